@@ -2,9 +2,15 @@ package main
 
 import (
     "context"
+    "encoding/json"
+    "errors"
+    "fmt"
     "log"
     "net/http"
     "os/signal"
+    "path/filepath"
+    "strings"
+    "sync"
     "syscall"
     "time"
 
@@ -14,11 +20,15 @@ import (
 
     "github.com/hiepdt/contest/services/api/internal/cache"
     "github.com/hiepdt/contest/services/api/internal/config"
+    "github.com/hiepdt/contest/services/api/internal/grounding"
     "github.com/hiepdt/contest/services/api/internal/llm"
     "github.com/hiepdt/contest/services/api/internal/httpserver"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/reembed"
     "github.com/hiepdt/contest/services/api/internal/retrieval"
     "github.com/hiepdt/contest/services/api/internal/storage"
     "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/upstream"
 )
 
 func main() {
@@ -29,11 +39,14 @@ func main() {
 
 func run() error {
     cfg := config.FromEnv()
+    hnswCfg := retrieval.HNSWConfig{M: cfg.HNSWM, EfConstruction: cfg.HNSWEfConstruction, EfSearch: cfg.HNSWEfSearch}
+    if cfg.VectorIndex == "hnsw" {
+        if err := hnswCfg.Validate(); err != nil { return fmt.Errorf("HNSW_M / HNSW_EF_CONSTRUCTION / HNSW_EF_SEARCH: %w", err) }
+    }
 
     // setup router
     r := chi.NewRouter()
     r.Use(middleware.RequestID)
-    r.Use(middleware.RealIP)
     r.Use(middleware.Logger)
     r.Use(middleware.Recoverer)
     r.Use(cors.Handler(cors.Options{
@@ -56,16 +69,143 @@ func run() error {
     db, err := storage.NewDatabase(ctx, cfg.PostgresURL)
     if err != nil { return err }
     if err := db.RunMigrations(ctx); err != nil { log.Println("migrate:", err) }
-    _ = cache.New(cfg.RedisAddr, cfg.RedisDB)
-    ollama := llm.NewOllama(cfg.OllamaHost, cfg.ModelName)
-    faiss := retrieval.NewFaiss(cfg.FaissHost)
+    rdb := cache.New(cfg.RedisAddr, cfg.RedisDB)
+    retry := upstream.Policy{MaxAttempts: cfg.UpstreamMaxAttempts, BaseDelay: cfg.UpstreamBackoffBase, MaxDelay: cfg.UpstreamBackoffMax}
+    ollama := llm.NewOllama(cfg.OllamaHost, cfg.ModelName).
+        WithEmbedBatching(cfg.EmbedBatchSize, cfg.EmbedConcurrency).
+        WithGuard(upstream.NewGuard("ollama", retry, cfg.BreakerFailures, cfg.BreakerCooldown))
+    breakers := []*upstream.Breaker{ollama.Breaker()}
+    // Each embedding model gets its own collection. pgvector is the durable
+    // index; FAISS or the in-process HNSW index is searched first and can be
+    // rebuilt from it.
+    repo := storage.NewRepository(db)
+    var faiss *retrieval.FaissClient
+    if cfg.VectorIndex == "faiss" {
+        faiss = retrieval.NewFaiss(cfg.FaissHost).WithGuard(upstream.NewGuard("faiss", retry, cfg.BreakerFailures, cfg.BreakerCooldown))
+        breakers = append(breakers, faiss.Breaker())
+    }
+    var hnswMu sync.Mutex
+    var hnswStores []*retrieval.HNSWStore
+    defer func() {
+        hnswMu.Lock()
+        defer hnswMu.Unlock()
+        for _, s := range hnswStores {
+            if err := s.Save(); err != nil { log.Println("hnsw snapshot:", err) }
+        }
+    }()
+    newCollection := func(ctx context.Context, model string) (*retrieval.Collection, error) {
+        want := 0
+        if model == cfg.EmbedModel { want = cfg.EmbedDim }
+        stored, err := openCollection(ctx, repo, ollama, model, want)
+        if err != nil { return nil, err }
+        col := &retrieval.Collection{Model: model, Dim: stored.Dim, Name: retrieval.CollectionName(model)}
+        col.Index = &retrieval.Composite{Durable: retrieval.NewPgvector(repo, stored)}
+        switch cfg.VectorIndex {
+        case "hnsw":
+            local, err := retrieval.OpenHNSWStore(filepath.Join(cfg.HNSWDir, col.Name), hnswCfg)
+            if err != nil { return nil, err }
+            hnswMu.Lock()
+            hnswStores = append(hnswStores, local)
+            hnswMu.Unlock()
+            go snapshotLoop(local, cfg.HNSWSnapshotEvery)
+            col.Index.Fast = append(col.Index.Fast, local)
+        case "faiss":
+            col.Faiss = faiss.For(col.Name)
+            col.Index.Fast = append(col.Index.Fast, col.Faiss)
+        }
+        log.Printf("embedding collection %s: %d dims", model, stored.Dim)
+        return col, nil
+    }
+    // The active model is persisted: once a migration flipped queries to a
+    // new model it stays active across restarts. EMBED_MODEL only seeds it.
+    active, err := repo.ActiveCollection(ctx)
+    if err != nil { return err }
+    if active == "" { active = cfg.EmbedModel }
+    collections := retrieval.NewRegistry(active)
+    for _, model := range append([]string{active, cfg.EmbedModel}, cfg.EmbedModelsSecondary...) {
+        if collections.Get(model) != nil { continue }
+        col, err := newCollection(ctx, model)
+        if err != nil { return err }
+        collections.Register(col)
+    }
+    if err := repo.ActivateCollection(ctx, active); err != nil { return err }
+    migrations := &reembed.Runner{Repo: repo, LLM: ollama, Collections: collections, Open: newCollection}
+    if err := migrations.Sync(ctx); err != nil { log.Println("embedding migration:", err) }
+    go migrations.Watch(ctx, cfg.CollectionSyncInterval)
+    // A changed EMBED_MODEL is migrated to in the background; queries keep
+    // using the old model until every chunk has a new vector.
+    if cfg.EmbedModel != active {
+        if m, err := migrations.Start(ctx, cfg.EmbedModel); err == nil {
+            log.Printf("EMBED_MODEL changed from %s: started embedding migration %d", active, m.ID)
+        } else if !errors.Is(err, storage.ErrMigrationRunning) {
+            log.Println("embedding migration:", err)
+        }
+    }
 
     // wire handlers
-    repo := storage.NewRepository(db)
+    caches := &httpserver.Caches{
+        Redis: rdb, EmbedTTL: cfg.CacheEmbedTTL, AnswerTTL: cfg.CacheAnswerTTL,
+        SemanticThreshold: cfg.SemanticCacheThreshold, SemanticMaxEntries: cfg.SemanticCacheMaxEntries,
+    }
+    ingestDeps := httpserver.IngestDeps{Repo: repo, LLM: ollama, Collections: collections, Caches: caches}
+    allowed := map[string]bool{}
+    for _, m := range cfg.AllowedModels { allowed[m] = true }
+    genDefaults := map[string]llm.GenOptions{}
+    for endpoint, raw := range cfg.GenOptions {
+        var o llm.GenOptions
+        if err := json.Unmarshal([]byte(raw), &o); err != nil { return fmt.Errorf("GEN_OPTIONS_%s: %w", strings.ToUpper(endpoint), err) }
+        if err := o.Validate(); err != nil { return fmt.Errorf("GEN_OPTIONS_%s: %w", strings.ToUpper(endpoint), err) }
+        genDefaults[endpoint] = o
+    }
+    // Prompt templates: built-in v1, then PROMPT_DIR, then Postgres.
+    promptReg := &prompts.Registry{Dir: cfg.PromptDir, Repo: repo, Defaults: cfg.PromptVersions}
+    if err := promptReg.Reload(ctx); err != nil { return err }
+    verifier := &grounding.Verifier{
+        LLM: ollama, Prompts: promptReg, Model: cfg.GroundingModel,
+        Threshold: cfg.GroundingThreshold, MinRetrievalScore: float32(cfg.GroundingMinRetrievalScore),
+    }
+    if cfg.GroundingCalibration != "" {
+        if verifier.Calibration, err = grounding.LoadCalibration(cfg.GroundingCalibration); err != nil { return fmt.Errorf("GROUNDING_CALIBRATION: %w", err) }
+    }
+    proxies, err := httpserver.ParseCIDRs(cfg.TrustedProxies)
+    if err != nil { return fmt.Errorf("TRUSTED_PROXIES: %w", err) }
+    limits := &httpserver.Limits{
+        Cache:            rdb,
+        RatePerSec:       cfg.RateLimitRPS,
+        Burst:            cfg.RateLimitBurst,
+        MaxConcurrentLLM: cfg.LLMMaxConcurrent,
+        LLMLease:         200 * time.Second, // > longest handler timeout (agent, 180s)
+        LLMRetryAfter:    cfg.LLMRetryAfter,
+        TrustedProxies:   proxies,
+    }
+    qaDeps := httpserver.QASumDeps{Repo: repo, LLM: ollama, GenModel: cfg.ModelName, AllowedModels: allowed, GenDefaults: genDefaults, Prompts: promptReg, Collections: collections, Grounding: verifier, CalculatorRounds: cfg.CalculatorRounds, AgentMaxSteps: cfg.AgentMaxSteps, Caches: caches, Limits: limits}
+    adminDeps := httpserver.AdminDeps{Repo: repo, Collections: collections, Migrations: migrations, Prompts: promptReg}
     api := &httpserver.API{
-        IngestHandler:    httpserver.MakeIngestHandler(httpserver.IngestDeps{Repo: repo, LLM: ollama, EmbedModel: cfg.EmbedModel, Faiss: faiss}),
-        SummarizeHandler: httpserver.MakeSummarizeHandler(httpserver.QASumDeps{Repo: repo, LLM: ollama, EmbedModel: cfg.EmbedModel, GenModel: cfg.ModelName, Faiss: faiss}),
-        QAHandler:        httpserver.MakeQAHandler(httpserver.QASumDeps{Repo: repo, LLM: ollama, EmbedModel: cfg.EmbedModel, GenModel: cfg.ModelName, Faiss: faiss}),
+        IngestHandler:    httpserver.MakeIngestHandler(ingestDeps),
+        SummarizeHandler: httpserver.MakeSummarizeHandler(qaDeps),
+        QAHandler:        httpserver.MakeQAHandler(qaDeps),
+        DeleteDocumentHandler: httpserver.MakeDeleteDocumentHandler(ingestDeps),
+        ListTablesHandler:     httpserver.MakeListTablesHandler(ingestDeps),
+        ListMetricsHandler:    httpserver.MakeListMetricsHandler(qaDeps),
+        ExtractMetricsHandler: httpserver.MakeExtractMetricsHandler(qaDeps),
+        CreateConversationHandler:  httpserver.MakeCreateConversationHandler(qaDeps),
+        ListMessagesHandler:        httpserver.MakeListMessagesHandler(qaDeps),
+        ConversationMessageHandler: httpserver.MakeConversationMessageHandler(qaDeps),
+        ReindexHandler:             httpserver.MakeReindexHandler(adminDeps),
+        IndexStatsHandler:          httpserver.MakeIndexStatsHandler(adminDeps),
+        TrainIndexHandler:          httpserver.MakeTrainIndexHandler(adminDeps),
+        RecallBenchmarkHandler:     httpserver.MakeRecallBenchmarkHandler(adminDeps),
+        StartEmbeddingMigrationHandler:  httpserver.MakeStartEmbeddingMigrationHandler(adminDeps),
+        ListEmbeddingMigrationsHandler:  httpserver.MakeListEmbeddingMigrationsHandler(adminDeps),
+        CancelEmbeddingMigrationHandler: httpserver.MakeCancelEmbeddingMigrationHandler(adminDeps),
+        ListPromptsHandler:              httpserver.MakeListPromptsHandler(adminDeps),
+        SavePromptHandler:               httpserver.MakeSavePromptHandler(adminDeps),
+        ReloadPromptsHandler:            httpserver.MakeReloadPromptsHandler(adminDeps),
+        ReadyHandler:               httpserver.MakeReadyHandler(httpserver.ReadyDeps{DB: db, Breakers: breakers}),
+        AdminKey:                   cfg.AdminKey,
+        APIKeys:          cfg.APIKeys,
+        DefaultTenant:    cfg.DefaultTenant,
+        Limits:           limits,
     }
     r.Mount("/", httpserver.NewRouter(api))
 
@@ -101,3 +241,25 @@ func run() error {
 }
 
 
+// openCollection probes model's embedding size and registers it in Postgres.
+// A model whose size changed since its vectors were stored, or that differs
+// from an explicit want, is a configuration error: mixing sizes breaks search.
+func openCollection(ctx context.Context, repo *storage.Repository, ollama *llm.OllamaClient, model string, want int) (storage.Collection, error) {
+    probe, err := ollama.Embeddings(ctx, model, []string{"dimension probe"})
+    if err != nil { return storage.Collection{}, fmt.Errorf("probe embedding model %s: %w", model, err) }
+    if len(probe) == 0 || len(probe[0]) == 0 { return storage.Collection{}, fmt.Errorf("embedding model %s returned no vector", model) }
+    dim := len(probe[0])
+    if want > 0 && want != dim { return storage.Collection{}, fmt.Errorf("EMBED_DIM=%d but %s returns %d dimensions", want, model, dim) }
+    return repo.EnsureCollection(ctx, model, dim)
+}
+
+// snapshotLoop persists changed HNSW indexes periodically so a crash loses at
+// most one interval of writes.
+func snapshotLoop(s *retrieval.HNSWStore, every time.Duration) {
+    if every <= 0 { return }
+    t := time.NewTicker(every)
+    defer t.Stop()
+    for range t.C {
+        if err := s.Save(); err != nil { log.Println("hnsw snapshot:", err) }
+    }
+}
@@ -0,0 +1,92 @@
+// Command calibrate-grounding fits the mapping from the grounding verifier's
+// raw support score to the probability that a sentence is supported.
+//
+// It reads JSON lines of hand-labelled answer sentences, held out from
+// anything used to write prompts:
+//
+//    {"passages":[{"id":3,"content":"..."}],"sentence":"...","supported":true}
+//
+// scores each sentence with the same model and verify prompt as the API
+// (OLLAMA_HOST, GROUNDING_MODEL or MODEL_NAME, PROMPT_DIR, PROMPT_VERSIONS)
+// and writes the fitted Calibration as JSON, for GROUNDING_CALIBRATION.
+// Refit it whenever the model or the verify prompt changes.
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "math"
+    "os"
+
+    "github.com/hiepdt/contest/services/api/internal/config"
+    "github.com/hiepdt/contest/services/api/internal/grounding"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+)
+
+type example struct {
+    Passages  []grounding.Passage `json:"passages"`
+    Sentence  string              `json:"sentence"`
+    Supported bool                `json:"supported"`
+}
+
+func main() {
+    out := flag.String("out", "grounding_calibration.json", "where to write the calibration")
+    flag.Parse()
+    if flag.NArg() != 1 { log.Fatal("usage: calibrate-grounding [-out file] labelled.jsonl") }
+    if err := run(flag.Arg(0), *out); err != nil { log.Fatal(err) }
+}
+
+func run(in, out string) error {
+    cfg := config.FromEnv()
+    ctx := context.Background()
+    reg := &prompts.Registry{Dir: cfg.PromptDir, Defaults: cfg.PromptVersions}
+    if err := reg.Reload(ctx); err != nil { return err }
+    model := cfg.GroundingModel
+    if model == "" { model = cfg.ModelName }
+    v := &grounding.Verifier{LLM: llm.NewOllama(cfg.OllamaHost, model), Prompts: reg, Model: model}
+
+    f, err := os.Open(in)
+    if err != nil { return err }
+    defer f.Close()
+    var (
+        scores    []float64
+        supported []bool
+    )
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 1<<20), 16<<20)
+    for line := 1; sc.Scan(); line++ {
+        if len(sc.Bytes()) == 0 { continue }
+        var ex example
+        if err := json.Unmarshal(sc.Bytes(), &ex); err != nil { return fmt.Errorf("%s:%d: %w", in, line, err) }
+        if len(ex.Passages) == 0 || ex.Sentence == "" { return fmt.Errorf("%s:%d: passages and sentence are required", in, line) }
+        _, raw, err := v.Check(ctx, model, ex.Passages, ex.Sentence)
+        if err != nil { return fmt.Errorf("%s:%d: %w", in, line, err) }
+        scores = append(scores, raw)
+        supported = append(supported, ex.Supported)
+    }
+    if err := sc.Err(); err != nil { return err }
+
+    c, err := grounding.Fit(scores, supported)
+    if err != nil { return err }
+    log.Printf("fitted on %d sentences: a=%.4f b=%.4f; Brier score raw %.4f, calibrated %.4f",
+        c.N, c.A, c.B, brier(nil, scores, supported), brier(c, scores, supported))
+    body, err := json.MarshalIndent(c, "", "  ")
+    if err != nil { return err }
+    return os.WriteFile(out, append(body, '\n'), 0o644)
+}
+
+// brier is the mean squared error of the probabilities c gives scores.
+func brier(c *grounding.Calibration, scores []float64, supported []bool) float64 {
+    var sum float64
+    for i, s := range scores {
+        y := 0.0
+        if supported[i] { y = 1 }
+        sum += math.Pow(c.Apply(s)-y, 2)
+    }
+    return sum / float64(len(scores))
+}
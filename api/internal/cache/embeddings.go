@@ -0,0 +1,59 @@
+package cache
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "math"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// EmbeddingKey identifies a vector by model and a hash of the exact text.
+func EmbeddingKey(model, text string) string {
+    sum := sha256.Sum256([]byte(text))
+    return "emb:" + model + ":" + hex.EncodeToString(sum[:])
+}
+
+// GetEmbeddings returns cached vectors aligned with texts (nil where missing)
+// and the indexes of texts that still need embedding.
+func (c *Cache) GetEmbeddings(ctx context.Context, model string, texts []string) ([][]float32, []int, error) {
+    out := make([][]float32, len(texts))
+    missing := make([]int, 0, len(texts))
+    if len(texts) == 0 { return out, missing, nil }
+    keys := make([]string, len(texts))
+    for i, t := range texts { keys[i] = EmbeddingKey(model, t) }
+    vals, err := c.Client.MGet(ctx, keys...).Result()
+    if err != nil { return nil, nil, err }
+    for i, v := range vals {
+        s, ok := v.(string)
+        if !ok || len(s) == 0 || len(s)%4 != 0 { missing = append(missing, i); continue }
+        out[i] = decodeVector([]byte(s))
+    }
+    return out, missing, nil
+}
+
+func (c *Cache) SetEmbeddings(ctx context.Context, model string, texts []string, vecs [][]float32, ttl time.Duration) error {
+    _, err := c.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+        for i, t := range texts {
+            if i >= len(vecs) || len(vecs[i]) == 0 { continue }
+            p.Set(ctx, EmbeddingKey(model, t), encodeVector(vecs[i]), ttl)
+        }
+        return nil
+    })
+    return err
+}
+
+func encodeVector(v []float32) []byte {
+    b := make([]byte, 4*len(v))
+    for i, f := range v { binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f)) }
+    return b
+}
+
+func decodeVector(b []byte) []float32 {
+    v := make([]float32, len(b)/4)
+    for i := range v { v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])) }
+    return v
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+    "context"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// tokenBucket refills at ARGV[1] tokens/s up to ARGV[2] and takes one token.
+// Returns {allowed, retry_after_ms}. Redis TIME is used so all API replicas
+// share one clock.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+    tokens = tokens - 1
+    allowed = 1
+else
+    wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+// Allow takes one token from the bucket at key. When the bucket is empty it
+// reports how long until the next token is available.
+func (c *Cache) Allow(ctx context.Context, key string, ratePerSec float64, burst int) (bool, time.Duration, error) {
+    res, err := tokenBucket.Run(ctx, c.Client, []string{key}, ratePerSec, burst).Int64Slice()
+    if err != nil { return false, 0, err }
+    return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// semAcquire is a lease-based counting semaphore: members of the sorted set
+// are holders scored by lease expiry, so a crashed replica cannot leak slots.
+var semAcquire = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[1]) then
+    redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+    redis.call('PEXPIRE', KEYS[1], ARGV[2])
+    return 1
+end
+return 0
+`)
+
+// Acquire tries to take one of limit slots of the semaphore at key for holder.
+// The slot is released by Release or when lease expires.
+func (c *Cache) Acquire(ctx context.Context, key, holder string, limit int, lease time.Duration) (bool, error) {
+    ok, err := semAcquire.Run(ctx, c.Client, []string{key}, limit, lease.Milliseconds(), holder).Int()
+    return ok == 1, err
+}
+
+func (c *Cache) Release(ctx context.Context, key, holder string) error {
+    return c.Client.ZRem(ctx, key, holder).Err()
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
     "context"
+    "strconv"
     "time"
 
     "github.com/redis/go-redis/v9"
@@ -24,3 +25,27 @@ func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
 }
 
 
+
+func (c *Cache) Del(ctx context.Context, keys ...string) error {
+    return c.Client.Del(ctx, keys...).Err()
+}
+
+// Bump increments version counters used to build cache keys; bumping a
+// version makes every key derived from the old value unreachable.
+func (c *Cache) Bump(ctx context.Context, keys ...string) error {
+    pipe := c.Client.TxPipeline()
+    for _, k := range keys { pipe.Incr(ctx, k) }
+    _, err := pipe.Exec(ctx)
+    return err
+}
+
+// Versions returns the counters at keys; missing keys are 0.
+func (c *Cache) Versions(ctx context.Context, keys ...string) ([]int64, error) {
+    vals, err := c.Client.MGet(ctx, keys...).Result()
+    if err != nil { return nil, err }
+    out := make([]int64, len(vals))
+    for i, v := range vals {
+        if s, ok := v.(string); ok { out[i], _ = strconv.ParseInt(s, 10, 64) }
+    }
+    return out, nil
+}
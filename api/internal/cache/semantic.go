@@ -0,0 +1,64 @@
+package cache
+
+import (
+    "context"
+    "encoding/json"
+    "math"
+    "time"
+)
+
+// SemanticEntry is one answered question in a semantic cache scope.
+type SemanticEntry struct {
+    Question string          `json:"q"`
+    Vector   []byte          `json:"v"` // little-endian float32, see encodeVector
+    Version  int64           `json:"ver"`
+    Response json.RawMessage `json:"r"`
+}
+
+// SemanticHit is the closest cached entry at or above the threshold.
+type SemanticHit struct {
+    Question   string
+    Similarity float64
+    Response   json.RawMessage
+}
+
+// SemanticLookup scans the scope at key for the entry most similar (cosine)
+// to vec. Entries recorded under another document version are ignored.
+func (c *Cache) SemanticLookup(ctx context.Context, key string, vec []float32, version int64, threshold float64) (*SemanticHit, error) {
+    raw, err := c.Client.LRange(ctx, key, 0, -1).Result()
+    if err != nil { return nil, err }
+    var best *SemanticHit
+    for _, s := range raw {
+        var e SemanticEntry
+        if json.Unmarshal([]byte(s), &e) != nil || e.Version != version { continue }
+        sim := cosine(vec, decodeVector(e.Vector))
+        if sim >= threshold && (best == nil || sim > best.Similarity) {
+            best = &SemanticHit{Question: e.Question, Similarity: sim, Response: e.Response}
+        }
+    }
+    return best, nil
+}
+
+// SemanticStore prepends an entry, keeping at most maxEntries per scope.
+func (c *Cache) SemanticStore(ctx context.Context, key, question string, vec []float32, version int64, response []byte, maxEntries int, ttl time.Duration) error {
+    b, err := json.Marshal(SemanticEntry{Question: question, Vector: encodeVector(vec), Version: version, Response: response})
+    if err != nil { return err }
+    pipe := c.Client.TxPipeline()
+    pipe.LPush(ctx, key, b)
+    pipe.LTrim(ctx, key, 0, int64(maxEntries-1))
+    pipe.Expire(ctx, key, ttl)
+    _, err = pipe.Exec(ctx)
+    return err
+}
+
+func cosine(a, b []float32) float64 {
+    if len(a) != len(b) || len(a) == 0 { return 0 }
+    var dot, na, nb float64
+    for i := range a {
+        dot += float64(a[i]) * float64(b[i])
+        na += float64(a[i]) * float64(a[i])
+        nb += float64(b[i]) * float64(b[i])
+    }
+    if na == 0 || nb == 0 { return 0 }
+    return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,205 @@
+// Package calc is the calculator the model calls for arithmetic on figures
+// taken from retrieved chunks (growth, CAGR, ratios, sums), so answers do
+// not depend on the model doing arithmetic. Every call is recorded as a
+// Step for the response.
+package calc
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+
+    "github.com/hiepdt/contest/services/api/internal/finance"
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+)
+
+// Step is one calculation: the tool, its arguments as sent by the model,
+// the result and the formula with the numbers filled in. ChunkIDs are the
+// chunks the model took the inputs from; Unsourced lists inputs not found
+// in any of them (figures the model made up or computed itself).
+type Step struct {
+    Tool       string          `json:"tool"`
+    Arguments  json.RawMessage `json:"arguments"`
+    Result     *float64        `json:"result,omitempty"`
+    Unit       string          `json:"unit,omitempty"`
+    Expression string          `json:"expression,omitempty"`
+    ChunkIDs   []int64         `json:"chunk_ids,omitempty"`
+    Unsourced  []float64       `json:"unsourced_inputs,omitempty"`
+    Error      string          `json:"error,omitempty"`
+}
+
+// Calculator runs tool calls. Sources holds the content of the chunks the
+// model was given, by id; their numbers are read in the language detected
+// in each, else in Language.
+type Calculator struct {
+    Sources  map[int64]string
+    Language string
+}
+
+type args struct {
+    From        float64   `json:"from"`
+    To          float64   `json:"to"`
+    Start       float64   `json:"start"`
+    End         float64   `json:"end"`
+    Years       float64   `json:"years"`
+    Numerator   float64   `json:"numerator"`
+    Denominator float64   `json:"denominator"`
+    AsPercent   bool      `json:"as_percent"`
+    A           float64   `json:"a"`
+    B           float64   `json:"b"`
+    Values      []float64 `json:"values"`
+    ChunkIDs    []int64   `json:"chunk_ids"`
+}
+
+type tool struct {
+    description string
+    params      map[string]*llm.Schema
+    required    []string
+    // run returns the result, its unit, the formula and the inputs.
+    run func(a args) (float64, string, string, []float64, error)
+}
+
+var (
+    number  = &llm.Schema{Type: "number"}
+    sources = &llm.Schema{Type: "array", Items: &llm.Schema{Type: "integer"}, Description: "id [#id] của các đoạn chứa số liệu đầu vào"}
+)
+
+var tools = map[string]tool{
+    "percent_change": {
+        description: "Tăng trưởng / thay đổi phần trăm từ from đến to, ví dụ tăng trưởng doanh thu so với cùng kỳ (YoY).",
+        params:      map[string]*llm.Schema{"from": number, "to": number},
+        required:    []string{"from", "to"},
+        run: func(a args) (float64, string, string, []float64, error) {
+            if a.From == 0 { return 0, "", "", nil, errors.New("from bằng 0") }
+            r := (a.To - a.From) / math.Abs(a.From) * 100
+            return r, "%", fmt.Sprintf("(%s − %s) / |%s| × 100", num(a.To), num(a.From), num(a.From)), []float64{a.From, a.To}, nil
+        },
+    },
+    "cagr": {
+        description: "Tốc độ tăng trưởng kép hằng năm (CAGR) từ start đến end trong years năm.",
+        params:      map[string]*llm.Schema{"start": number, "end": number, "years": number},
+        required:    []string{"start", "end", "years"},
+        run: func(a args) (float64, string, string, []float64, error) {
+            if a.Start <= 0 || a.End <= 0 || a.Years <= 0 { return 0, "", "", nil, errors.New("start, end và years phải dương") }
+            r := (math.Pow(a.End/a.Start, 1/a.Years) - 1) * 100
+            return r, "%", fmt.Sprintf("((%s / %s)^(1/%s) − 1) × 100", num(a.End), num(a.Start), num(a.Years)), []float64{a.Start, a.End}, nil
+        },
+    },
+    "ratio": {
+        description: "Tỷ lệ numerator / denominator, ví dụ biên lợi nhuận hoặc nợ / vốn chủ sở hữu; as_percent để nhân 100.",
+        params:      map[string]*llm.Schema{"numerator": number, "denominator": number, "as_percent": {Type: "boolean"}},
+        required:    []string{"numerator", "denominator"},
+        run: func(a args) (float64, string, string, []float64, error) {
+            if a.Denominator == 0 { return 0, "", "", nil, errors.New("denominator bằng 0") }
+            r, expr := a.Numerator/a.Denominator, num(a.Numerator)+" / "+num(a.Denominator)
+            if a.AsPercent { return r * 100, "%", expr + " × 100", []float64{a.Numerator, a.Denominator}, nil }
+            return r, "", expr, []float64{a.Numerator, a.Denominator}, nil
+        },
+    },
+    "sum": {
+        description: "Tổng các giá trị, ví dụ cộng doanh thu các quý.",
+        params:      map[string]*llm.Schema{"values": {Type: "array", Items: number}},
+        required:    []string{"values"},
+        run: func(a args) (float64, string, string, []float64, error) {
+            if len(a.Values) == 0 { return 0, "", "", nil, errors.New("values rỗng") }
+            r, terms := 0.0, make([]string, len(a.Values))
+            for i, v := range a.Values { r += v; terms[i] = num(v) }
+            return r, "", strings.Join(terms, " + "), a.Values, nil
+        },
+    },
+    "difference": {
+        description: "Hiệu a − b, ví dụ chênh lệch biên lợi nhuận (điểm %) giữa hai kỳ.",
+        params:      map[string]*llm.Schema{"a": number, "b": number},
+        required:    []string{"a", "b"},
+        run: func(a args) (float64, string, string, []float64, error) {
+            return a.A - a.B, "", num(a.A) + " − " + num(a.B), []float64{a.A, a.B}, nil
+        },
+    },
+}
+
+// schema is the tool's parameters; every tool also takes the chunk_ids its
+// inputs come from.
+func (t tool) schema() *llm.Schema {
+    params := map[string]*llm.Schema{"chunk_ids": sources}
+    for k, v := range t.params { params[k] = v }
+    return &llm.Schema{Type: "object", Properties: params, Required: append(append([]string{}, t.required...), "chunk_ids")}
+}
+
+// Tools describes the calculator to the model, one tool per operation.
+func Tools() []llm.Tool {
+    var out []llm.Tool
+    for _, name := range []string{"percent_change", "cagr", "ratio", "sum", "difference"} {
+        t := tools[name]
+        out = append(out, llm.Tool{Type: "function", Function: llm.ToolFunction{Name: name, Description: t.description, Parameters: t.schema()}})
+    }
+    return out
+}
+
+// Known reports whether name is one of the calculator's tools.
+func Known(name string) bool { _, ok := tools[name]; return ok }
+
+// Call runs one tool call. Errors (unknown tool, bad arguments, division
+// by zero) are recorded in the step for the model to see, not returned.
+func (c Calculator) Call(call llm.ToolCall) Step {
+    s := Step{Tool: call.Function.Name, Arguments: call.Function.Arguments}
+    t, ok := tools[s.Tool]
+    if !ok { s.Error = "không có công cụ " + s.Tool; return s }
+    var raw any
+    if err := json.Unmarshal(call.Function.Arguments, &raw); err != nil { s.Error = "arguments không phải JSON: " + err.Error(); return s }
+    if err := t.schema().Validate(raw); err != nil { s.Error = err.Error(); return s }
+    var a args
+    _ = json.Unmarshal(call.Function.Arguments, &a)
+    r, unit, expr, inputs, err := t.run(a)
+    if err != nil { s.Error = err.Error(); return s }
+    r = round(r)
+    s.Result, s.Unit, s.ChunkIDs = &r, unit, a.ChunkIDs
+    s.Expression = expr + " = " + num(r) + unit
+    s.Unsourced = c.unsourced(inputs, a.ChunkIDs)
+    return s
+}
+
+// Reply is the tool message answering a step.
+func (s Step) Reply() string {
+    var b []byte
+    if s.Error != "" {
+        b, _ = json.Marshal(map[string]string{"error": s.Error})
+    } else {
+        b, _ = json.Marshal(map[string]any{"result": *s.Result, "unit": s.Unit, "expression": s.Expression})
+    }
+    return string(b)
+}
+
+// unsourced returns the inputs that appear in none of the cited chunks,
+// allowing for the model rescaling them (1.234 tỷ as 1234000000000) or
+// writing a percentage as a fraction.
+func (c Calculator) unsourced(inputs []float64, ids []int64) []float64 {
+    var found []float64
+    for _, id := range ids {
+        if text, ok := c.Sources[id]; ok { found = append(found, finance.Numbers(text, lang.Or(lang.Detect(text), c.Language))...) }
+    }
+    var out []float64
+    for _, x := range inputs {
+        if !appears(x, found) { out = append(out, x) }
+    }
+    return out
+}
+
+var scales = []float64{1, 1e3, 1e6, 1e9, 1e12, 1e-2, 1e-3, 1e-6, 1e-9}
+
+func appears(x float64, found []float64) bool {
+    x = math.Abs(x)
+    for _, n := range found {
+        for _, s := range scales {
+            if math.Abs(x-n*s) <= 1e-6*math.Max(1e-9, x) { return true }
+        }
+    }
+    return false
+}
+
+func round(x float64) float64 { return math.Round(x*1e4) / 1e4 }
+
+func num(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
@@ -0,0 +1,101 @@
+package calc
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+)
+
+func toolCall(name, arguments string) llm.ToolCall {
+    var c llm.ToolCall
+    c.Function.Name, c.Function.Arguments = name, json.RawMessage(arguments)
+    return c
+}
+
+func TestCall(t *testing.T) {
+    c := Calculator{Language: lang.Vietnamese}
+    tests := []struct {
+        name, tool, args string
+        result           float64
+        unit, expression string
+        err              string
+    }{
+        {"growth", "percent_change", `{"from":100,"to":112,"chunk_ids":[]}`, 12, "%", "(112 − 100) / |100| × 100 = 12%", ""},
+        {"growth from a loss", "percent_change", `{"from":-50,"to":25,"chunk_ids":[]}`, 150, "%", "", ""},
+        {"cagr", "cagr", `{"start":100,"end":121,"years":2,"chunk_ids":[]}`, 10, "%", "", ""},
+        {"ratio", "ratio", `{"numerator":1,"denominator":3,"chunk_ids":[]}`, 0.3333, "", "1 / 3 = 0.3333", ""},
+        {"ratio as percent", "ratio", `{"numerator":25,"denominator":200,"as_percent":true,"chunk_ids":[]}`, 12.5, "%", "", ""},
+        {"sum", "sum", `{"values":[1.5,2.5,3],"chunk_ids":[]}`, 7, "", "1.5 + 2.5 + 3 = 7", ""},
+        {"difference", "difference", `{"a":22.4,"b":20.6,"chunk_ids":[]}`, 1.8, "", "", ""},
+        {"division by zero", "ratio", `{"numerator":1,"denominator":0,"chunk_ids":[]}`, 0, "", "", "denominator bằng 0"},
+        {"cagr of a loss", "cagr", `{"start":-1,"end":2,"years":1,"chunk_ids":[]}`, 0, "", "", "phải dương"},
+        {"missing argument", "percent_change", `{"from":100,"chunk_ids":[]}`, 0, "", "", "to"},
+        {"not json", "sum", `{"values":`, 0, "", "", "arguments không phải JSON"},
+        {"unknown tool", "sqrt", `{}`, 0, "", "", "không có công cụ sqrt"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := c.Call(toolCall(tt.tool, tt.args))
+            if tt.err != "" {
+                if s.Result != nil || !strings.Contains(s.Error, tt.err) { t.Errorf("step = %+v, want error containing %q", s, tt.err) }
+                if !strings.Contains(s.Reply(), `"error"`) { t.Errorf("Reply() = %s, want an error", s.Reply()) }
+                return
+            }
+            if s.Error != "" || s.Result == nil { t.Fatalf("step = %+v, want a result", s) }
+            if *s.Result != tt.result || s.Unit != tt.unit { t.Errorf("result = %v%s, want %v%s", *s.Result, s.Unit, tt.result, tt.unit) }
+            if tt.expression != "" && s.Expression != tt.expression { t.Errorf("expression = %q, want %q", s.Expression, tt.expression) }
+        })
+    }
+}
+
+func TestUnsourced(t *testing.T) {
+    c := Calculator{Language: lang.Vietnamese, Sources: map[int64]string{
+        1: "Doanh thu thuần năm 2023 đạt 1.234,5 tỷ đồng, năm 2022 là 1.097 tỷ đồng.",
+        2: "Biên lợi nhuận gộp đạt 12,5%.",
+        3: "Net revenue was 1,234 million USD in 2023.",
+    }}
+    tests := []struct {
+        name   string
+        inputs []float64
+        ids    []int64
+        want   []float64
+    }{
+        {"as written", []float64{1234.5, 1097}, []int64{1}, nil},
+        {"rescaled to đồng", []float64{1234500000000, 1097000000000}, []int64{1}, nil},
+        {"rescaled to triệu", []float64{1234500, 1097000}, []int64{1}, nil},
+        {"negative", []float64{-1097}, []int64{1}, nil},
+        {"percent as fraction", []float64{0.125}, []int64{2}, nil},
+        {"english thousands", []float64{1234, 1234000000}, []int64{3}, nil},
+        {"invented", []float64{1234.5, 999}, []int64{1}, []float64{999}},
+        {"nghìn tỷ", []float64{1.2345}, []int64{1}, nil},
+        {"shifted by ten", []float64{123.45}, []int64{1}, []float64{123.45}},
+        {"near but not equal", []float64{1234.6}, []int64{1}, []float64{1234.6}},
+        {"wrong chunk", []float64{12.5}, []int64{1}, []float64{12.5}},
+        {"unknown chunk", []float64{1234.5}, []int64{9}, []float64{1234.5}},
+        {"no chunks cited", []float64{1234.5}, nil, []float64{1234.5}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := c.unsourced(tt.inputs, tt.ids); !reflect.DeepEqual(got, tt.want) { t.Errorf("unsourced(%v, %v) = %v, want %v", tt.inputs, tt.ids, got, tt.want) }
+        })
+    }
+}
+
+func TestCallRecordsSources(t *testing.T) {
+    c := Calculator{Language: lang.Vietnamese, Sources: map[int64]string{4: "Lợi nhuận sau thuế quý 2/2024 đạt 250 tỷ đồng, cùng kỳ 200 tỷ đồng."}}
+    s := c.Call(toolCall("percent_change", `{"from":200,"to":260,"chunk_ids":[4]}`))
+    if s.Result == nil || *s.Result != 30 { t.Fatalf("step = %+v, want result 30", s) }
+    if !reflect.DeepEqual(s.ChunkIDs, []int64{4}) || !reflect.DeepEqual(s.Unsourced, []float64{260}) { t.Errorf("chunk_ids = %v, unsourced = %v, want [4] and [260]", s.ChunkIDs, s.Unsourced) }
+}
+
+func TestTools(t *testing.T) {
+    for _, tool := range Tools() {
+        if !Known(tool.Function.Name) { t.Errorf("tool %s is not Known", tool.Function.Name) }
+        if req := tool.Function.Parameters.Required; req[len(req)-1] != "chunk_ids" { t.Errorf("tool %s does not require chunk_ids", tool.Function.Name) }
+    }
+    if len(Tools()) != len(tools) { t.Errorf("Tools() lists %d of %d tools", len(Tools()), len(tools)) }
+}
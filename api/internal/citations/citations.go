@@ -0,0 +1,206 @@
+// Package citations turns retrieved chunks and the [#id] markers of an
+// answer into citation entries a UI can link and highlight.
+package citations
+
+import (
+    "regexp"
+    "strconv"
+    "strings"
+    "unicode"
+
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// MarkerPattern matches [#12], [#12, #13] and [#12,13].
+var MarkerPattern = regexp.MustCompile(`\[#\s*\d+(?:\s*,\s*#?\s*\d+)*\s*\]`)
+
+var digits = regexp.MustCompile(`\d+`)
+
+// ParseIDs returns the ids cited in text, in order of appearance.
+func ParseIDs(text string) []int64 {
+    var ids []int64
+    for _, m := range MarkerPattern.FindAllString(text, -1) {
+        for _, d := range digits.FindAllString(m, -1) {
+            if id, err := strconv.ParseInt(d, 10, 64); err == nil { ids = append(ids, id) }
+        }
+    }
+    return ids
+}
+
+// Span is a [Start, End) range of characters (Unicode code points).
+type Span struct {
+    Start int `json:"start"`
+    End   int `json:"end"`
+}
+
+// Highlight is a sentence of a chunk that supports the answer. Span is its
+// position in the chunk's content; DocumentSpan in the whole document,
+// when the chunk was ingested with offsets.
+type Highlight struct {
+    Text         string `json:"text"`
+    Span         Span   `json:"span"`
+    DocumentSpan *Span  `json:"document_span,omitempty"`
+}
+
+// Citation is a retrieved chunk as returned to clients. ID is the number
+// in the answer's [#id] markers; Cited says whether the answer uses it.
+type Citation struct {
+    ID         int64       `json:"id"`
+    DocumentID string      `json:"document_id"`
+    Title      string      `json:"title,omitempty"`
+    Page       int         `json:"page,omitempty"`
+    Span       *Span       `json:"span,omitempty"`
+    Content    string      `json:"content"`
+    Score      float32     `json:"score"`
+    Cited      bool        `json:"cited"`
+    Sentences  []Highlight `json:"sentences,omitempty"`
+}
+
+// Marker is one [#id] marker of the answer: its text, position in the
+// answer, the ids it names and the indexes of their entries in the
+// citation list (ids that were not retrieved have none).
+type Marker struct {
+    Text      string  `json:"text"`
+    Span      Span    `json:"span"`
+    IDs       []int64 `json:"ids"`
+    Citations []int   `json:"citations"`
+}
+
+// Build lists hits as citations, in retrieval order, and maps the answer's
+// markers to them. For each cited chunk the sentences closest to the answer
+// sentences citing it are highlighted.
+func Build(answer string, hits []storage.Hit) ([]Citation, []Marker) {
+    cites := make([]Citation, len(hits))
+    index := make(map[int64]int, len(hits))
+    for i, h := range hits {
+        cites[i] = fromHit(h)
+        index[h.ID] = i
+    }
+    markers := []Marker{}
+    runes := []rune(answer)
+    sentences := segment(runes)
+    // claims collects, per citation, the answer sentences citing it.
+    claims := make([][]string, len(cites))
+    for _, loc := range MarkerPattern.FindAllStringIndex(answer, -1) {
+        start := len([]rune(answer[:loc[0]]))
+        text := answer[loc[0]:loc[1]]
+        m := Marker{Text: text, Span: Span{start, start + len([]rune(text))}, IDs: ParseIDs(text), Citations: []int{}}
+        claim := claimFor(runes, sentences, start)
+        for _, id := range m.IDs {
+            i, ok := index[id]
+            if !ok { continue }
+            m.Citations = append(m.Citations, i)
+            cites[i].Cited = true
+            if claim != "" { claims[i] = append(claims[i], claim) }
+        }
+        markers = append(markers, m)
+    }
+    for i := range cites {
+        for _, claim := range claims[i] { cites[i].Sentences = addHighlight(cites[i].Sentences, bestSentence(cites[i], claim)) }
+    }
+    return cites, markers
+}
+
+// Single is the citation of one hit backing claim, with the chunk sentence
+// closest to claim highlighted.
+func Single(h storage.Hit, claim string) Citation {
+    c := fromHit(h)
+    c.Cited = true
+    c.Sentences = addHighlight(nil, bestSentence(c, claim))
+    return c
+}
+
+func fromHit(h storage.Hit) Citation {
+    return Citation{ID: h.ID, DocumentID: h.DocID, Title: h.Title, Page: h.Page, Span: parseSpan(h.Span), Content: h.Content, Score: h.Score}
+}
+
+// claimFor is the answer sentence a marker at pos refers to, without
+// markers: the sentence containing it, or the previous one when the marker
+// opens a sentence ("... tăng 12%. [#3]").
+func claimFor(runes []rune, sentences []Span, pos int) string {
+    for i, s := range sentences {
+        if pos < s.Start || pos >= s.End { continue }
+        text := strings.TrimSpace(MarkerPattern.ReplaceAllString(string(runes[s.Start:pos]), ""))
+        if strings.IndexFunc(text, unicode.IsLetter) < 0 && i > 0 {
+            p := sentences[i-1]
+            text = strings.TrimSpace(MarkerPattern.ReplaceAllString(string(runes[p.Start:p.End]), ""))
+        }
+        return text
+    }
+    return ""
+}
+
+// bestSentence picks the chunk sentence sharing the most words with claim.
+func bestSentence(c Citation, claim string) *Highlight {
+    want := words(claim)
+    runes := []rune(c.Content)
+    var best *Highlight
+    bestScore := 0
+    for _, s := range segment(runes) {
+        score := 0
+        for w := range words(string(runes[s.Start:s.End])) {
+            if want[w] { score++ }
+        }
+        if score <= bestScore { continue }
+        bestScore = score
+        h := Highlight{Text: strings.TrimSpace(string(runes[s.Start:s.End])), Span: s}
+        if c.Span != nil { h.DocumentSpan = &Span{c.Span.Start + s.Start, c.Span.Start + s.End} }
+        best = &h
+    }
+    return best
+}
+
+func addHighlight(hs []Highlight, h *Highlight) []Highlight {
+    if h == nil { return hs }
+    for _, x := range hs {
+        if x.Span == h.Span { return hs }
+    }
+    return append(hs, *h)
+}
+
+// segment splits text into sentences at line breaks and after . ! ? ;
+// followed by a space. Leading and trailing spaces are excluded.
+func segment(runes []rune) []Span {
+    var out []Span
+    start := 0
+    emit := func(end int) {
+        s, e := start, end
+        for s < e && unicode.IsSpace(runes[s]) { s++ }
+        for e > s && unicode.IsSpace(runes[e-1]) { e-- }
+        if e > s { out = append(out, Span{s, e}) }
+        start = end
+    }
+    for i, r := range runes {
+        switch {
+        case r == '\n':
+            emit(i)
+        case strings.ContainsRune(".!?;", r) && i+1 < len(runes) && unicode.IsSpace(runes[i+1]):
+            emit(i + 1)
+        }
+    }
+    emit(len(runes))
+    return out
+}
+
+// words are the lower-cased letter/digit runs of s; numbers like "12,5"
+// split into their digit groups, which still match across sentences.
+func words(s string) map[string]bool {
+    out := map[string]bool{}
+    for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+        out[w] = true
+    }
+    return out
+}
+
+// parseSpan reads a chunk's "start-end" span; anything else is no span.
+func parseSpan(s string) *Span {
+    a, b, ok := strings.Cut(s, "-")
+    if !ok { return nil }
+    start, err1 := strconv.Atoi(strings.TrimSpace(a))
+    end, err2 := strconv.Atoi(strings.TrimSpace(b))
+    if err1 != nil || err2 != nil || start < 0 || end < start { return nil }
+    return &Span{start, end}
+}
+
+// FormatSpan is the stored form of a chunk's span.
+func FormatSpan(start, end int) string { return strconv.Itoa(start) + "-" + strconv.Itoa(end) }
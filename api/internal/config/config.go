@@ -2,6 +2,9 @@ package config
 
 import (
     "os"
+    "strconv"
+    "strings"
+    "time"
 )
 
 type Config struct {
@@ -10,8 +13,83 @@ type Config struct {
     RedisDB     int
     OllamaHost  string
     ModelName   string
+    // AllowedModels may be requested per call instead of ModelName.
+    AllowedModels []string
+    // GenOptions holds per-endpoint default sampling options as JSON
+    // (llm.GenOptions), from GEN_OPTIONS_QA, _SUMMARIZE, _CHAT, _EXTRACT
+    // and _EXPAND (query expansion).
+    GenOptions map[string]string
+    // PromptDir optionally holds <name>/<version>.tmpl prompt templates;
+    // PromptVersions ("qa:v2,summarize:v1") picks each name's default.
+    PromptDir      string
+    PromptVersions map[string]string
+    // Grounding verification of QA/chat answers: minimum mean support score
+    // (0, the default, disables it; each verified answer costs up to 12 more
+    // LLM calls), minimum best retrieval similarity before generating (0
+    // only abstains without hits), and the NLI model ("" = the answer's).
+    GroundingThreshold         float64
+    GroundingMinRetrievalScore float64
+    GroundingModel             string
+    // GroundingCalibration optionally names the JSON Platt mapping (written
+    // by cmd/calibrate-grounding) that makes GroundingThreshold a probability.
+    GroundingCalibration string
+    // CalculatorRounds caps the rounds of calculator tool calls of a QA
+    // answer; 0 disables the calculator.
+    CalculatorRounds int
+    // AgentMaxSteps caps the model turns (each may run searches) of /qa in
+    // agent mode; 0 disables agent mode.
+    AgentMaxSteps int
     EmbedModel  string
+    // EmbedDim, when set, must match what EmbedModel returns; startup fails
+    // otherwise. 0 accepts whatever the model produces.
+    EmbedDim    int
+    // EmbedModelsSecondary are written on ingest next to EmbedModel but not
+    // searched, so a new model can be filled before switching to it.
+    EmbedModelsSecondary []string
+    // EmbedBatchSize inputs go in one /api/embed call; EmbedConcurrency calls
+    // of one request run in parallel.
+    EmbedBatchSize   int
+    EmbedConcurrency int
     FaissHost   string
+    // Calls to Ollama and FAISS: attempts per call, backoff bounds, and the
+    // consecutive failures that open a circuit for BreakerCooldown.
+    UpstreamMaxAttempts int
+    UpstreamBackoffBase time.Duration
+    UpstreamBackoffMax  time.Duration
+    BreakerFailures     int
+    BreakerCooldown     time.Duration
+    // APIKeys maps an API key to the tenant (workspace) it authenticates.
+    // Empty means auth is disabled and every caller is DefaultTenant.
+    APIKeys       map[string]string
+    DefaultTenant string
+    // AdminKey guards /admin; empty disables the admin API.
+    AdminKey      string
+    // Rate limiting (per API key or IP) and global LLM concurrency; 0 disables.
+    RateLimitRPS     float64
+    RateLimitBurst   int
+    LLMMaxConcurrent int
+    LLMRetryAfter    time.Duration
+    // TrustedProxies lists the CIDRs (or addresses) of reverse proxies whose
+    // X-Forwarded-For names the client; others are rate limited by peer.
+    TrustedProxies []string
+    // Redis cache TTLs; 0 disables the cache.
+    CacheEmbedTTL  time.Duration
+    CacheAnswerTTL time.Duration
+    // Semantic answer cache: minimum cosine similarity (0 disables) and
+    // entries kept per document scope.
+    SemanticCacheThreshold  float64
+    SemanticCacheMaxEntries int
+    // VectorIndex selects the ANN index: "faiss" (Python service) or "hnsw"
+    // (in-process, persisted under HNSWDir).
+    VectorIndex        string
+    HNSWDir            string
+    HNSWM              int
+    HNSWEfConstruction int
+    HNSWEfSearch       int
+    HNSWSnapshotEvery  time.Duration
+    // CollectionSyncInterval is how often a replica re-reads the active
+    // embedding collection and running migration from Postgres.
+    CollectionSyncInterval time.Duration
 }
 
 func FromEnv() Config {
@@ -21,8 +99,52 @@ func FromEnv() Config {
         RedisDB:     0,
         OllamaHost:  getenv("OLLAMA_HOST", "http://localhost:11434"),
         ModelName:   getenv("MODEL_NAME", "qwen2.5:3b"),
+        AllowedModels: splitList(os.Getenv("ALLOWED_MODELS")),
+        GenOptions: map[string]string{
+            "qa":        getenv("GEN_OPTIONS_QA", `{"temperature":0.1}`),
+            "summarize": getenv("GEN_OPTIONS_SUMMARIZE", `{"temperature":0.2}`),
+            "chat":      getenv("GEN_OPTIONS_CHAT", `{"temperature":0.3}`),
+            "extract":   getenv("GEN_OPTIONS_EXTRACT", `{"temperature":0}`),
+            "expand":    getenv("GEN_OPTIONS_EXPAND", `{"temperature":0.7}`),
+        },
+        PromptDir:      os.Getenv("PROMPT_DIR"),
+        PromptVersions: parseKeyTenants(os.Getenv("PROMPT_VERSIONS")),
+        GroundingThreshold:         getenvFloat("GROUNDING_THRESHOLD", 0),
+        GroundingMinRetrievalScore: getenvFloat("GROUNDING_MIN_RETRIEVAL_SCORE", 0),
+        GroundingModel:             os.Getenv("GROUNDING_MODEL"),
+        GroundingCalibration:       os.Getenv("GROUNDING_CALIBRATION"),
+        CalculatorRounds:           getenvInt("QA_CALCULATOR_ROUNDS", 4),
+        AgentMaxSteps:              getenvInt("AGENT_MAX_STEPS", 5),
         EmbedModel:  getenv("EMBED_MODEL", "bge-m3"),
+        EmbedDim:    getenvInt("EMBED_DIM", 0),
+        EmbedModelsSecondary: splitList(os.Getenv("EMBED_MODELS_SECONDARY")),
+        EmbedBatchSize:   getenvInt("EMBED_BATCH_SIZE", 32),
+        EmbedConcurrency: getenvInt("EMBED_CONCURRENCY", 2),
         FaissHost:   getenv("FAISS_HOST", "http://localhost:8000"),
+        UpstreamMaxAttempts: getenvInt("UPSTREAM_MAX_ATTEMPTS", 3),
+        UpstreamBackoffBase: getenvDuration("UPSTREAM_BACKOFF_BASE", 200*time.Millisecond),
+        UpstreamBackoffMax:  getenvDuration("UPSTREAM_BACKOFF_MAX", 5*time.Second),
+        BreakerFailures:     getenvInt("BREAKER_FAILURES", 5),
+        BreakerCooldown:     getenvDuration("BREAKER_COOLDOWN", 30*time.Second),
+        APIKeys:       parseKeyTenants(os.Getenv("API_KEYS")),
+        DefaultTenant: getenv("DEFAULT_TENANT", "default"),
+        AdminKey:      os.Getenv("ADMIN_API_KEY"),
+        RateLimitRPS:     getenvFloat("RATE_LIMIT_RPS", 5),
+        RateLimitBurst:   getenvInt("RATE_LIMIT_BURST", 10),
+        LLMMaxConcurrent: getenvInt("LLM_MAX_CONCURRENT", 2),
+        LLMRetryAfter:    getenvDuration("LLM_RETRY_AFTER", 5*time.Second),
+        TrustedProxies:   splitList(os.Getenv("TRUSTED_PROXIES")),
+        CacheEmbedTTL:    getenvDuration("CACHE_EMBED_TTL", 7*24*time.Hour),
+        CacheAnswerTTL:   getenvDuration("CACHE_ANSWER_TTL", time.Hour),
+        SemanticCacheThreshold:  getenvFloat("SEMANTIC_CACHE_THRESHOLD", 0.9),
+        SemanticCacheMaxEntries: getenvInt("SEMANTIC_CACHE_MAX_ENTRIES", 200),
+        VectorIndex:        getenv("VECTOR_INDEX", "faiss"),
+        HNSWDir:            getenv("HNSW_DIR", "./data/hnsw"),
+        HNSWM:              getenvInt("HNSW_M", 16),
+        HNSWEfConstruction: getenvInt("HNSW_EF_CONSTRUCTION", 200),
+        HNSWEfSearch:       getenvInt("HNSW_EF_SEARCH", 64),
+        HNSWSnapshotEvery:  getenvDuration("HNSW_SNAPSHOT_INTERVAL", time.Minute),
+        CollectionSyncInterval: getenvDuration("COLLECTION_SYNC_INTERVAL", 10*time.Second),
     }
     return cfg
 }
@@ -34,4 +156,43 @@ func getenv(key, def string) string {
     return def
 }
 
+func getenvInt(key string, def int) int {
+    if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+        return v
+    }
+    return def
+}
+
+func getenvFloat(key string, def float64) float64 {
+    if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+        return v
+    }
+    return def
+}
 
+func getenvDuration(key string, def time.Duration) time.Duration {
+    if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+        return v
+    }
+    return def
+}
+
+// parseKeyTenants parses "key1:tenantA,key2:tenantB".
+func parseKeyTenants(s string) map[string]string {
+    out := map[string]string{}
+    for _, part := range strings.Split(s, ",") {
+        k, t, ok := strings.Cut(strings.TrimSpace(part), ":")
+        if !ok || k == "" || t == "" { continue }
+        out[k] = t
+    }
+    return out
+}
+
+// splitList parses a comma separated list, dropping empty items.
+func splitList(s string) []string {
+    var out []string
+    for _, v := range strings.Split(s, ",") {
+        if v = strings.TrimSpace(v); v != "" { out = append(out, v) }
+    }
+    return out
+}
@@ -0,0 +1,58 @@
+package finance
+
+import "strings"
+
+// Metric is a key figure extracted from filings. Kind is what its value
+// measures: "amount" (money), "percent", "per_share" or "ratio".
+type Metric struct {
+    Name  string
+    Kind  string
+    Label string // as written in Vietnamese filings, for prompts and search
+}
+
+// Metrics is the catalogue, in the order results are listed.
+var Metrics = []Metric{
+    {"revenue", "amount", "doanh thu thuần (net revenue)"},
+    {"gross_profit", "amount", "lợi nhuận gộp (gross profit)"},
+    {"gross_margin", "percent", "biên lợi nhuận gộp (gross margin)"},
+    {"net_income", "amount", "lợi nhuận sau thuế (net income)"},
+    {"eps", "per_share", "lãi cơ bản trên cổ phiếu (EPS)"},
+    {"total_assets", "amount", "tổng tài sản (total assets)"},
+    {"total_debt", "amount", "tổng nợ vay (total debt)"},
+    {"equity", "amount", "vốn chủ sở hữu (equity)"},
+    {"debt_to_equity", "ratio", "nợ vay / vốn chủ sở hữu (debt to equity)"},
+    {"debt_to_assets", "ratio", "nợ phải trả / tổng tài sản (debt to assets)"},
+}
+
+// LookupMetric returns the metric named name.
+func LookupMetric(name string) (Metric, bool) {
+    for _, m := range Metrics {
+        if m.Name == name { return m, true }
+    }
+    return Metric{}, false
+}
+
+// Normalize fixes the unit of an amount parsed for m: a ratio written as a
+// percentage becomes a plain ratio and a margin written as a fraction a
+// percentage, and currency given separately fills a missing one.
+func (m Metric) Normalize(a Amount, currency string) Amount {
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    if currency == "VNĐ" || currency == "ĐỒNG" { currency = "VND" }
+    switch m.Kind {
+    case "ratio":
+        if a.Unit == "%" { a.Value /= 100 }
+        a.Unit = ""
+    case "percent":
+        if a.Unit != "%" && a.Value <= 1 && a.Value >= -1 { a.Value *= 100 }
+        a.Unit = "%"
+    case "amount":
+        if a.Unit == "" { a.Unit = currency }
+    case "per_share":
+        if !strings.HasSuffix(a.Unit, "/share") {
+            if a.Unit == "" { a.Unit = currency }
+            if a.Unit == "" { a.Unit = "VND" }
+            a.Unit += "/share"
+        }
+    }
+    return a
+}
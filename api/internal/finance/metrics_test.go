@@ -0,0 +1,26 @@
+package finance
+
+import "testing"
+
+func TestMetricNormalize(t *testing.T) {
+    tests := []struct {
+        metric   string
+        in       Amount
+        currency string
+        want     Amount
+    }{
+        {"debt_to_equity", Amount{150, "%"}, "", Amount{1.5, ""}},
+        {"gross_margin", Amount{0.25, ""}, "", Amount{25, "%"}},
+        {"gross_margin", Amount{25, ""}, "", Amount{25, "%"}},
+        {"revenue", Amount{1e12, ""}, "vnđ", Amount{1e12, "VND"}},
+        {"eps", Amount{2350, ""}, "", Amount{2350, "VND/share"}},
+        {"eps", Amount{1.5, "USD"}, "", Amount{1.5, "USD/share"}},
+    }
+    for _, tt := range tests {
+        m, ok := LookupMetric(tt.metric)
+        if !ok { t.Fatalf("no metric %s", tt.metric) }
+        if got := m.Normalize(tt.in, tt.currency); got.Unit != tt.want.Unit || !near(got.Value, tt.want.Value) {
+            t.Errorf("%s.Normalize(%+v, %q) = %+v, want %+v", tt.metric, tt.in, tt.currency, got, tt.want)
+        }
+    }
+}
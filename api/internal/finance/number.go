@@ -0,0 +1,163 @@
+// Package finance knows the key metrics analysts ask for and how figures
+// are written in Vietnamese and English filings.
+package finance
+
+import (
+    "errors"
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+)
+
+// Amount is a figure normalised to base units: Value is in đồng or USD
+// (not tỷ/triệu), in percent for "%", or a plain ratio when Unit is "".
+// Per-share amounts have Unit "VND/share" or "USD/share".
+type Amount struct {
+    Value float64 `json:"value"`
+    Unit  string  `json:"unit"`
+}
+
+var (
+    numberPattern = regexp.MustCompile(`\d[\d.,]*`)
+    // Scale words, longest first so "nghìn tỷ" wins over "tỷ".
+    scales = []struct{ word string; factor float64 }{
+        {"nghìn tỷ", 1e12}, {"ngàn tỷ", 1e12}, {"trillion", 1e12},
+        {"tỷ", 1e9}, {"tỉ", 1e9}, {"billion", 1e9}, {"bn", 1e9},
+        {"triệu", 1e6}, {"million", 1e6}, {"mn", 1e6}, {"tr", 1e6},
+        {"nghìn", 1e3}, {"ngàn", 1e3}, {"thousand", 1e3}, {"k", 1e3},
+    }
+    currencies = []struct{ word, code string }{
+        {"vnđ", "VND"}, {"vnd", "VND"}, {"đồng", "VND"}, {"đ", "VND"},
+        {"usd", "USD"}, {"us$", "USD"}, {"$", "USD"},
+    }
+    perShare = []string{"/cp", "/cổ phiếu", "/ cổ phiếu", "mỗi cổ phiếu", "per share", "/share"}
+)
+
+var (
+    // ErrNoNumber means the text holds no figure.
+    ErrNoNumber = errors.New("không tìm thấy số")
+    // ErrMalformed means a figure's separators do not group thousands,
+    // e.g. "1.234.5".
+    ErrMalformed = errors.New("số viết sai định dạng")
+)
+
+// ParseAmount reads a figure as written in a filing, e.g. "1.234,5 tỷ
+// đồng", "(120) triệu", "12,5%", "USD 3.2 million" or "2.350 đ/cp".
+// language decides ambiguous separators ("1.234" is 1234 in Vietnamese,
+// 1.234 in English); the other convention is recognised when the digits
+// make it clear.
+func ParseAmount(text, language string) (Amount, error) {
+    lower := strings.ToLower(strings.TrimSpace(text))
+    loc := numberPattern.FindStringIndex(lower)
+    if loc == nil { return Amount{}, ErrNoNumber }
+    v, err := parseNumber(lower[loc[0]:loc[1]], language)
+    if err != nil { return Amount{}, err }
+    before, after := lower[:loc[0]], lower[loc[1]:]
+    prefix := strings.TrimSpace(before)
+    if strings.HasSuffix(prefix, "-") || strings.HasSuffix(prefix, "−") || (strings.HasSuffix(prefix, "(") && strings.Contains(after, ")")) { v = -v }
+
+    var a Amount
+    rest := strings.TrimSpace(after)
+    if strings.HasPrefix(rest, "%") || strings.HasPrefix(rest, "phần trăm") || strings.HasPrefix(rest, "percent") {
+        return Amount{Value: v, Unit: "%"}, nil
+    }
+    rest = strings.TrimSpace(strings.TrimLeft(rest, ")"))
+    for _, s := range scales {
+        if strings.HasPrefix(rest, s.word) && boundary(rest, len(s.word)) { v *= s.factor; break }
+    }
+    for _, c := range currencies {
+        if strings.Contains(lower, c.word) && (c.word != "đ" || hasToken(lower, "đ")) { a.Unit = c.code; break }
+    }
+    for _, p := range perShare {
+        if strings.Contains(lower, p) {
+            if a.Unit == "" { a.Unit = "VND" }
+            a.Unit += "/share"
+            break
+        }
+    }
+    a.Value = v
+    return a, nil
+}
+
+// Numbers returns the numbers written in text, unsigned and unscaled
+// ("1.234,5 tỷ" gives 1234.5), read with language's separators.
+func Numbers(text, language string) []float64 {
+    var out []float64
+    for _, s := range numberPattern.FindAllString(text, -1) {
+        if v, err := parseNumber(s, language); err == nil { out = append(out, v) }
+    }
+    return out
+}
+
+// Mentions reports whether text writes the number v, as Numbers reads it.
+func Mentions(text string, v float64, language string) bool {
+    v = math.Abs(v)
+    for _, n := range Numbers(text, language) {
+        if math.Abs(n-v) <= 1e-9*math.Max(1, v) { return true }
+    }
+    return false
+}
+
+// parseNumber parses digits with thousands and decimal separators.
+func parseNumber(s, language string) (float64, error) {
+    s = strings.TrimRight(s, ".,")
+    dots, commas := strings.Count(s, "."), strings.Count(s, ",")
+    var thousands, decimal string
+    switch {
+    case dots > 0 && commas > 0:
+        // Both present: the last one is the decimal separator.
+        if strings.LastIndex(s, ",") > strings.LastIndex(s, ".") { thousands, decimal = ".", "," } else { thousands, decimal = ",", "." }
+    case dots > 1:
+        thousands = "."
+    case commas > 1:
+        thousands = ","
+    case dots == 1:
+        // "1.234" groups thousands in Vietnamese; "12.5" is a decimal anywhere.
+        if len(s)-strings.Index(s, ".")-1 == 3 && language != lang.English { thousands = "." } else { decimal = "." }
+    case commas == 1:
+        if len(s)-strings.Index(s, ",")-1 == 3 && language == lang.English { thousands = "," } else { decimal = "," }
+    }
+    if thousands != "" {
+        whole := s
+        if decimal != "" { whole = s[:strings.LastIndex(s, decimal)] }
+        for _, g := range strings.Split(whole, thousands)[1:] {
+            if len(g) != 3 { return 0, ErrMalformed }
+        }
+        s = strings.ReplaceAll(s, thousands, "")
+    }
+    if decimal != "" { s = strings.Replace(s, decimal, ".", 1) }
+    return strconv.ParseFloat(s, 64)
+}
+
+// boundary reports whether a word of s ends at byte i.
+func boundary(s string, i int) bool {
+    if i >= len(s) { return true }
+    c := s[i]
+    return c == ' ' || c == '/' || c == '.' || c == ',' || c == ';' || c == ')'
+}
+
+func hasToken(s, tok string) bool {
+    for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '/' || r == '(' || r == ')' || r == ',' }) {
+        if f == tok { return true }
+    }
+    return false
+}
+
+var (
+    quarterPattern = regexp.MustCompile(`(?:quý|q)\s*([1-4])\D{0,10}((?:19|20)\d\d)`)
+    halfPattern    = regexp.MustCompile(`(?:6\s*(?:tháng|t)(?:\s*đầu\s*năm)?|h1|1h)\D{0,10}((?:19|20)\d\d)`)
+    yearPattern    = regexp.MustCompile(`(?:19|20)\d\d`)
+)
+
+// NormalizePeriod writes common period phrasings one way: "Q2/2024",
+// "H1/2024" or "2024". Anything else is returned trimmed.
+func NormalizePeriod(period string) string {
+    p := strings.ToLower(strings.TrimSpace(period))
+    if m := quarterPattern.FindStringSubmatch(p); m != nil { return "Q" + m[1] + "/" + m[2] }
+    if m := halfPattern.FindStringSubmatch(p); m != nil { return "H1/" + m[1] }
+    if ys := yearPattern.FindAllString(p, -1); len(ys) == 1 && len(p) <= 16 { return ys[0] }
+    return strings.TrimSpace(period)
+}
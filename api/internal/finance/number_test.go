@@ -0,0 +1,95 @@
+package finance
+
+import (
+    "errors"
+    "math"
+    "testing"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b)) }
+
+func TestParseNumber(t *testing.T) {
+    tests := []struct {
+        in, language string
+        want         float64
+        err          error
+    }{
+        {"1.234", lang.Vietnamese, 1234, nil},
+        {"1.234", lang.English, 1.234, nil},
+        {"1,234", lang.Vietnamese, 1.234, nil},
+        {"1,234", lang.English, 1234, nil},
+        {"12.5", lang.Vietnamese, 12.5, nil},
+        {"12,5", lang.English, 12.5, nil},
+        {"1.234,5", lang.English, 1234.5, nil},
+        {"1,234.5", lang.Vietnamese, 1234.5, nil},
+        {"1.234.567", lang.English, 1234567, nil},
+        {"1,234,567", lang.Vietnamese, 1234567, nil},
+        {"120.", lang.Vietnamese, 120, nil},
+        {"1.234.5", lang.Vietnamese, 0, ErrMalformed},
+        {"1,23,456", lang.English, 0, ErrMalformed},
+        {"12.34.567,8", lang.Vietnamese, 0, ErrMalformed},
+    }
+    for _, tt := range tests {
+        got, err := parseNumber(tt.in, tt.language)
+        if !errors.Is(err, tt.err) || (tt.err == nil && !near(got, tt.want)) {
+            t.Errorf("parseNumber(%q, %s) = %v, %v; want %v, %v", tt.in, tt.language, got, err, tt.want, tt.err)
+        }
+    }
+}
+
+func TestParseAmount(t *testing.T) {
+    tests := []struct {
+        in, language string
+        want         Amount
+        err          error
+    }{
+        {"1.234,5 tỷ đồng", lang.Vietnamese, Amount{1.2345e12, "VND"}, nil},
+        {"2 nghìn tỷ đồng", lang.Vietnamese, Amount{2e12, "VND"}, nil},
+        {"2 ngàn tỷ", lang.Vietnamese, Amount{2e12, ""}, nil},
+        {"2 tỷ đồng", lang.Vietnamese, Amount{2e9, "VND"}, nil},
+        {"(120) triệu", lang.Vietnamese, Amount{-1.2e8, ""}, nil},
+        {"(120)", lang.Vietnamese, Amount{-120, ""}, nil},
+        {"-5,2%", lang.Vietnamese, Amount{-5.2, "%"}, nil},
+        {"12,5%", lang.Vietnamese, Amount{12.5, "%"}, nil},
+        {"12.5 percent", lang.English, Amount{12.5, "%"}, nil},
+        {"USD 3.2 million", lang.English, Amount{3.2e6, "USD"}, nil},
+        {"1,234 billion", lang.English, Amount{1.234e12, ""}, nil},
+        {"2.350 đ/cp", lang.Vietnamese, Amount{2350, "VND/share"}, nil},
+        {"1.5 USD per share", lang.English, Amount{1.5, "USD/share"}, nil},
+        {"1,2", lang.Vietnamese, Amount{1.2, ""}, nil},
+        {"không có số", lang.Vietnamese, Amount{}, ErrNoNumber},
+        {"1.234.5 tỷ", lang.Vietnamese, Amount{}, ErrMalformed},
+    }
+    for _, tt := range tests {
+        got, err := ParseAmount(tt.in, tt.language)
+        if !errors.Is(err, tt.err) || got.Unit != tt.want.Unit || !near(got.Value, tt.want.Value) {
+            t.Errorf("ParseAmount(%q, %s) = %+v, %v; want %+v, %v", tt.in, tt.language, got, err, tt.want, tt.err)
+        }
+    }
+}
+
+func TestMentions(t *testing.T) {
+    text := "Doanh thu đạt 1.234,5 tỷ đồng, tăng 12,5% so với 1.097 tỷ."
+    for _, v := range []float64{1234.5, 12.5, 1097, -1097} {
+        if !Mentions(text, v, lang.Vietnamese) { t.Errorf("Mentions(%v) = false", v) }
+    }
+    for _, v := range []float64{1.097, 1234, 125} {
+        if Mentions(text, v, lang.Vietnamese) { t.Errorf("Mentions(%v) = true", v) }
+    }
+}
+
+func TestNormalizePeriod(t *testing.T) {
+    tests := map[string]string{
+        "Quý 2 năm 2024":       "Q2/2024",
+        "q3/2023":              "Q3/2023",
+        "6 tháng đầu năm 2024": "H1/2024",
+        "H1 2022":              "H1/2022",
+        "Năm 2023":             "2023",
+        " cả năm tài chính ":   "cả năm tài chính",
+    }
+    for in, want := range tests {
+        if got := NormalizePeriod(in); got != want { t.Errorf("NormalizePeriod(%q) = %q, want %q", in, got, want) }
+    }
+}
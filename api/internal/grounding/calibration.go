@@ -0,0 +1,112 @@
+package grounding
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "os"
+)
+
+// Calibration maps a raw support score (see entails) to the probability
+// that the sentence is supported, by Platt scaling:
+// p = 1 / (1 + exp(A*score + B)). A and B are fitted with Fit on sentences
+// labelled by hand, held out from anything used to tune prompts.
+type Calibration struct {
+    A float64 `json:"a"`
+    B float64 `json:"b"`
+    // N is the number of labelled sentences the mapping was fitted on.
+    N int `json:"n"`
+}
+
+// Apply returns the calibrated probability of score; a nil Calibration
+// returns score unchanged.
+func (c *Calibration) Apply(score float64) float64 {
+    if c == nil { return score }
+    return 1 / (1 + math.Exp(c.A*score+c.B))
+}
+
+// LoadCalibration reads a Calibration written as JSON, e.g. by
+// cmd/calibrate-grounding.
+func LoadCalibration(path string) (*Calibration, error) {
+    raw, err := os.ReadFile(path)
+    if err != nil { return nil, err }
+    var c Calibration
+    if err := json.Unmarshal(raw, &c); err != nil { return nil, fmt.Errorf("%s: %w", path, err) }
+    if math.IsNaN(c.A) || math.IsNaN(c.B) || c.A >= 0 { return nil, fmt.Errorf("%s: a must be negative so a higher score is more likely supported", path) }
+    return &c, nil
+}
+
+// ErrFewLabels is returned by Fit without at least two supported and two
+// unsupported sentences.
+var ErrFewLabels = errors.New("calibration needs at least two supported and two unsupported sentences")
+
+// Fit fits a Calibration to raw scores and whether each sentence was
+// supported, using Platt's smoothed targets and the Newton method of Lin,
+// Lin and Weng (2007).
+func Fit(scores []float64, supported []bool) (*Calibration, error) {
+    if len(scores) != len(supported) { return nil, errors.New("scores and labels differ in length") }
+    var pos, neg float64
+    for _, y := range supported {
+        if y { pos++ } else { neg++ }
+    }
+    if pos < 2 || neg < 2 { return nil, ErrFewLabels }
+    hi, lo := (pos+1)/(pos+2), 1/(neg+2)
+    t := make([]float64, len(scores))
+    for i, y := range supported {
+        if y { t[i] = hi } else { t[i] = lo }
+    }
+
+    const sigma = 1e-12
+    a, b := 0.0, math.Log((neg+1)/(pos+1))
+    loss := func(a, b float64) float64 {
+        var f float64
+        for i, s := range scores {
+            fApB := s*a + b
+            if fApB >= 0 {
+                f += t[i]*fApB + math.Log1p(math.Exp(-fApB))
+            } else {
+                f += (t[i]-1)*fApB + math.Log1p(math.Exp(fApB))
+            }
+        }
+        return f
+    }
+    f := loss(a, b)
+    for iter := 0; iter < 100; iter++ {
+        h11, h22, h21, g1, g2 := sigma, sigma, 0.0, 0.0, 0.0
+        for i, s := range scores {
+            fApB := s*a + b
+            var p, q float64
+            if fApB >= 0 {
+                p = math.Exp(-fApB) / (1 + math.Exp(-fApB))
+                q = 1 / (1 + math.Exp(-fApB))
+            } else {
+                p = 1 / (1 + math.Exp(fApB))
+                q = math.Exp(fApB) / (1 + math.Exp(fApB))
+            }
+            d2 := p * q
+            h11 += s * s * d2
+            h22 += d2
+            h21 += s * d2
+            d1 := t[i] - p
+            g1 += s * d1
+            g2 += d1
+        }
+        if math.Abs(g1) < 1e-5 && math.Abs(g2) < 1e-5 { break }
+        det := h11*h22 - h21*h21
+        dA := -(h22*g1 - h21*g2) / det
+        dB := -(-h21*g1 + h11*g2) / det
+        gd := g1*dA + g2*dB
+        step := 1.0
+        for step >= 1e-10 {
+            na, nb := a+step*dA, b+step*dB
+            if nf := loss(na, nb); nf < f+1e-4*step*gd {
+                a, b, f = na, nb, nf
+                break
+            }
+            step /= 2
+        }
+        if step < 1e-10 { break }
+    }
+    return &Calibration{A: a, B: b, N: len(scores)}, nil
+}
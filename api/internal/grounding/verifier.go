@@ -0,0 +1,240 @@
+// Package grounding checks that a generated answer is supported by the
+// passages it was generated from, and turns unsupported answers into an
+// explicit abstention.
+package grounding
+
+import (
+    "context"
+    "math"
+    "strconv"
+    "strings"
+    "unicode"
+
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+)
+
+// Passage is a retrieved chunk the answer may cite as [#ID].
+type Passage struct {
+    ID      int64
+    Content string
+    Score   float32
+}
+
+// Sentence is the verdict on one answer sentence. Label is the NLI label
+// (entailment, neutral, contradiction), "unsupported" when every passage it
+// cites is unknown, or "unchecked" past MaxSentences. RawScore is derived
+// from the verifying model's self-reported confidence (see entails); Score
+// is its calibrated probability of support, or RawScore without a
+// Calibration.
+type Sentence struct {
+    Text      string  `json:"text"`
+    Citations []int64 `json:"citations"`
+    Invalid   []int64 `json:"invalid_citations,omitempty"`
+    Label     string  `json:"label"`
+    Score     float64 `json:"score"`
+    RawScore  float64 `json:"raw_score"`
+}
+
+// Result is the outcome of a check. Score is the mean Score of the checked
+// sentences: with a Calibration, the expected share of supported sentences,
+// so Threshold is a probability; without one it is self-reported by the
+// verifying model and Threshold only a heuristic cut. The answer is replaced
+// by an abstention when Score is below Threshold. Reason says why an answer
+// abstained: no_context, low_retrieval_score or unsupported.
+type Result struct {
+    Score            float64    `json:"score"`
+    Threshold        float64    `json:"threshold"`
+    Calibrated       bool       `json:"calibrated"`
+    Abstained        bool       `json:"abstained"`
+    Reason           string     `json:"reason,omitempty"`
+    Sentences        []Sentence `json:"sentences,omitempty"`
+    InvalidCitations []int64    `json:"invalid_citations,omitempty"`
+    // Answer is the text to return: the abstention, or the answer with
+    // citations of unknown passages removed.
+    Answer string `json:"-"`
+}
+
+// Generator runs the NLI check; *llm.OllamaClient implements it.
+type Generator interface {
+    GenerateStructured(ctx context.Context, model, prompt string, schema *llm.Schema, opts llm.GenOptions, out any) (bool, error)
+}
+
+// Verifier is safe for concurrent use. A nil Verifier, or a zero
+// Threshold, disables verification.
+type Verifier struct {
+    LLM     Generator
+    Prompts *prompts.Registry
+    // Model runs the NLI check; "" uses the model that generated the answer.
+    Model string
+    // Threshold is the minimum mean support score, in [0, 1].
+    Threshold float64
+    // Calibration, when set, turns raw scores into probabilities before
+    // they are compared with Threshold.
+    Calibration *Calibration
+    // MinRetrievalScore abstains before generation when no passage is at
+    // least this similar to the question; 0 only abstains on no passages.
+    MinRetrievalScore float32
+    // MaxSentences bounds the NLI calls per answer; later sentences are
+    // left unchecked. The calls run one after another, so a verified answer
+    // holds a single LLM slot however many sentences it has.
+    MaxSentences int
+}
+
+// Enabled reports whether answers are verified.
+func (v *Verifier) Enabled() bool { return v != nil && v.Threshold > 0 }
+
+// Precheck decides before generation: with no passage, or none similar
+// enough, there is nothing to ground an answer in and the caller should
+// return the abstention right away.
+func (v *Verifier) Precheck(passages []Passage, language string) (Result, bool) {
+    if !v.Enabled() { return Result{}, false }
+    res := Result{Threshold: v.Threshold, Abstained: true, Answer: Abstention(language)}
+    if len(passages) == 0 { res.Reason = "no_context"; return res, true }
+    if v.MinRetrievalScore > 0 {
+        best := passages[0].Score
+        for _, p := range passages { best = max(best, p.Score) }
+        if best < v.MinRetrievalScore { res.Reason = "low_retrieval_score"; return res, true }
+    }
+    return Result{}, false
+}
+
+// Verify checks every sentence of answer against the passages it cites, or
+// against all passages when it cites none. Citations of ids that are not
+// among passages are rejected and dropped from the answer; a sentence whose
+// citations are all rejected is unsupported, with score 0.
+func (v *Verifier) Verify(ctx context.Context, model, answer string, passages []Passage, language string) (Result, error) {
+    byID := make(map[int64]Passage, len(passages))
+    for _, p := range passages { byID[p.ID] = p }
+    res := Result{Threshold: v.Threshold, Calibrated: v.Calibration != nil, Answer: stripCitations(answer, byID)}
+    sentences := splitSentences(answer)
+    if v.Model != "" { model = v.Model }
+    limit := v.MaxSentences
+    if limit <= 0 { limit = 12 }
+
+    checked := 0
+    for i := range sentences {
+        s := &sentences[i]
+        var premise []Passage
+        for _, id := range s.Citations {
+            if p, ok := byID[id]; ok { premise = append(premise, p) } else { s.Invalid = append(s.Invalid, id) }
+        }
+        res.InvalidCitations = append(res.InvalidCitations, s.Invalid...)
+        if len(s.Citations) > 0 && len(premise) == 0 { s.Label = "unsupported"; continue }
+        if len(premise) == 0 { premise = passages }
+        if checked++; checked > limit { s.Label = "unchecked"; continue }
+        label, raw, err := v.Check(ctx, model, premise, s.Text)
+        if err != nil { return res, err }
+        s.Label, s.RawScore = label, raw
+        s.Score = math.Round(v.Calibration.Apply(raw)*1000) / 1000
+    }
+
+    n := 0
+    for _, s := range sentences {
+        if s.Label == "unchecked" { continue }
+        res.Score += s.Score
+        n++
+    }
+    if n > 0 { res.Score /= float64(n) }
+    res.Score = math.Round(res.Score*1000) / 1000
+    res.Sentences = sentences
+    if res.Score < v.Threshold {
+        res.Abstained, res.Reason, res.Answer = true, "unsupported", Abstention(language)
+    }
+    return res, nil
+}
+
+var nliSchema = &llm.Schema{
+    Type: "object",
+    Properties: map[string]*llm.Schema{
+        "label":      {Type: "string", Enum: []any{"entailment", "neutral", "contradiction"}},
+        "confidence": {Type: "number"},
+    },
+    Required: []string{"label", "confidence"},
+}
+
+// Check labels one answer sentence against premise and returns its raw
+// score, before calibration.
+func (v *Verifier) Check(ctx context.Context, model string, premise []Passage, sentence string) (string, float64, error) {
+    return v.entails(ctx, model, premise, strings.TrimSpace(citations.MarkerPattern.ReplaceAllString(sentence, "")))
+}
+
+// entails asks the model whether premise supports hypothesis. The score
+// maps the model's own confidence to support: that confidence for an
+// entailment, half the doubt of a neutral verdict, and 0 for a
+// contradiction. Models are not calibrated, so it only orders answers.
+func (v *Verifier) entails(ctx context.Context, model string, premise []Passage, hypothesis string) (string, float64, error) {
+    var b strings.Builder
+    for _, p := range premise { b.WriteString("- [#" + strconv.FormatInt(p.ID, 10) + "] " + p.Content + "\n") }
+    prompt, _, _, err := v.Prompts.Render(prompts.Verify, "", lang.Default, map[string]any{"Premise": b.String(), "Hypothesis": hypothesis})
+    if err != nil { return "", 0, err }
+    zero := 0.0
+    var out struct {
+        Label      string  `json:"label"`
+        Confidence float64 `json:"confidence"`
+    }
+    if _, err := v.LLM.GenerateStructured(ctx, model, prompt, nliSchema, llm.GenOptions{Temperature: &zero}, &out); err != nil { return "", 0, err }
+    c := math.Min(math.Max(out.Confidence, 0), 1)
+    switch out.Label {
+    case "entailment":
+        return out.Label, c, nil
+    case "neutral":
+        return out.Label, (1 - c) / 2, nil
+    }
+    return out.Label, 0, nil
+}
+
+// Abstention is the answer given when the documents do not support one.
+func Abstention(language string) string {
+    if language == lang.English { return "There is not enough information in the documents to answer this question." }
+    return "Không đủ thông tin trong tài liệu để trả lời câu hỏi này."
+}
+
+// stripCitations rewrites every citation marker to keep only known ids.
+func stripCitations(text string, known map[int64]Passage) string {
+    out := citations.MarkerPattern.ReplaceAllStringFunc(text, func(m string) string {
+        var keep []string
+        for _, id := range citations.ParseIDs(m) {
+            if _, ok := known[id]; ok { keep = append(keep, "#"+strconv.FormatInt(id, 10)) }
+        }
+        if len(keep) == 0 { return "" }
+        return "[" + strings.Join(keep, ", ") + "]"
+    })
+    return strings.TrimSpace(strings.ReplaceAll(out, " .", "."))
+}
+
+// splitSentences splits an answer at line breaks and at . ! ? followed by
+// a space. Citations right after a sentence end ("... 12%. [#3]") belong to
+// that sentence; fragments without letters are dropped.
+func splitSentences(text string) []Sentence {
+    var parts []string
+    for _, line := range strings.Split(text, "\n") {
+        start := 0
+        rs := []rune(line)
+        for i := 0; i < len(rs); i++ {
+            if (rs[i] == '.' || rs[i] == '!' || rs[i] == '?') && i+1 < len(rs) && unicode.IsSpace(rs[i+1]) {
+                parts = append(parts, string(rs[start:i+1]))
+                start = i + 1
+            }
+        }
+        parts = append(parts, string(rs[start:]))
+    }
+    var out []Sentence
+    for _, p := range parts {
+        p = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(p), "-•*"))
+        // A leading marker cites the previous sentence.
+        for len(out) > 0 {
+            loc := citations.MarkerPattern.FindStringIndex(p)
+            if loc == nil || loc[0] != 0 { break }
+            prev := &out[len(out)-1]
+            prev.Citations = append(prev.Citations, citations.ParseIDs(p[:loc[1]])...)
+            prev.Text += " " + p[:loc[1]]
+            p = strings.TrimSpace(p[loc[1]:])
+        }
+        if strings.IndexFunc(p, unicode.IsLetter) < 0 { continue }
+        out = append(out, Sentence{Text: p, Citations: citations.ParseIDs(p)})
+    }
+    return out
+}
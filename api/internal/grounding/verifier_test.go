@@ -0,0 +1,144 @@
+package grounding
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+)
+
+// fakeNLI answers the verify prompt with the verdict listed for its
+// hypothesis (the sentence without its markers), and entailment with
+// confidence 1 otherwise.
+type fakeNLI struct {
+    verdicts map[string]verdict
+    calls    []string
+}
+
+type verdict struct {
+    Label      string  `json:"label"`
+    Confidence float64 `json:"confidence"`
+}
+
+func (f *fakeNLI) GenerateStructured(_ context.Context, _, prompt string, _ *llm.Schema, _ llm.GenOptions, out any) (bool, error) {
+    _, hyp, _ := strings.Cut(prompt, "Câu: ")
+    hyp = strings.TrimSpace(hyp)
+    f.calls = append(f.calls, hyp)
+    v, ok := f.verdicts[hyp]
+    if !ok { v = verdict{"entailment", 1} }
+    raw, _ := json.Marshal(v)
+    return false, json.Unmarshal(raw, out)
+}
+
+func newVerifier(t *testing.T, nli *fakeNLI, threshold float64) *Verifier {
+    t.Helper()
+    reg := &prompts.Registry{}
+    if err := reg.Reload(context.Background()); err != nil { t.Fatal(err) }
+    return &Verifier{LLM: nli, Prompts: reg, Threshold: threshold}
+}
+
+func TestSplitSentences(t *testing.T) {
+    tests := []struct {
+        name string
+        in   string
+        want []Sentence
+    }{
+        {"plain", "Doanh thu tăng 12%. Lợi nhuận giảm.", []Sentence{{Text: "Doanh thu tăng 12%."}, {Text: "Lợi nhuận giảm."}}},
+        {"inline citation", "Doanh thu tăng 12% [#3]. Lợi nhuận giảm [#4, #5].", []Sentence{
+            {Text: "Doanh thu tăng 12% [#3].", Citations: []int64{3}},
+            {Text: "Lợi nhuận giảm [#4, #5].", Citations: []int64{4, 5}},
+        }},
+        {"trailing citation", "Doanh thu tăng 12%. [#3] Lợi nhuận giảm.", []Sentence{
+            {Text: "Doanh thu tăng 12%. [#3]", Citations: []int64{3}},
+            {Text: "Lợi nhuận giảm."},
+        }},
+        {"bullets", "- Vốn 1.234 tỷ [#1]\n* Nợ xấu 2,1% [#2]", []Sentence{
+            {Text: "Vốn 1.234 tỷ [#1]", Citations: []int64{1}},
+            {Text: "Nợ xấu 2,1% [#2]", Citations: []int64{2}},
+        }},
+        {"decimal point", "Tỷ lệ là 3.5% năm 2023.", []Sentence{{Text: "Tỷ lệ là 3.5% năm 2023."}}},
+        {"no letters", "12%. [#1]", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := splitSentences(tt.in); !reflect.DeepEqual(got, tt.want) { t.Errorf("splitSentences(%q) = %+v, want %+v", tt.in, got, tt.want) }
+        })
+    }
+}
+
+func TestVerifyRejectsUnknownCitations(t *testing.T) {
+    nli := &fakeNLI{}
+    v := newVerifier(t, nli, 0.5)
+    passages := []Passage{{ID: 1, Content: "Doanh thu tăng 12%."}, {ID: 2, Content: "Lợi nhuận giảm 3%."}}
+    res, err := v.Verify(context.Background(), "m", "Doanh thu tăng 12% [#1, #9]. Lợi nhuận giảm 3% [#7].", passages, lang.Default)
+    if err != nil { t.Fatal(err) }
+    if want := []int64{9, 7}; !reflect.DeepEqual(res.InvalidCitations, want) { t.Errorf("InvalidCitations = %v, want %v", res.InvalidCitations, want) }
+    if want := "Doanh thu tăng 12% [#1]. Lợi nhuận giảm 3%."; res.Answer != want { t.Errorf("Answer = %q, want %q", res.Answer, want) }
+    if got := res.Sentences[1]; got.Label != "unsupported" || got.Score != 0 { t.Errorf("sentence citing only unknown ids = %+v, want unsupported with score 0", got) }
+    if len(nli.calls) != 1 { t.Errorf("NLI calls = %v, want only the sentence with a known citation", nli.calls) }
+    if res.Score != 0.5 || res.Abstained { t.Errorf("Score = %v, Abstained = %v, want 0.5 and kept", res.Score, res.Abstained) }
+}
+
+func TestVerifyAbstainsBelowThreshold(t *testing.T) {
+    passages := []Passage{{ID: 1, Content: "Doanh thu tăng 12%."}}
+    answer := "Doanh thu tăng 12% [#1]. Lợi nhuận tăng gấp đôi [#1]."
+    tests := []struct {
+        name      string
+        verdict   verdict
+        threshold float64
+        score     float64
+        abstained bool
+    }{
+        {"contradiction", verdict{"contradiction", 0.9}, 0.6, 0.5, true},
+        {"neutral", verdict{"neutral", 0.2}, 0.6, 0.7, false},
+        {"at threshold", verdict{"entailment", 0.2}, 0.6, 0.6, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            v := newVerifier(t, &fakeNLI{verdicts: map[string]verdict{"Lợi nhuận tăng gấp đôi .": tt.verdict}}, tt.threshold)
+            res, err := v.Verify(context.Background(), "m", answer, passages, lang.English)
+            if err != nil { t.Fatal(err) }
+            if res.Score != tt.score || res.Abstained != tt.abstained { t.Fatalf("Score = %v, Abstained = %v, want %v, %v", res.Score, res.Abstained, tt.score, tt.abstained) }
+            if tt.abstained && (res.Answer != Abstention(lang.English) || res.Reason != "unsupported") { t.Errorf("Answer = %q, Reason = %q", res.Answer, res.Reason) }
+        })
+    }
+}
+
+func TestVerifyCalibratesScores(t *testing.T) {
+    nli := &fakeNLI{verdicts: map[string]verdict{"Doanh thu tăng 12% .": {"entailment", 0.7}}}
+    v := newVerifier(t, nli, 0.5)
+    v.Calibration = &Calibration{A: -10, B: 8} // raw 0.7 -> 0.269
+    res, err := v.Verify(context.Background(), "m", "Doanh thu tăng 12% [#1].", []Passage{{ID: 1, Content: "Doanh thu tăng 12%."}}, lang.Default)
+    if err != nil { t.Fatal(err) }
+    s := res.Sentences[0]
+    if s.RawScore != 0.7 || s.Score != 0.269 { t.Errorf("RawScore = %v, Score = %v, want 0.7 and 0.269", s.RawScore, s.Score) }
+    if !res.Calibrated || !res.Abstained { t.Errorf("Calibrated = %v, Abstained = %v, want both", res.Calibrated, res.Abstained) }
+}
+
+func TestPrecheck(t *testing.T) {
+    v := &Verifier{Threshold: 0.5, MinRetrievalScore: 0.4}
+    if res, ok := v.Precheck(nil, lang.Default); !ok || res.Reason != "no_context" { t.Errorf("no passages: %+v, %v", res, ok) }
+    if res, ok := v.Precheck([]Passage{{ID: 1, Score: 0.3}}, lang.Default); !ok || res.Reason != "low_retrieval_score" { t.Errorf("low score: %+v, %v", res, ok) }
+    if _, ok := v.Precheck([]Passage{{ID: 1, Score: 0.3}, {ID: 2, Score: 0.45}}, lang.Default); ok { t.Error("abstained with a passage above MinRetrievalScore") }
+}
+
+func TestFit(t *testing.T) {
+    // Half of the sentences scored 0.5 are supported, all above, none below.
+    var scores []float64
+    var labels []bool
+    for i := 0; i < 40; i++ {
+        scores = append(scores, 0.1, 0.5, 0.9)
+        labels = append(labels, false, i%2 == 0, true)
+    }
+    c, err := Fit(scores, labels)
+    if err != nil { t.Fatal(err) }
+    if c.A >= 0 || c.N != 120 { t.Fatalf("Fit = %+v, want A < 0 and N = 120", c) }
+    if p := c.Apply(0.5); p < 0.4 || p > 0.6 { t.Errorf("Apply(0.5) = %v, want about 0.5", p) }
+    if lo, hi := c.Apply(0.1), c.Apply(0.9); lo > 0.1 || hi < 0.9 { t.Errorf("Apply(0.1) = %v, Apply(0.9) = %v", lo, hi) }
+    if _, err := Fit([]float64{0.1, 0.9, 0.8}, []bool{false, true, true}); err != ErrFewLabels { t.Errorf("Fit with one negative = %v, want ErrFewLabels", err) }
+}
@@ -0,0 +1,238 @@
+package httpserver
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/calc"
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// maxAgentTopK caps the chunks one agent search may return.
+const maxAgentTopK = 10
+
+// agentTimeout bounds an agent answer, searches and tool turns included.
+const agentTimeout = 180 * time.Second
+
+var errNoTitleMatch = errors.New("không có tài liệu nào khớp title")
+
+// AgentSearch is one search of an agent answer, for the trace: the query,
+// its scope and the chunks found, New of them not seen before. The first
+// search (Initial) is the question itself.
+type AgentSearch struct {
+    Query       string   `json:"query"`
+    DocumentIDs []string `json:"document_ids,omitempty"`
+    Title       string   `json:"title,omitempty"`
+    Initial     bool     `json:"initial,omitempty"`
+    Results     []int64  `json:"results"`
+    New         int      `json:"new"`
+    Error       string   `json:"error,omitempty"`
+}
+
+// agentRun is the state of one agent answer: every chunk gathered so far,
+// in the order found, and the searches and calculations made.
+type agentRun struct {
+    d          QASumDeps
+    tenant     string
+    col        *retrieval.Collection
+    topK       int
+    search     retrieval.SearchOptions
+    gathered   []storage.Hit
+    seen       map[int64]bool
+    trace      []AgentSearch
+    calculator calc.Calculator
+    steps      []calc.Step
+}
+
+// agent serves /qa in agent mode: after an initial retrieval for the
+// question, the model may issue its own searches, scoped by document ids
+// or title, for up to max_steps turns, and answers citing everything
+// gathered.
+func (d QASumDeps) agent(w http.ResponseWriter, r *http.Request, req QARequest) {
+    if d.AgentMaxSteps <= 0 { writeError(w, http.StatusBadRequest, "agent mode đang tắt"); return }
+    model, opts, err := d.generation("qa", req.Model, req.Options)
+    if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+    var ok bool
+    if req.PromptVersion, ok = d.Prompts.Resolve(prompts.QA, req.PromptVersion); !ok { writeError(w, http.StatusBadRequest, "prompt_version không tồn tại"); return }
+    respLang, err := responseLanguage(req.ResponseLanguage, req.Question)
+    if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+    maxSteps := d.AgentMaxSteps
+    if req.MaxSteps > 0 && req.MaxSteps < maxSteps { maxSteps = req.MaxSteps }
+    start := time.Now()
+    ctx, cancel := context.WithTimeout(r.Context(), agentTimeout)
+    // The loop outlives the server's write timeout.
+    _ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(agentTimeout))
+    defer cancel()
+    tenant := tenantFrom(r.Context())
+    col := d.Collections.Active()
+    cacheKey, cacheOK := d.Caches.answerKey(ctx, "agent", []string{tenantVersionKey(tenant)},
+        tenant, model, opts.Key(), req.PromptVersion, respLang, col.Model, strconv.Itoa(req.TopK), strconv.Itoa(maxSteps), strconv.Itoa(req.NProbe), strconv.Itoa(req.EfSearch), normalizeText(req.Question))
+    if cacheOK {
+        if b, ok := d.Caches.getAnswer(ctx, cacheKey); ok { writeRawJSON(w, markCached(b, nil)); return }
+    }
+    release, ok := d.Limits.acquireLLM(w, r)
+    if !ok { return }
+    defer release()
+
+    run := &agentRun{d: d, tenant: tenant, col: col, topK: req.TopK, search: retrieval.SearchOptions{NProbe: req.NProbe, EfSearch: req.EfSearch},
+        seen: map[int64]bool{}, trace: []AgentSearch{}, calculator: newCalculator(nil), steps: []calc.Step{}}
+    initial, err := run.find(ctx, AgentSearch{Query: req.Question, Initial: true}, req.TopK)
+    if err != nil { w.WriteHeader(500); return }
+    prompt, promptVersion, promptLang, err := d.Prompts.Render(prompts.QA, req.PromptVersion, respLang, map[string]any{
+        "Context": formatContext(initial), "Question": req.Question, "CrossLingual": d.crossLingual(ctx, tenant, respLang, req.Question, initial),
+    })
+    if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+    system, _, _, err := d.Prompts.Render(prompts.Agent, "", respLang, map[string]any{"Calculator": d.CalculatorRounds > 0})
+    if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+    tools := []llm.Tool{searchTool()}
+    if d.CalculatorRounds > 0 { tools = append(tools, calc.Tools()...) }
+    messages := []llm.ChatMessage{{Role: "system", Content: system}, {Role: "user", Content: prompt}}
+    ans, err := d.toolLoop(ctx, model, messages, tools, opts, maxSteps, func(call llm.ToolCall) string {
+        if call.Function.Name == "search" { return run.searchCall(ctx, call) }
+        return calculate(run.calculator, call, &run.steps)
+    })
+    if errors.Is(err, errToolsUnsupported) { writeError(w, http.StatusBadRequest, "agent mode cần model hỗ trợ tool calling: "+err.Error()); return }
+    if err != nil { w.WriteHeader(500); return }
+
+    var hits []storage.Hit
+    var verdict any
+    if res, abstain := d.precheck("agent", respLang, run.gathered); abstain {
+        // Nothing gathered can ground an answer; abstain like /qa.
+        ans, hits, verdict = res.Answer, run.gathered[:0], res
+    } else {
+        ans, hits, verdict = d.ground(ctx, "agent", model, respLang, strings.TrimSpace(ans), run.gathered)
+    }
+    latency := time.Since(start).Milliseconds()
+    d.audit(ctx, storage.Audit{Tenant: tenant, Endpoint: "agent", LatencyMs: latency, Model: model, PromptName: prompts.QA, PromptVersion: promptVersion})
+    cites, markers := citations.Build(ans, hits)
+    resp := map[string]any{
+        "answer": ans, "citations": cites, "markers": markers, "trace": run.trace, "cached": false,
+        "meta": map[string]any{"model": model, "prompt": prompts.QA, "prompt_version": promptVersion, "prompt_language": promptLang, "language": respLang,
+            "mode": "agent", "max_steps": maxSteps, "searches": len(run.trace), "chunks": len(run.gathered), "latency_ms": latency},
+    }
+    if verdict != nil { resp["grounding"] = verdict }
+    if len(run.steps) > 0 { resp["calculations"] = run.steps }
+    b, _ := json.Marshal(resp)
+    if cacheOK { d.Caches.setAnswer(ctx, cacheKey, b) }
+    writeRawJSON(w, b)
+}
+
+// searchCall runs a search requested by the model and tells it what was
+// found; chunks it already has are only listed by id.
+func (a *agentRun) searchCall(ctx context.Context, call llm.ToolCall) string {
+    var raw any
+    var args struct {
+        Query       string   `json:"query"`
+        DocumentIDs []string `json:"document_ids"`
+        Title       string   `json:"title"`
+        TopK        int      `json:"top_k"`
+    }
+    err := json.Unmarshal(call.Function.Arguments, &raw)
+    if err == nil { err = searchTool().Function.Parameters.Validate(raw) }
+    if err == nil { err = json.Unmarshal(call.Function.Arguments, &args) }
+    if err != nil {
+        a.trace = append(a.trace, AgentSearch{Results: []int64{}, Error: err.Error()})
+        return toolError(err)
+    }
+    topK := args.TopK
+    if topK <= 0 { topK = a.topK }
+    topK = min(topK, maxAgentTopK)
+    before := len(a.gathered)
+    hits, err := a.find(ctx, AgentSearch{Query: args.Query, DocumentIDs: args.DocumentIDs, Title: strings.TrimSpace(args.Title)}, topK)
+    if err != nil { return toolError(err) }
+    if len(hits) == 0 { return "Không tìm thấy đoạn nào." }
+    var b strings.Builder
+    var known []string
+    for _, h := range hits {
+        if a.isNew(h.ID, before) {
+            b.WriteString("- [#" + strconv.FormatInt(h.ID, 10) + "] (" + h.DocID)
+            if h.Title != "" { b.WriteString(", " + h.Title) }
+            if h.Page > 0 { b.WriteString(", trang " + strconv.Itoa(h.Page)) }
+            b.WriteString(") " + h.Content + "\n")
+        } else {
+            known = append(known, "#"+strconv.FormatInt(h.ID, 10))
+        }
+    }
+    if len(known) > 0 { b.WriteString("Đã có từ trước: " + strings.Join(known, ", ") + "\n") }
+    return b.String()
+}
+
+// isNew reports whether the chunk id was first gathered after the first
+// before chunks.
+func (a *agentRun) isNew(id int64, before int) bool {
+    for _, h := range a.gathered[:before] {
+        if h.ID == id { return false }
+    }
+    return true
+}
+
+// find runs one search, records it in the trace (failed ones too) and
+// gathers its chunks. A title limits the search to the documents whose id
+// or title contains it, in addition to any document ids given; a title
+// matching none is an error.
+func (a *agentRun) find(ctx context.Context, s AgentSearch, topK int) ([]storage.Hit, error) {
+    s.Results = []int64{}
+    fail := func(err error) ([]storage.Hit, error) {
+        s.Error = err.Error()
+        a.trace = append(a.trace, s)
+        return nil, err
+    }
+    opts := a.search
+    opts.DocIDs = s.DocumentIDs
+    if s.Title != "" {
+        ids, err := a.d.Repo.MatchDocuments(ctx, a.tenant, s.Title, entityDocuments)
+        if err != nil { return fail(err) }
+        if len(ids) == 0 { return fail(errNoTitleMatch) }
+        opts.DocIDs = append(append([]string{}, s.DocumentIDs...), ids...)
+    }
+    embeds, err := a.d.Caches.embed(ctx, a.d.LLM, a.col.Model, []string{s.Query})
+    if err != nil { return fail(fmt.Errorf("embedding: %w", err)) }
+    hits, err := retrieveHits(ctx, a.d.Repo, a.col, a.tenant, "", embeds[0], topK, opts)
+    if err != nil { return fail(err) }
+    for _, h := range hits {
+        s.Results = append(s.Results, h.ID)
+        if a.seen[h.ID] { continue }
+        a.seen[h.ID] = true
+        a.gathered = append(a.gathered, h)
+        a.calculator.Sources[h.ID] = h.Content
+        s.New++
+    }
+    a.trace = append(a.trace, s)
+    return hits, nil
+}
+
+// toolError is the result of a failed tool call, as JSON the model can
+// read.
+func toolError(err error) string {
+    b, _ := json.Marshal(map[string]string{"error": err.Error()})
+    return string(b)
+}
+
+// searchTool describes the search the model may run.
+func searchTool() llm.Tool {
+    one := 1
+    return llm.Tool{Type: "function", Function: llm.ToolFunction{
+        Name:        "search",
+        Description: "Tìm thêm đoạn văn trong các tài liệu đã ingest theo truy vấn ngữ nghĩa.",
+        Parameters: &llm.Schema{
+            Type: "object",
+            Properties: map[string]*llm.Schema{
+                "query":        {Type: "string", MinLength: &one, Description: "truy vấn cho dữ kiện cần tìm"},
+                "document_ids": {Type: "array", Items: &llm.Schema{Type: "string"}, Description: "chỉ tìm trong các tài liệu này"},
+                "title":        {Type: "string", Description: "chỉ tìm trong tài liệu có id hoặc tiêu đề chứa chuỗi này, ví dụ mã công ty"},
+                "top_k":        {Type: "integer", Description: "số đoạn tối đa, mặc định như câu hỏi, tối đa 10"},
+            },
+            Required: []string{"query"},
+        },
+    }}
+}
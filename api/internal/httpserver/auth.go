@@ -0,0 +1,73 @@
+package httpserver
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "regexp"
+    "strings"
+)
+
+type ctxKey int
+
+const (
+    tenantKey ctxKey = iota
+    // apiKeyKey holds the caller's API key once it has been validated.
+    apiKeyKey
+)
+
+// tenantIDPattern keeps tenant ids safe to use in FAISS index names and Redis keys.
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// tenantMiddleware resolves the caller's tenant from its API key (X-API-Key or
+// Authorization: Bearer). With no keys configured every caller is defaultTenant.
+func tenantMiddleware(keys map[string]string, defaultTenant string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            tenant := defaultTenant
+            ctx := r.Context()
+            if len(keys) > 0 {
+                key := apiKeyFrom(r)
+                t, ok := keys[key]
+                if !ok {
+                    writeError(w, http.StatusUnauthorized, "thiếu hoặc sai API key")
+                    return
+                }
+                tenant = t
+                ctx = context.WithValue(ctx, apiKeyKey, key)
+            }
+            if !tenantIDPattern.MatchString(tenant) {
+                writeError(w, http.StatusForbidden, "tenant không hợp lệ")
+                return
+            }
+            next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tenantKey, tenant)))
+        })
+    }
+}
+
+func apiKeyFrom(r *http.Request) string {
+    if k := r.Header.Get("X-API-Key"); k != "" { return k }
+    if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+        return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+    }
+    return ""
+}
+
+// validatedKeyFrom returns the API key tenantMiddleware accepted; "" when
+// auth is disabled.
+func validatedKeyFrom(ctx context.Context) string {
+    k, _ := ctx.Value(apiKeyKey).(string)
+    return k
+}
+
+// tenantFrom returns the tenant resolved by tenantMiddleware.
+func tenantFrom(ctx context.Context) string {
+    t, _ := ctx.Value(tenantKey).(string)
+    return t
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,150 @@
+package httpserver
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/cache"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+)
+
+// Caches groups the Redis-backed caches. A nil *Caches or nil Redis disables
+// caching, and a zero TTL disables that cache. Cache errors never fail a
+// request; they are logged and the value is recomputed.
+type Caches struct {
+    Redis     *cache.Cache
+    EmbedTTL  time.Duration
+    AnswerTTL time.Duration
+    // SemanticThreshold is the minimum cosine similarity for a previously
+    // answered question to be reused; 0 disables the semantic cache.
+    SemanticThreshold  float64
+    SemanticMaxEntries int
+}
+
+func docVersionKey(tenant, docID string) string { return "docver:" + tenant + ":" + docID }
+
+// tenantVersionKey changes whenever any document of the tenant changes; it
+// versions answers computed over the whole tenant.
+func tenantVersionKey(tenant string) string { return "docver:" + tenant }
+
+// embed returns embeddings for texts, serving repeats from the cache.
+func (c *Caches) embed(ctx context.Context, l *llm.OllamaClient, model string, texts []string) ([][]float32, error) {
+    if c == nil || c.Redis == nil || c.EmbedTTL <= 0 { return l.Embeddings(ctx, model, texts) }
+    out, missing, err := c.Redis.GetEmbeddings(ctx, model, texts)
+    if err != nil {
+        log.Println("embedding cache:", err)
+        return l.Embeddings(ctx, model, texts)
+    }
+    if len(missing) == 0 { return out, nil }
+    todo := make([]string, len(missing))
+    for i, idx := range missing { todo[i] = texts[idx] }
+    fresh, err := l.Embeddings(ctx, model, todo)
+    if err != nil { return nil, err }
+    for i, idx := range missing { out[idx] = fresh[i] }
+    if err := c.Redis.SetEmbeddings(ctx, model, todo, fresh, c.EmbedTTL); err != nil { log.Println("embedding cache:", err) }
+    return out, nil
+}
+
+// answerKey builds a cache key for a generated response from its normalized
+// request parts and the current versions of the documents it was built from,
+// so re-ingesting or deleting a document invalidates it. ok is false when
+// answer caching is off or versions cannot be read.
+func (c *Caches) answerKey(ctx context.Context, endpoint string, versionKeys []string, parts ...string) (string, bool) {
+    if c == nil || c.Redis == nil || c.AnswerTTL <= 0 { return "", false }
+    vers, err := c.Redis.Versions(ctx, versionKeys...)
+    if err != nil {
+        log.Println("answer cache:", err)
+        return "", false
+    }
+    h := sha256.New()
+    for _, p := range parts { h.Write([]byte(p)); h.Write([]byte{0}) }
+    for _, v := range vers { h.Write([]byte(strconv.FormatInt(v, 10))); h.Write([]byte{0}) }
+    return "ans:" + endpoint + ":" + hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (c *Caches) getAnswer(ctx context.Context, key string) ([]byte, bool) {
+    b, err := c.Redis.Get(ctx, key)
+    if err != nil || len(b) == 0 { return nil, false }
+    return b, true
+}
+
+func (c *Caches) setAnswer(ctx context.Context, key string, body []byte) {
+    if err := c.Redis.Set(ctx, key, body, c.AnswerTTL); err != nil { log.Println("answer cache:", err) }
+}
+
+// semanticScope is the semantic cache key for questions over one document
+// scope ("" means the whole tenant) answered the same way. Vectors from
+// different embedding models are not comparable, so the model is part of the
+// scope; params are every other request field the answer depends on. The
+// parts are hashed as a delimited tuple, so no two scopes run together.
+func semanticScope(tenant, docID, embedModel string, params ...string) string {
+    if docID == "" { docID = "*" }
+    h := sha256.New()
+    for _, p := range append([]string{docID, embedModel}, params...) { h.Write([]byte(p)); h.Write([]byte{0}) }
+    return "sem:" + tenant + ":" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (c *Caches) semanticEnabled() bool {
+    return c != nil && c.Redis != nil && c.AnswerTTL > 0 && c.SemanticThreshold > 0
+}
+
+// version returns the current value of one version counter.
+func (c *Caches) version(ctx context.Context, key string) (int64, error) {
+    v, err := c.Redis.Versions(ctx, key)
+    if err != nil { return 0, err }
+    return v[0], nil
+}
+
+// lookupSemantic returns the cached response of the closest earlier question
+// in scope, marked as cached, and the version it was looked up under, to
+// pass to storeSemantic; -1 when the semantic cache is off or unreadable.
+// Storing under the version read before generation means an ingest during
+// generation makes the stored answer stale rather than current.
+func (c *Caches) lookupSemantic(ctx context.Context, scope, versionKey string, vec []float32) ([]byte, int64, bool) {
+    if !c.semanticEnabled() { return nil, -1, false }
+    ver, err := c.version(ctx, versionKey)
+    if err != nil { log.Println("semantic cache:", err); return nil, -1, false }
+    hit, err := c.Redis.SemanticLookup(ctx, scope, vec, ver, c.SemanticThreshold)
+    if err != nil { log.Println("semantic cache:", err); return nil, ver, false }
+    if hit == nil { return nil, ver, false }
+    return markCached(hit.Response, map[string]any{"cached_question": hit.Question, "similarity": hit.Similarity}), ver, true
+}
+
+// storeSemantic caches an answer under the version lookupSemantic returned.
+func (c *Caches) storeSemantic(ctx context.Context, scope string, ver int64, question string, vec []float32, body []byte) {
+    if !c.semanticEnabled() || ver < 0 { return }
+    limit := c.SemanticMaxEntries
+    if limit <= 0 { limit = 200 }
+    if err := c.Redis.SemanticStore(ctx, scope, question, vec, ver, body, limit, c.AnswerTTL); err != nil { log.Println("semantic cache:", err) }
+}
+
+// markCached sets "cached": true (plus extra fields) on a cached JSON object.
+func markCached(body []byte, extra map[string]any) []byte {
+    var m map[string]any
+    if err := json.Unmarshal(body, &m); err != nil { return body }
+    m["cached"] = true
+    for k, v := range extra { m[k] = v }
+    b, err := json.Marshal(m)
+    if err != nil { return body }
+    return b
+}
+
+// invalidateDocument bumps the document and tenant versions.
+func (c *Caches) invalidateDocument(ctx context.Context, tenant, docID string) {
+    if c == nil || c.Redis == nil { return }
+    if err := c.Redis.Bump(ctx, docVersionKey(tenant, docID), tenantVersionKey(tenant)); err != nil {
+        log.Println("cache invalidate:", err)
+    }
+}
+
+// normalizeText lowercases and collapses whitespace so trivially different
+// requests share a cache entry.
+func normalizeText(s string) string {
+    return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
@@ -0,0 +1,83 @@
+package httpserver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+
+    "github.com/hiepdt/contest/services/api/internal/calc"
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+    "github.com/hiepdt/contest/services/api/internal/upstream"
+)
+
+// errToolsUnsupported means Ollama refused the first tool-calling request,
+// as it does for models without tool support.
+var errToolsUnsupported = errors.New("model does not support tools")
+
+// toolLoop chats with tools on offer until the model answers without
+// calling any, for at most rounds model turns; run executes one call and
+// returns the tool message answering it. Out of rounds, the model answers
+// from what it has without tools.
+func (d QASumDeps) toolLoop(ctx context.Context, model string, messages []llm.ChatMessage, tools []llm.Tool, opts llm.GenOptions, rounds int, run func(llm.ToolCall) string) (string, error) {
+    for round := 0; round < rounds; round++ {
+        msg, err := d.LLM.ChatTools(ctx, model, messages, tools, opts)
+        var status *upstream.StatusError
+        if round == 0 && errors.As(err, &status) && status.Status == http.StatusBadRequest { return "", fmt.Errorf("%w: %v", errToolsUnsupported, err) }
+        if err != nil { return "", err }
+        if len(msg.ToolCalls) == 0 { return msg.Content, nil }
+        messages = append(messages, msg)
+        for _, call := range msg.ToolCalls {
+            messages = append(messages, llm.ChatMessage{Role: "tool", Content: run(call), ToolName: call.Function.Name})
+        }
+    }
+    return d.LLM.ChatWith(ctx, model, messages, opts)
+}
+
+// newCalculator is a calculator over the figures of hits.
+func newCalculator(hits []storage.Hit) calc.Calculator {
+    c := calc.Calculator{Sources: make(map[int64]string, len(hits)), Language: lang.Default}
+    for _, h := range hits { c.Sources[h.ID] = h.Content }
+    return c
+}
+
+// calculate runs a calculator call, appending it to steps.
+func calculate(c calc.Calculator, call llm.ToolCall, steps *[]calc.Step) string {
+    s := c.Call(call)
+    outcome := "ok"
+    if s.Error != "" { outcome = "error" } else if len(s.Unsourced) > 0 { outcome = "unsourced" }
+    tool := s.Tool
+    if !calc.Known(tool) { tool = "unknown" }
+    metrics.CalculatorCallsTotal.WithLabelValues(tool, outcome).Inc()
+    *steps = append(*steps, s)
+    return s.Reply()
+}
+
+// generateWithCalculator answers prompt letting the model call the
+// calculator on figures from hits, for up to CalculatorRounds rounds of
+// tool calls, and returns the answer with every calculation made. With the
+// calculator off, or a model Ollama cannot offer tools to, it is a plain
+// generation.
+func (d QASumDeps) generateWithCalculator(ctx context.Context, model, prompt string, opts llm.GenOptions, language string, hits []storage.Hit) (string, []calc.Step, error) {
+    if d.CalculatorRounds <= 0 {
+        ans, err := d.LLM.GenerateWith(ctx, model, prompt, opts)
+        return ans, nil, err
+    }
+    system, _, _, err := d.Prompts.Render(prompts.Calculator, "", language, nil)
+    if err != nil { return "", nil, err }
+    calculator := newCalculator(hits)
+    messages := []llm.ChatMessage{{Role: "system", Content: system}, {Role: "user", Content: prompt}}
+    steps := []calc.Step{}
+    ans, err := d.toolLoop(ctx, model, messages, calc.Tools(), opts, d.CalculatorRounds, func(call llm.ToolCall) string { return calculate(calculator, call, &steps) })
+    if errors.Is(err, errToolsUnsupported) {
+        log.Printf("calculator: %v; generating without tools", err)
+        ans, err = d.LLM.GenerateWith(ctx, model, prompt, opts)
+        return ans, nil, err
+    }
+    return ans, steps, err
+}
@@ -0,0 +1,134 @@
+package httpserver
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strings"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// Retrieval modes of QARequest.Retrieval; "" searches with the question
+// alone.
+const (
+    retrievalMultiQuery = "multi_query"
+    retrievalHyDE       = "hyde"
+)
+
+// expansions is how many paraphrases multi_query asks for.
+const expansions = 3
+
+func validRetrieval(mode string) bool {
+    return mode == "" || mode == retrievalMultiQuery || mode == retrievalHyDE
+}
+
+// Expansion is the debug output of an expanded retrieval: the texts
+// generated and embedded besides the question, the chunk ids each search
+// returned (the question's first) before fusion, and the fused score of
+// each chunk kept.
+type Expansion struct {
+    Mode               string            `json:"mode"`
+    Queries            []string          `json:"queries,omitempty"`
+    HypotheticalAnswer string            `json:"hypothetical_answer,omitempty"`
+    Results            [][]int64         `json:"results"`
+    Fused              map[int64]float64 `json:"fused"`
+    Error              string            `json:"error,omitempty"`
+}
+
+// expandedHits retrieves topK chunks for the question (vec is its
+// embedding) and for the texts generated for mode: paraphrases for
+// multi_query, a hypothetical answer passage for hyde. The result lists
+// are fused by reciprocal rank; hits keep their similarity to the question
+// as score. A failed generation, or a failed embedding of the generated
+// texts, falls back to the question alone, noted in the Expansion.
+func (d QASumDeps) expandedHits(ctx context.Context, col *retrieval.Collection, tenant, docScoped, mode, model, language, question string, vec []float32, topK int, opts retrieval.SearchOptions) ([]storage.Hit, *Expansion, error) {
+    exp := &Expansion{Mode: mode, Results: [][]int64{}, Fused: map[int64]float64{}}
+    texts, err := d.expand(ctx, mode, model, language, question, exp)
+    if err != nil {
+        log.Printf("retrieval %s: %v", mode, err)
+        exp.Error = err.Error()
+    }
+    vecs := [][]float32{vec}
+    if len(texts) > 0 {
+        more, err := d.Caches.embed(ctx, d.LLM, col.Model, texts)
+        if err != nil {
+            log.Printf("retrieval %s embed: %v", mode, err)
+            exp.Error = err.Error()
+        } else {
+            vecs = append(vecs, more...)
+        }
+    }
+    if docScoped != "" { opts.DocIDs = []string{docScoped} }
+    lists := make([][]retrieval.Hit, len(vecs))
+    for i, v := range vecs {
+        if lists[i], err = col.Index.Search(ctx, tenant, v, topK, opts); err != nil { return nil, nil, err }
+        ids := make([]int64, len(lists[i]))
+        for k, h := range lists[i] { ids[k] = h.ID }
+        exp.Results = append(exp.Results, ids)
+    }
+    fused := retrieval.Fuse(lists, topK)
+    for _, h := range fused { exp.Fused[h.ID] = h.Fused }
+    hits, err := loadHits(ctx, d.Repo, tenant, fused)
+    return hits, exp, err
+}
+
+// expand generates the texts embedded besides the question, recording
+// them in exp.
+func (d QASumDeps) expand(ctx context.Context, mode, model, language, question string, exp *Expansion) ([]string, error) {
+    opts := d.GenDefaults["expand"]
+    switch mode {
+    case retrievalHyDE:
+        prompt, _, _, err := d.Prompts.Render(prompts.HyDE, "", language, map[string]any{"Question": question})
+        if err != nil { return nil, err }
+        passage, err := d.LLM.GenerateWith(ctx, model, prompt, opts)
+        if err != nil { return nil, err }
+        exp.HypotheticalAnswer = strings.TrimSpace(passage)
+        if exp.HypotheticalAnswer == "" { return nil, nil }
+        return []string{exp.HypotheticalAnswer}, nil
+    case retrievalMultiQuery:
+        prompt, _, _, err := d.Prompts.Render(prompts.ExpandQueries, "", lang.Default, map[string]any{"Question": question, "Count": expansions})
+        if err != nil { return nil, err }
+        var out struct{ Queries []string `json:"queries"` }
+        repaired, err := d.LLM.GenerateStructured(ctx, model, prompt, queriesSchema(), opts, &out)
+        outcome := "ok"
+        var invalid *llm.StructuredError
+        switch {
+        case errors.As(err, &invalid):
+            metrics.StructuredOutputTotal.WithLabelValues("expand_queries", "fallback").Inc()
+            return nil, invalid.Reason
+        case err != nil:
+            return nil, err
+        case repaired:
+            outcome = "repaired"
+        }
+        metrics.StructuredOutputTotal.WithLabelValues("expand_queries", outcome).Inc()
+        seen := map[string]bool{normalizeText(question): true}
+        for _, q := range out.Queries {
+            q = strings.TrimSpace(q)
+            if seen[normalizeText(q)] || len(exp.Queries) == expansions { continue }
+            seen[normalizeText(q)] = true
+            exp.Queries = append(exp.Queries, q)
+        }
+        return exp.Queries, nil
+    }
+    return nil, nil
+}
+
+// queriesSchema is the multi_query format: 1 to expansions queries.
+func queriesSchema() *llm.Schema {
+    one, most, closed := 1, expansions, false
+    return &llm.Schema{
+        Type: "object",
+        Properties: map[string]*llm.Schema{
+            "queries": {Type: "array", Items: &llm.Schema{Type: "string", MinLength: &one}, MinItems: &one, MaxItems: &most},
+        },
+        Required:             []string{"queries"},
+        AdditionalProperties: &closed,
+    }
+}
@@ -0,0 +1,32 @@
+package httpserver
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// generation resolves the model and sampling options of one request: the
+// endpoint's server-side defaults overridden by whatever the caller set. The
+// model must be on the allow-list; "" means GenModel.
+func (d QASumDeps) generation(endpoint, model string, opts *llm.GenOptions) (string, llm.GenOptions, error) {
+    if model == "" { model = d.GenModel }
+    if model != d.GenModel && !d.AllowedModels[model] {
+        return "", llm.GenOptions{}, fmt.Errorf("model %q không được phép", model)
+    }
+    merged := d.GenDefaults[endpoint]
+    if opts != nil {
+        if err := opts.Validate(); err != nil { return "", llm.GenOptions{}, errors.New("options: " + err.Error()) }
+        merged = merged.Merge(*opts)
+    }
+    return model, merged, nil
+}
+
+// audit records a generation; a failure only costs the audit row.
+func (d QASumDeps) audit(ctx context.Context, a storage.Audit) {
+    if err := d.Repo.InsertAudit(ctx, a); err != nil { log.Println("audit:", err) }
+}
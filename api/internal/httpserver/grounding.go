@@ -0,0 +1,46 @@
+package httpserver
+
+import (
+    "context"
+    "log"
+
+    "github.com/hiepdt/contest/services/api/internal/grounding"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// passages adapts retrieval hits for the grounding verifier.
+func passages(hits []storage.Hit) []grounding.Passage {
+    out := make([]grounding.Passage, len(hits))
+    for i, h := range hits { out[i] = grounding.Passage{ID: h.ID, Content: h.Content, Score: h.Score} }
+    return out
+}
+
+// precheck returns the abstention to send instead of generating when the
+// retrieved hits cannot ground any answer.
+func (d QASumDeps) precheck(endpoint, language string, hits []storage.Hit) (*grounding.Result, bool) {
+    res, abstain := d.Grounding.Precheck(passages(hits), language)
+    if !abstain { return nil, false }
+    metrics.GroundingTotal.WithLabelValues(endpoint, res.Reason).Inc()
+    return &res, true
+}
+
+// ground verifies a generated answer against the hits it was generated
+// from and returns the answer to send, the citations backing it and the
+// verdict for the response (nil when verification is off). A failing
+// verifier leaves the answer as generated rather than failing the request.
+func (d QASumDeps) ground(ctx context.Context, endpoint, model, language, ans string, hits []storage.Hit) (string, []storage.Hit, any) {
+    if !d.Grounding.Enabled() { return ans, hits, nil }
+    res, err := d.Grounding.Verify(ctx, model, ans, passages(hits), language)
+    if err != nil {
+        log.Printf("grounding %s: %v", endpoint, err)
+        metrics.GroundingTotal.WithLabelValues(endpoint, "error").Inc()
+        return ans, hits, map[string]string{"error": err.Error()}
+    }
+    if res.Abstained {
+        metrics.GroundingTotal.WithLabelValues(endpoint, res.Reason).Inc()
+        return res.Answer, hits[:0], res
+    }
+    metrics.GroundingTotal.WithLabelValues(endpoint, "grounded").Inc()
+    return res.Answer, hits, res
+}
@@ -5,16 +5,71 @@ import (
     "encoding/json"
     "net/http"
     "time"
+
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/llm"
 )
 
 type IngestRequest struct {
-    DocumentID string   `json:"document_id"`
-    Chunks     []string `json:"chunks"`
+    DocumentID string       `json:"document_id"`
+    Title      string       `json:"title,omitempty"`
+    Chunks     []ChunkInput `json:"chunks"`
+    // Language ("vi", "en") overrides detection from the chunks.
+    Language   string       `json:"language,omitempty"`
+}
+
+// ChunkInput is a chunk to ingest: a plain string, or an object that also
+// gives its page and character offsets in the document, which citations
+// return for highlighting.
+type ChunkInput struct {
+    Text  string `json:"text"`
+    Page  int    `json:"page,omitempty"`
+    Start *int   `json:"start,omitempty"`
+    End   *int   `json:"end,omitempty"`
+}
+
+func (c *ChunkInput) UnmarshalJSON(b []byte) error {
+    if len(b) > 0 && b[0] == '"' { return json.Unmarshal(b, &c.Text) }
+    type plain ChunkInput
+    return json.Unmarshal(b, (*plain)(c))
+}
+
+// span is the stored form of the chunk's offsets; "" when not given.
+func (c ChunkInput) span() string {
+    if c.Start == nil || c.End == nil || *c.Start < 0 || *c.End < *c.Start { return "" }
+    return citations.FormatSpan(*c.Start, *c.End)
 }
 
 type QARequest struct {
     Question string `json:"question"`
     TopK     int    `json:"top_k"`
+    // Optional accuracy/latency knobs for approximate indexes (IVF-PQ nprobe,
+    // HNSW efSearch); 0 keeps the index default.
+    NProbe   int    `json:"nprobe"`
+    EfSearch int    `json:"ef_search"`
+    // Model and Options override the server's generation defaults; the model
+    // must be on the ALLOWED_MODELS list.
+    Model    string          `json:"model,omitempty"`
+    Options  *llm.GenOptions `json:"options,omitempty"`
+    // PromptVersion picks a version of the "qa" template; "" is the default.
+    PromptVersion string `json:"prompt_version,omitempty"`
+    // ResponseLanguage ("vi", "en") sets the answer's language; by default
+    // it follows the question.
+    ResponseLanguage string `json:"response_language,omitempty"`
+    // Mode "compare" answers comparison questions with a table, retrieving
+    // separately for each target; Targets optionally fixes them instead of
+    // having the model decompose the question. See handlers_compare.go.
+    // Mode "agent" lets the model run follow-up searches, for at most
+    // MaxSteps turns (capped by the server); see agent.go.
+    Mode     string          `json:"mode,omitempty"`
+    Targets  []CompareTarget `json:"targets,omitempty"`
+    MaxSteps int             `json:"max_steps,omitempty"`
+    // Retrieval "multi_query" also searches with paraphrases of the
+    // question and "hyde" with a hypothetical answer passage, fusing the
+    // results; see expand.go. Debug adds the generated texts and per-search
+    // results to the response.
+    Retrieval string `json:"retrieval,omitempty"`
+    Debug     bool   `json:"debug,omitempty"`
 }
 
 type SummarizeRequest struct {
@@ -22,6 +77,11 @@ type SummarizeRequest struct {
     NumBullets int    `json:"num_bullets"`
     Category   string `json:"category"`
     Instruction string `json:"instruction"`
+    Model      string          `json:"model,omitempty"`
+    Options    *llm.GenOptions `json:"options,omitempty"`
+    PromptVersion string       `json:"prompt_version,omitempty"`
+    // ResponseLanguage ("vi", "en"); by default the document's language.
+    ResponseLanguage string    `json:"response_language,omitempty"`
 }
 
 func (a *API) writeJSON(w http.ResponseWriter, status int, v any) {
@@ -30,6 +90,14 @@ func (a *API) writeJSON(w http.ResponseWriter, status int, v any) {
     _ = json.NewEncoder(w).Encode(v)
 }
 
+// writeRawJSON writes an already encoded JSON body with status 200.
+func writeRawJSON(w http.ResponseWriter, body []byte) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(body)
+    if len(body) == 0 || body[len(body)-1] != '\n' { _, _ = w.Write([]byte("\n")) }
+}
+
 func (a *API) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
     return context.WithTimeout(ctx, 60*time.Second)
 }
@@ -0,0 +1,283 @@
+package httpserver
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/reembed"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+type AdminDeps struct {
+    Repo        *storage.Repository
+    Collections *retrieval.Registry
+    Migrations  *reembed.Runner
+    Prompts     *prompts.Registry
+}
+
+// collection resolves an admin request's model; empty means the active one.
+func (d AdminDeps) collection(model string) *retrieval.Collection {
+    if model == "" { return d.Collections.Active() }
+    return d.Collections.Get(model)
+}
+
+// reindexBatch is how many embeddings are read from Postgres and sent to an
+// index per round trip.
+const reindexBatch = 500
+
+// adminMiddleware guards /admin with a single operator key. With no key
+// configured the admin API is disabled.
+func adminMiddleware(key string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if key == "" { w.WriteHeader(http.StatusNotFound); return }
+            if subtle.ConstantTimeCompare([]byte(apiKeyFrom(r)), []byte(key)) != 1 {
+                writeError(w, http.StatusUnauthorized, "thiếu hoặc sai admin key")
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// MakeReindexHandler rebuilds every rebuildable index (FAISS, HNSW) from the
+// embeddings stored in Postgres, for all tenants or only ?tenant=, and for all
+// collections or only ?model=. Progress is streamed as NDJSON, one line per
+// batch and a final summary line.
+func MakeReindexHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        ctx := r.Context()
+        tenants := []string{r.URL.Query().Get("tenant")}
+        if tenants[0] == "" {
+            var err error
+            if tenants, err = deps.Repo.Tenants(ctx); err != nil { w.WriteHeader(500); return }
+        }
+        cols := deps.Collections.All()
+        if m := r.URL.Query().Get("model"); m != "" {
+            col := deps.Collections.Get(m)
+            if col == nil { writeError(w, http.StatusNotFound, "không có collection cho model "+m); return }
+            cols = []*retrieval.Collection{col}
+        }
+        rc := http.NewResponseController(w)
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        w.WriteHeader(http.StatusOK)
+        enc := json.NewEncoder(w)
+        emit := func(v any) {
+            // A full rebuild outlives the server's write timeout.
+            _ = rc.SetWriteDeadline(time.Now().Add(time.Minute))
+            _ = enc.Encode(v)
+            _ = rc.Flush()
+        }
+        start := time.Now()
+        total := 0
+        for _, col := range cols {
+            targets := col.Index.Rebuildable()
+            for _, tenant := range tenants {
+                n, err := rebuildTenant(ctx, deps.Repo, col.Model, targets, tenant, func(n int) { emit(map[string]any{"model": col.Model, "tenant": tenant, "indexed": n}) })
+                if err != nil { emit(map[string]any{"model": col.Model, "tenant": tenant, "error": err.Error()}); return }
+                total += n
+            }
+            // Snapshot persistent indexes (HNSWStore) now, so a restart does
+            // not load the pre-rebuild graph.
+            for _, t := range targets {
+                s, ok := t.(interface{ Save() error })
+                if !ok { continue }
+                if err := s.Save(); err != nil {
+                    log.Printf("reindex %s: save: %v", col.Model, err)
+                    emit(map[string]any{"model": col.Model, "error": "save: " + err.Error()})
+                }
+            }
+        }
+        emit(map[string]any{"done": true, "tenants": len(tenants), "indexed": total, "elapsed_ms": time.Since(start).Milliseconds()})
+    }
+}
+
+// rebuildTenant empties targets for the tenant and streams its stored
+// embeddings of model into them in batches, reporting the running count after
+// each.
+func rebuildTenant(ctx context.Context, repo *storage.Repository, model string, targets []retrieval.Rebuildable, tenant string, progress func(int)) (int, error) {
+    for _, t := range targets {
+        if err := t.Reset(ctx, tenant); err != nil { return 0, err }
+    }
+    var after int64
+    n := 0
+    for {
+        batch, err := repo.EmbeddingsAfter(ctx, tenant, model, after, reindexBatch)
+        if err != nil { return n, err }
+        if len(batch) == 0 { return n, nil }
+        items := make([]retrieval.Item, len(batch))
+        for i, e := range batch { items[i] = retrieval.Item{ID: e.ID, DocID: e.DocID, Vector: e.Vector} }
+        for _, t := range targets {
+            if err := t.Add(ctx, tenant, items); err != nil { return n, err }
+        }
+        after = batch[len(batch)-1].ID
+        n += len(batch)
+        progress(n)
+    }
+}
+
+// MakeIndexStatsHandler reports the FAISS vector count for ?tenant= in the
+// collection of ?model= (default: active).
+func MakeIndexStatsHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        col := deps.collection(r.URL.Query().Get("model"))
+        if col == nil || col.Faiss == nil { w.WriteHeader(http.StatusNotFound); return }
+        st, err := col.Faiss.Stats(r.Context(), r.URL.Query().Get("tenant"))
+        if err != nil { writeError(w, http.StatusBadGateway, err.Error()); return }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(st)
+    }
+}
+
+type TrainIndexRequest struct {
+    Tenant string                   `json:"tenant"`
+    // Model selects the collection; empty means the active one.
+    Model  string                   `json:"model"`
+    Spec   retrieval.FaissIndexSpec `json:"spec"`
+    // SampleSize is how many stored embeddings are drawn for training.
+    SampleSize int `json:"sample_size"`
+}
+
+// MakeTrainIndexHandler switches a tenant's FAISS index type (e.g. to IVF-PQ
+// or HNSW for large corpora): it trains the new index on a random sample of
+// the tenant's embeddings from Postgres, then refills it from Postgres.
+func MakeTrainIndexHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req TrainIndexRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tenant == "" || req.Spec.Type == "" {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        col := deps.collection(req.Model)
+        if col == nil || col.Faiss == nil { w.WriteHeader(http.StatusNotFound); return }
+        if req.SampleSize <= 0 { req.SampleSize = 5000 }
+        ctx := r.Context()
+        rc := http.NewResponseController(w)
+        _ = rc.SetWriteDeadline(time.Time{})
+        var sample [][]float32
+        if req.Spec.Type == "ivfpq" {
+            rows, err := deps.Repo.SampleEmbeddings(ctx, req.Tenant, col.Model, req.SampleSize)
+            if err != nil { w.WriteHeader(500); return }
+            for _, e := range rows { sample = append(sample, e.Vector) }
+        }
+        start := time.Now()
+        req.Spec.Dim = col.Dim
+        if err := col.Faiss.Train(ctx, req.Tenant, req.Spec, sample); err != nil { writeError(w, http.StatusBadGateway, err.Error()); return }
+        n, err := rebuildTenant(ctx, deps.Repo, col.Model, []retrieval.Rebuildable{col.Faiss}, req.Tenant, func(int) {})
+        if err != nil { writeError(w, http.StatusBadGateway, err.Error()); return }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{"tenant": req.Tenant, "model": col.Model, "spec": req.Spec, "sample": len(sample), "indexed": n, "elapsed_ms": time.Since(start).Milliseconds()})
+    }
+}
+
+type BenchmarkRequest struct {
+    Tenant   string `json:"tenant"`
+    Model    string `json:"model"`
+    Queries  int    `json:"queries"`
+    TopK     int    `json:"top_k"`
+    NProbe   int    `json:"nprobe"`
+    EfSearch int    `json:"ef_search"`
+}
+
+// MakeRecallBenchmarkHandler measures recall@k of the tenant's FAISS index
+// against exact brute-force cosine search over every embedding in Postgres,
+// using stored chunk embeddings as queries.
+func MakeRecallBenchmarkHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req BenchmarkRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tenant == "" { w.WriteHeader(http.StatusBadRequest); return }
+        col := deps.collection(req.Model)
+        if col == nil || col.Faiss == nil { w.WriteHeader(http.StatusNotFound); return }
+        if req.Queries <= 0 { req.Queries = 100 }
+        if req.TopK <= 0 { req.TopK = 10 }
+        if req.NProbe < 0 || req.EfSearch < 0 { writeError(w, http.StatusBadRequest, "nprobe và ef_search không được âm"); return }
+        ctx := r.Context()
+        _ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+        queries, err := deps.Repo.SampleEmbeddings(ctx, req.Tenant, col.Model, req.Queries)
+        if err != nil { w.WriteHeader(500); return }
+        exact := make([]*topK, len(queries))
+        for i := range exact { exact[i] = newTopK(req.TopK) }
+        var after int64
+        for {
+            batch, err := deps.Repo.EmbeddingsAfter(ctx, req.Tenant, col.Model, after, reindexBatch)
+            if err != nil { w.WriteHeader(500); return }
+            if len(batch) == 0 { break }
+            for qi, q := range queries {
+                for _, e := range batch { exact[qi].push(e.ID, cosineSim(q.Vector, e.Vector)) }
+            }
+            after = batch[len(batch)-1].ID
+        }
+        opts := retrieval.SearchOptions{NProbe: req.NProbe, EfSearch: req.EfSearch}
+        found, relevant := 0, 0
+        var approxTime time.Duration
+        for qi, q := range queries {
+            t0 := time.Now()
+            hits, err := col.Faiss.Search(ctx, req.Tenant, q.Vector, req.TopK, opts)
+            approxTime += time.Since(t0)
+            if err != nil { writeError(w, http.StatusBadGateway, err.Error()); return }
+            want := exact[qi].ids()
+            for _, h := range hits {
+                if want[h.ID] { found++ }
+            }
+            relevant += len(want)
+        }
+        recall := 0.0
+        if relevant > 0 { recall = float64(found) / float64(relevant) }
+        avgMs := 0.0
+        if len(queries) > 0 { avgMs = float64(approxTime.Microseconds()) / 1000 / float64(len(queries)) }
+        stats, _ := col.Faiss.Stats(ctx, req.Tenant)
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{
+            "tenant": req.Tenant, "model": col.Model, "spec": stats.Spec, "queries": len(queries), "top_k": req.TopK,
+            "nprobe": req.NProbe, "ef_search": req.EfSearch,
+            "recall": recall, "avg_search_ms": avgMs,
+        })
+    }
+}
+
+// topK keeps the k best (id, score) pairs seen; k is small so a sorted slice
+// is enough.
+type topK struct {
+    k      int
+    idList []int64
+    scores []float64
+}
+
+func newTopK(k int) *topK { return &topK{k: k} }
+
+func (t *topK) push(id int64, score float64) {
+    if len(t.scores) == t.k && score <= t.scores[len(t.scores)-1] { return }
+    i := sort.Search(len(t.scores), func(i int) bool { return t.scores[i] < score })
+    t.scores = append(t.scores, 0)
+    t.idList = append(t.idList, 0)
+    copy(t.scores[i+1:], t.scores[i:])
+    copy(t.idList[i+1:], t.idList[i:])
+    t.scores[i], t.idList[i] = score, id
+    if len(t.scores) > t.k { t.scores, t.idList = t.scores[:t.k], t.idList[:t.k] }
+}
+
+func (t *topK) ids() map[int64]bool {
+    m := make(map[int64]bool, len(t.idList))
+    for _, id := range t.idList { m[id] = true }
+    return m
+}
+
+func cosineSim(a, b []float32) float64 {
+    if len(a) != len(b) { return -1 }
+    var dot, na, nb float64
+    for i := range a {
+        dot += float64(a[i]) * float64(b[i])
+        na += float64(a[i]) * float64(a[i])
+        nb += float64(b[i]) * float64(b[i])
+    }
+    if na == 0 || nb == 0 { return 0 }
+    return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,292 @@
+package httpserver
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// CompareTarget is one side of a comparison and one column of its table,
+// e.g. a company, a period or a company in a period. Retrieval for it is
+// limited to DocumentIDs; when none are given, to the documents whose id or
+// title contains Entity, and failing that it searches the whole tenant.
+type CompareTarget struct {
+    Label       string   `json:"label"`
+    Entity      string   `json:"entity,omitempty"`
+    Period      string   `json:"period,omitempty"`
+    Query       string   `json:"query,omitempty"`
+    DocumentIDs []string `json:"document_ids,omitempty"`
+}
+
+// query is what is embedded to retrieve the target's chunks.
+func (t CompareTarget) query(question string) string {
+    if t.Query != "" { return t.Query }
+    return strings.TrimSpace(question + " " + t.Label + " " + t.Entity + " " + t.Period)
+}
+
+const (
+    maxCompareTargets = 6
+    // entityDocuments caps the documents matched by a target's entity.
+    entityDocuments = 50
+)
+
+// CompareTable lays the comparison out side by side: one column per target
+// and one row per metric. Cells line up with Columns.
+type CompareTable struct {
+    Columns []string     `json:"columns"`
+    Rows    []CompareRow `json:"rows"`
+}
+
+type CompareRow struct {
+    Label string        `json:"label"`
+    Cells []CompareCell `json:"cells"`
+}
+
+// CompareCell is a value with the chunks backing it; only chunks retrieved
+// for the cell's own target are accepted as citations.
+type CompareCell struct {
+    Value     string               `json:"value"`
+    Citations []citations.Citation `json:"citations"`
+}
+
+// compare serves /qa in comparison mode: the question is decomposed into
+// targets (unless the caller listed them), each target is retrieved
+// separately, and the model answers with a comparison and a table whose
+// cells cite their target's chunks.
+func (d QASumDeps) compare(w http.ResponseWriter, r *http.Request, req QARequest) {
+    model, opts, err := d.generation("qa", req.Model, req.Options)
+    if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+    var ok bool
+    if req.PromptVersion, ok = d.Prompts.Resolve(prompts.Compare, req.PromptVersion); !ok { writeError(w, http.StatusBadRequest, "prompt_version không tồn tại"); return }
+    respLang, err := responseLanguage(req.ResponseLanguage, req.Question)
+    if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+    if len(req.Targets) > maxCompareTargets { writeError(w, http.StatusBadRequest, "tối đa "+strconv.Itoa(maxCompareTargets)+" targets"); return }
+    seen := map[string]bool{}
+    for _, t := range req.Targets {
+        if t.Label == "" || seen[t.Label] { writeError(w, http.StatusBadRequest, "mỗi target cần label riêng"); return }
+        seen[t.Label] = true
+    }
+    start := time.Now()
+    ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+    defer cancel()
+    tenant := tenantFrom(r.Context())
+    col := d.Collections.Active()
+    targetsKey, _ := json.Marshal(req.Targets)
+    cacheKey, cacheOK := d.Caches.answerKey(ctx, "compare", []string{tenantVersionKey(tenant)},
+        tenant, model, opts.Key(), req.PromptVersion, respLang, col.Model, strconv.Itoa(req.TopK), strconv.Itoa(req.NProbe), strconv.Itoa(req.EfSearch), string(targetsKey), normalizeText(req.Question))
+    if cacheOK {
+        if b, ok := d.Caches.getAnswer(ctx, cacheKey); ok { writeRawJSON(w, markCached(b, nil)); return }
+    }
+    release, ok := d.Limits.acquireLLM(w, r)
+    if !ok { return }
+    defer release()
+
+    targets, decomposed := req.Targets, false
+    if len(targets) == 0 {
+        if targets, err = d.decompose(ctx, model, opts, req.Question); err != nil { writeError(w, http.StatusBadGateway, "không tách được câu hỏi so sánh: "+err.Error()); return }
+        decomposed = true
+    }
+    if len(targets) < 2 { writeError(w, http.StatusBadRequest, "cần ít nhất hai đối tượng để so sánh; hãy gửi targets"); return }
+    queries := make([]string, len(targets))
+    for i, t := range targets { queries[i] = t.query(req.Question) }
+    embeds, err := d.Caches.embed(ctx, d.LLM, col.Model, queries)
+    if err != nil || len(embeds) != len(targets) { w.WriteHeader(500); return }
+    groups := make([][]storage.Hit, len(targets))
+    var all []storage.Hit
+    inAll := map[int64]bool{}
+    var sections strings.Builder
+    for i := range targets {
+        t := &targets[i]
+        if len(t.DocumentIDs) == 0 && t.Entity != "" {
+            if t.DocumentIDs, err = d.Repo.MatchDocuments(ctx, tenant, t.Entity, entityDocuments); err != nil { w.WriteHeader(500); return }
+        }
+        groups[i], err = retrieveHits(ctx, d.Repo, col, tenant, "", embeds[i], req.TopK, retrieval.SearchOptions{NProbe: req.NProbe, EfSearch: req.EfSearch, DocIDs: t.DocumentIDs})
+        if err != nil { w.WriteHeader(500); return }
+        sections.WriteString("### " + t.Label + "\n" + formatContext(groups[i]) + "\n")
+        for _, h := range groups[i] {
+            if !inAll[h.ID] { inAll[h.ID] = true; all = append(all, h) }
+        }
+    }
+
+    meta := map[string]any{"model": model, "prompt": prompts.Compare, "mode": "compare", "decomposed": decomposed, "language": respLang}
+    var ans string
+    var table *CompareTable
+    var verdict any
+    if res, abstain := d.precheck("compare", respLang, all); abstain {
+        ans, all, verdict = res.Answer, all[:0], res
+    } else {
+        labels := make([]string, len(targets))
+        enum := make([]any, len(targets))
+        for i, t := range targets { labels[i], enum[i] = t.Label, t.Label }
+        prompt, promptVersion, promptLang, err := d.Prompts.Render(prompts.Compare, req.PromptVersion, respLang, map[string]any{
+            "Targets": `"` + strings.Join(labels, `", "`) + `"`, "Context": sections.String(), "Question": req.Question,
+            "CrossLingual": d.crossLingual(ctx, tenant, respLang, req.Question, all),
+        })
+        if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+        var out compareOutput
+        repaired, err := d.LLM.GenerateStructured(ctx, model, prompt, compareSchema(enum), opts, &out)
+        outcome := "ok"
+        var invalid *llm.StructuredError
+        switch {
+        case errors.As(err, &invalid):
+            metrics.StructuredOutputTotal.WithLabelValues("compare", "fallback").Inc()
+            writeError(w, http.StatusBadGateway, "model không trả về đúng schema: "+invalid.Reason.Error())
+            return
+        case err != nil:
+            w.WriteHeader(500)
+            return
+        case repaired:
+            outcome = "repaired"
+        }
+        metrics.StructuredOutputTotal.WithLabelValues("compare", outcome).Inc()
+        var uncited int
+        table, uncited = buildCompareTable(labels, groups, out)
+        ans, all, verdict = d.ground(ctx, "compare", model, respLang, strings.TrimSpace(out.Answer), all)
+        if len(all) == 0 { table = nil }
+        meta["prompt_version"], meta["prompt_language"], meta["structured_output"], meta["uncited_cells"] = promptVersion, promptLang, outcome, uncited
+        d.audit(ctx, storage.Audit{Tenant: tenant, Endpoint: "compare", LatencyMs: time.Since(start).Milliseconds(), Model: model, PromptName: prompts.Compare, PromptVersion: promptVersion})
+    }
+    meta["latency_ms"] = time.Since(start).Milliseconds()
+    cites, markers := citations.Build(ans, all)
+    resp := map[string]any{"answer": ans, "table": table, "targets": targets, "citations": cites, "markers": markers, "cached": false, "meta": meta}
+    if verdict != nil { resp["grounding"] = verdict }
+    b, _ := json.Marshal(resp)
+    if cacheOK { d.Caches.setAnswer(ctx, cacheKey, b) }
+    writeRawJSON(w, b)
+}
+
+// decompose asks the model for the targets a comparison question names.
+// Targets with a label already used are dropped.
+func (d QASumDeps) decompose(ctx context.Context, model string, opts llm.GenOptions, question string) ([]CompareTarget, error) {
+    prompt, _, _, err := d.Prompts.Render(prompts.Decompose, "", lang.Default, map[string]any{"Question": question})
+    if err != nil { return nil, err }
+    var out struct{ Targets []CompareTarget `json:"targets"` }
+    repaired, err := d.LLM.GenerateStructured(ctx, model, prompt, decomposeSchema(), opts, &out)
+    outcome := "ok"
+    var invalid *llm.StructuredError
+    switch {
+    case errors.As(err, &invalid):
+        metrics.StructuredOutputTotal.WithLabelValues("compare_decompose", "fallback").Inc()
+        return nil, invalid.Reason
+    case err != nil:
+        return nil, err
+    case repaired:
+        outcome = "repaired"
+    }
+    metrics.StructuredOutputTotal.WithLabelValues("compare_decompose", outcome).Inc()
+    var targets []CompareTarget
+    seen := map[string]bool{}
+    for _, t := range out.Targets {
+        t.Label = strings.TrimSpace(t.Label)
+        if seen[t.Label] || len(targets) == maxCompareTargets { continue }
+        seen[t.Label] = true
+        targets = append(targets, t)
+    }
+    return targets, nil
+}
+
+type compareOutput struct {
+    Answer string `json:"answer"`
+    Rows   []struct {
+        Label string `json:"label"`
+        Cells []struct {
+            Target    string  `json:"target"`
+            Value     string  `json:"value"`
+            Citations []int64 `json:"citations"`
+        } `json:"cells"`
+    } `json:"rows"`
+}
+
+// buildCompareTable lines the model's cells up with the targets and turns
+// their ids into citations, keeping only chunks retrieved for the cell's
+// target. It also counts cells left with a value but no valid citation.
+func buildCompareTable(labels []string, groups [][]storage.Hit, out compareOutput) (*CompareTable, int) {
+    column := make(map[string]int, len(labels))
+    for i, l := range labels { column[l] = i }
+    table := &CompareTable{Columns: labels, Rows: []CompareRow{}}
+    uncited := 0
+    for _, row := range out.Rows {
+        cells := make([]CompareCell, len(labels))
+        for i := range cells { cells[i].Citations = []citations.Citation{} }
+        for _, c := range row.Cells {
+            i, ok := column[c.Target]
+            if !ok { continue }
+            cells[i].Value = strings.TrimSpace(c.Value)
+            for _, id := range c.Citations {
+                for _, h := range groups[i] {
+                    if h.ID == id { cells[i].Citations = append(cells[i].Citations, citations.Single(h, c.Value+" "+row.Label)); break }
+                }
+            }
+        }
+        for _, c := range cells {
+            if c.Value != "" && len(c.Citations) == 0 { uncited++ }
+        }
+        table.Rows = append(table.Rows, CompareRow{Label: strings.TrimSpace(row.Label), Cells: cells})
+    }
+    return table, uncited
+}
+
+// decomposeSchema is the decomposition format: 2 to maxCompareTargets
+// targets, each with a label and a search query.
+func decomposeSchema() *llm.Schema {
+    one, two, most, closed := 1, 2, maxCompareTargets, false
+    return &llm.Schema{
+        Type: "object",
+        Properties: map[string]*llm.Schema{
+            "targets": {Type: "array", MinItems: &two, MaxItems: &most, Items: &llm.Schema{
+                Type: "object",
+                Properties: map[string]*llm.Schema{
+                    "label":  {Type: "string", MinLength: &one},
+                    "entity": {Type: "string"},
+                    "period": {Type: "string"},
+                    "query":  {Type: "string", MinLength: &one},
+                },
+                Required:             []string{"label", "entity", "period", "query"},
+                AdditionalProperties: &closed,
+            }},
+        },
+        Required:             []string{"targets"},
+        AdditionalProperties: &closed,
+    }
+}
+
+// compareSchema is the comparison format; cells name their target by label.
+func compareSchema(labels []any) *llm.Schema {
+    one, closed := 1, false
+    return &llm.Schema{
+        Type: "object",
+        Properties: map[string]*llm.Schema{
+            "answer": {Type: "string", MinLength: &one},
+            "rows": {Type: "array", Items: &llm.Schema{
+                Type: "object",
+                Properties: map[string]*llm.Schema{
+                    "label": {Type: "string", MinLength: &one},
+                    "cells": {Type: "array", Items: &llm.Schema{
+                        Type: "object",
+                        Properties: map[string]*llm.Schema{
+                            "target":    {Type: "string", Enum: labels},
+                            "value":     {Type: "string"},
+                            "citations": {Type: "array", Items: &llm.Schema{Type: "integer"}},
+                        },
+                        Required: []string{"target", "value", "citations"},
+                    }},
+                },
+                Required: []string{"label", "cells"},
+            }},
+        },
+        Required:             []string{"answer", "rows"},
+        AdditionalProperties: &closed,
+    }
+}
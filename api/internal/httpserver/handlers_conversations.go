@@ -0,0 +1,172 @@
+package httpserver
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+type CreateConversationRequest struct {
+    DocumentID string `json:"document_id"`
+    Title      string `json:"title"`
+}
+
+type MessageRequest struct {
+    Content string          `json:"content"`
+    TopK    int             `json:"top_k"`
+    Model   string          `json:"model,omitempty"`
+    Options *llm.GenOptions `json:"options,omitempty"`
+    // PromptVersion picks a version of the "chat_system" template.
+    PromptVersion string    `json:"prompt_version,omitempty"`
+    // ResponseLanguage ("vi", "en"); by default the message's language.
+    ResponseLanguage string `json:"response_language,omitempty"`
+}
+
+// historyTurns is how many earlier messages are fed to condensation and chat.
+const historyTurns = 10
+
+func MakeCreateConversationHandler(deps QASumDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req CreateConversationRequest
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil { w.WriteHeader(http.StatusBadRequest); return }
+        }
+        conv, err := deps.Repo.CreateConversation(r.Context(), tenantFrom(r.Context()), req.DocumentID, req.Title)
+        if err != nil { w.WriteHeader(500); return }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+        _ = json.NewEncoder(w).Encode(conv)
+    }
+}
+
+func MakeListMessagesHandler(deps QASumDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        conv, ok := loadConversation(w, r, deps)
+        if !ok { return }
+        msgs, err := deps.Repo.RecentMessages(r.Context(), conv.ID, 200)
+        if err != nil { w.WriteHeader(500); return }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{"conversation": conv, "messages": msgs})
+    }
+}
+
+// MakeConversationMessageHandler answers a follow-up in a conversation: the
+// question is first rewritten into a standalone query using the history, that
+// query drives retrieval, and the answer is generated with the history as chat
+// turns so references like "còn quý 3 thì sao?" resolve.
+func MakeConversationMessageHandler(deps QASumDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        conv, ok := loadConversation(w, r, deps)
+        if !ok { return }
+        var req MessageRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Content) == "" {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        if req.TopK <= 0 { req.TopK = 5 }
+        model, opts, err := deps.generation("chat", req.Model, req.Options)
+        if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        if !deps.Prompts.Has(prompts.ChatSystem, req.PromptVersion) { writeError(w, http.StatusBadRequest, "prompt_version không tồn tại"); return }
+        respLang, err := responseLanguage(req.ResponseLanguage, req.Content)
+        if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        start := time.Now()
+        ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+        defer cancel()
+        release, ok := deps.Limits.acquireLLM(w, r)
+        if !ok { return }
+        defer release()
+        tenant := tenantFrom(r.Context())
+        history, err := deps.Repo.RecentMessages(ctx, conv.ID, historyTurns)
+        if err != nil { w.WriteHeader(500); return }
+        standalone, err := condenseQuestion(ctx, deps.LLM, deps.Prompts, model, history, req.Content)
+        if err != nil { w.WriteHeader(500); return }
+        col := deps.Collections.Active()
+        embeds, err := deps.Caches.embed(ctx, deps.LLM, col.Model, []string{standalone})
+        if err != nil || len(embeds) == 0 { w.WriteHeader(500); return }
+        hits, err := retrieveHits(ctx, deps.Repo, col, tenant, conv.DocumentID, embeds[0], req.TopK, retrieval.SearchOptions{})
+        if err != nil { w.WriteHeader(500); return }
+
+        meta := map[string]any{"model": model, "prompt": prompts.ChatSystem, "language": respLang}
+        var ans string
+        var verdict any
+        if res, abstain := deps.precheck("chat", respLang, hits); abstain {
+            ans, hits, verdict = res.Answer, hits[:0], res
+        } else {
+            system, promptVersion, promptLang, err := deps.Prompts.Render(prompts.ChatSystem, req.PromptVersion, respLang, map[string]any{
+                "Context": formatContext(hits), "CrossLingual": deps.crossLingual(ctx, tenant, respLang, req.Content, hits),
+            })
+            if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+            msgs := []llm.ChatMessage{{Role: "system", Content: system}}
+            for _, m := range history { msgs = append(msgs, llm.ChatMessage{Role: m.Role, Content: m.Content}) }
+            msgs = append(msgs, llm.ChatMessage{Role: "user", Content: req.Content})
+            ans, err = deps.LLM.ChatWith(ctx, model, msgs, opts)
+            if err != nil { w.WriteHeader(500); return }
+            ans, hits, verdict = deps.ground(ctx, "chat", model, respLang, strings.TrimSpace(ans), hits)
+            meta["prompt_version"], meta["prompt_language"] = promptVersion, promptLang
+            deps.audit(ctx, storage.Audit{Tenant: tenant, Endpoint: "chat", LatencyMs: time.Since(start).Milliseconds(), Model: model, PromptName: prompts.ChatSystem, PromptVersion: promptVersion})
+        }
+        meta["latency_ms"] = time.Since(start).Milliseconds()
+
+        if _, err := deps.Repo.AppendMessage(ctx, conv.ID, "user", req.Content, nil); err != nil { w.WriteHeader(500); return }
+        cites, markers := citations.Build(ans, hits)
+        msgID, err := deps.Repo.AppendMessage(ctx, conv.ID, "assistant", ans, cites)
+        if err != nil { w.WriteHeader(500); return }
+        resp := map[string]any{
+            "conversation_id":     conv.ID,
+            "message_id":          msgID,
+            "answer":              ans,
+            "standalone_question": standalone,
+            "citations":           cites,
+            "markers":             markers,
+            "meta":                meta,
+        }
+        if verdict != nil { resp["grounding"] = verdict }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(resp)
+    }
+}
+
+// loadConversation resolves {id} for the caller's tenant, writing 404 otherwise.
+func loadConversation(w http.ResponseWriter, r *http.Request, deps QASumDeps) (storage.Conversation, bool) {
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil { w.WriteHeader(http.StatusNotFound); return storage.Conversation{}, false }
+    conv, err := deps.Repo.GetConversation(r.Context(), tenantFrom(r.Context()), id)
+    if errors.Is(err, storage.ErrNotFound) { w.WriteHeader(http.StatusNotFound); return conv, false }
+    if err != nil { w.WriteHeader(500); return conv, false }
+    return conv, true
+}
+
+// condenseQuestion rewrites a follow-up into a question that can be searched
+// without the conversation. The first question of a conversation is used as is.
+func condenseQuestion(ctx context.Context, l *llm.OllamaClient, reg *prompts.Registry, model string, history []storage.Message, question string) (string, error) {
+    if len(history) == 0 { return question, nil }
+    var h strings.Builder
+    for _, m := range history {
+        role := "Người dùng"
+        if m.Role == "assistant" { role = "Trợ lý" }
+        h.WriteString(role + ": " + m.Content + "\n")
+    }
+    // The rewrite keeps the question's language, so use its template.
+    prompt, _, _, err := reg.Render(prompts.Condense, "", lang.Or(lang.Detect(question), lang.Default), map[string]any{"History": h.String(), "Question": question})
+    if err != nil { return "", err }
+    // Rewriting should be deterministic whatever the answer's sampling is.
+    zero := 0.0
+    out, err := l.GenerateWith(ctx, model, prompt, llm.GenOptions{Temperature: &zero})
+    if err != nil { return "", err }
+    out = strings.TrimSpace(out)
+    if out == "" { return question, nil }
+    return out, nil
+}
@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/go-chi/chi/v5"
+
+    "github.com/hiepdt/contest/services/api/internal/reembed"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+type StartMigrationRequest struct {
+    Model string `json:"model"`
+}
+
+// MakeStartEmbeddingMigrationHandler starts re-embedding every chunk with a
+// new model. Queries keep using the current model until the job completes,
+// then switch atomically.
+func MakeStartEmbeddingMigrationHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req StartMigrationRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model == "" { w.WriteHeader(http.StatusBadRequest); return }
+        m, err := deps.Migrations.Start(r.Context(), req.Model)
+        switch {
+        case errors.Is(err, storage.ErrMigrationRunning), errors.Is(err, reembed.ErrAlreadyActive):
+            writeError(w, http.StatusConflict, err.Error())
+            return
+        case err != nil:
+            writeError(w, http.StatusBadGateway, err.Error())
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+        _ = json.NewEncoder(w).Encode(m)
+    }
+}
+
+// MakeListEmbeddingMigrationsHandler reports the active model and the latest
+// jobs with their progress.
+func MakeListEmbeddingMigrationsHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        list, err := deps.Repo.EmbeddingMigrations(r.Context(), 20)
+        if err != nil { w.WriteHeader(500); return }
+        if list == nil { list = []storage.EmbeddingMigration{} }
+        models := []string{}
+        for _, c := range deps.Collections.All() { models = append(models, c.Model) }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{"active": deps.Collections.Active().Model, "collections": models, "migrations": list})
+    }
+}
+
+// MakeCancelEmbeddingMigrationHandler stops a running job, on whichever
+// replica runs it. Vectors already written are kept, so starting the same
+// model again resumes the work.
+func MakeCancelEmbeddingMigrationHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil { w.WriteHeader(http.StatusBadRequest); return }
+        ok, err := deps.Migrations.Cancel(r.Context(), id)
+        if err != nil { w.WriteHeader(500); return }
+        if !ok { w.WriteHeader(http.StatusNotFound); return }
+        w.WriteHeader(http.StatusAccepted)
+    }
+}
@@ -3,20 +3,29 @@ package httpserver
 import (
     "context"
     "encoding/json"
+    "log"
     "net/http"
     "time"
     "strconv"
+    "strings"
+    "unicode"
 
+    "github.com/go-chi/chi/v5"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
     "github.com/hiepdt/contest/services/api/internal/llm"
     "github.com/hiepdt/contest/services/api/internal/retrieval"
     "github.com/hiepdt/contest/services/api/internal/storage"
+    "github.com/hiepdt/contest/services/api/internal/tables"
 )
 
 type IngestDeps struct {
     Repo *storage.Repository
     LLM  *llm.OllamaClient
-    EmbedModel string
-    Faiss *retrieval.FaissClient
+    // Collections holds one index per embedding model; ingest writes to all
+    // of them.
+    Collections *retrieval.Registry
+    Caches *Caches
 }
 
 func MakeIngestHandler(deps IngestDeps) http.HandlerFunc {
@@ -30,26 +39,150 @@ func MakeIngestHandler(deps IngestDeps) http.HandlerFunc {
             w.WriteHeader(http.StatusBadRequest)
             return
         }
+        if req.Language != "" && !lang.Valid(req.Language) { writeError(w, http.StatusBadRequest, "language phải là vi hoặc en"); return }
+        // Tables become chunks of their own, embedded as text and also
+        // stored as rows.
+        pieces := splitTables(req.Chunks)
+        texts := make([]string, len(pieces))
+        for i, p := range pieces { texts[i] = p.Text }
+        if req.Language == "" { req.Language = lang.Detect(strings.Join(texts, "\n")) }
         ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
         defer cancel()
-        if err := deps.Repo.UpsertDocument(ctx, req.DocumentID, ""); err != nil { w.WriteHeader(500); return }
-        embeds, err := deps.LLM.Embeddings(ctx, deps.EmbedModel, req.Chunks)
-        if err != nil { w.WriteHeader(500); return }
-        items := make(map[int64][]float32)
-        for i, ch := range req.Chunks {
-            var vec []float32
-            if i < len(embeds) { vec = embeds[i] }
-            // Insert DB row to get id for FAISS
-            _ = deps.Repo.InsertChunk(ctx, req.DocumentID, 0, "", ch, vec)
-            // naive: use i as temp id; in real impl, return id from DB
-            items[int64(i+1)] = vec
-        }
-        if deps.Faiss != nil { _ = deps.Faiss.Add(ctx, items) }
+        tenant := tenantFrom(r.Context())
+        if err := deps.Repo.UpsertDocument(ctx, tenant, req.DocumentID, req.Title, req.Language); err != nil { w.WriteHeader(500); return }
+        // Embed with the active model before writing anything, so a failing
+        // embedder leaves no vector-less chunks behind.
+        cols := deps.Collections.All()
+        embeds, err := deps.Caches.embed(ctx, deps.LLM, cols[0].Model, texts)
+        if err != nil { writeError(w, http.StatusBadGateway, "embedding: "+err.Error()); return }
+        if len(embeds) != len(texts) { writeError(w, http.StatusBadGateway, "embedding: số vector không khớp số chunk"); return }
+        if len(embeds[0]) != cols[0].Dim { writeError(w, http.StatusBadGateway, "embedding: số chiều khác với collection "+cols[0].Model); return }
+        ids := make([]int64, len(pieces))
+        numTables := 0
+        for i, p := range pieces {
+            // Insert DB row to get id for the vector index
+            id, err := deps.Repo.InsertChunk(ctx, tenant, req.DocumentID, p.Page, p.span(), p.Text)
+            if err != nil { w.WriteHeader(500); return }
+            ids[i] = id
+            if p.table == nil { continue }
+            t := storage.Table{DocumentID: req.DocumentID, ChunkID: id, Page: p.Page, Title: p.table.Title, Kind: p.table.Kind, Header: p.table.Header, Rows: p.table.Rows}
+            if _, err := deps.Repo.InsertTable(ctx, tenant, t); err != nil { w.WriteHeader(500); return }
+            numTables++
+        }
+        if err := cols[0].Index.Add(ctx, tenant, chunkItems(ids, req.DocumentID, embeds)); err != nil { w.WriteHeader(500); return }
+        // Secondary collections are being filled for a future switch; a
+        // failure there must not fail ingest and is repaired by a backfill.
+        for _, col := range cols[1:] {
+            vecs, err := deps.Caches.embed(ctx, deps.LLM, col.Model, texts)
+            if err == nil { err = col.Index.Add(ctx, tenant, chunkItems(ids, req.DocumentID, vecs)) }
+            if err != nil { log.Printf("ingest %s into %s: %v", req.DocumentID, col.Model, err) }
+        }
+        deps.Caches.invalidateDocument(ctx, tenant, req.DocumentID)
         w.Header().Set("Content-Type", "application/json")
         w.WriteHeader(http.StatusOK)
-        _, _ = w.Write([]byte(`{"status":"ingested","chunks":` + strconv.Itoa(len(req.Chunks)) + `}`))
+        _, _ = w.Write([]byte(`{"status":"ingested","chunks":` + strconv.Itoa(len(pieces)) + `,"tables":` + strconv.Itoa(numTables) + `,"language":` + strconv.Quote(req.Language) + `}`))
+    }
+}
+
+// piece is a chunk as stored: an ingested chunk's prose, or one of its
+// tables rendered as text.
+type piece struct {
+    ChunkInput
+    table *tables.Table
+}
+
+// splitTables separates the tables found in each chunk from its prose. A
+// table's span is narrowed to its lines when the chunk's offsets are known,
+// and so is the prose's; see proseSpan.
+func splitTables(chunks []ChunkInput) []piece {
+    var out []piece
+    for _, ch := range chunks {
+        found := tables.Detect(ch.Text)
+        if len(found) == 0 { out = append(out, piece{ChunkInput: ch}); continue }
+        if prose := tables.Strip(ch.Text, found); strings.IndexFunc(prose, unicode.IsLetter) >= 0 {
+            p := ch
+            p.Text = prose
+            p.Start, p.End = proseSpan(ch, found)
+            out = append(out, piece{ChunkInput: p})
+        }
+        for i := range found {
+            t := &found[i]
+            p := ChunkInput{Text: tables.Render(*t), Page: ch.Page}
+            if ch.Start != nil {
+                start, end := *ch.Start+t.Start, *ch.Start+t.End
+                p.Start, p.End = &start, &end
+            }
+            out = append(out, piece{ChunkInput: p, table: t})
+        }
+    }
+    return out
+}
+
+// proseSpan is the span of a chunk's text once its tables are stripped: the
+// one stretch of prose around them, trimmed like tables.Strip. Prose on both
+// sides of a table is not one range of the document, so it gets no span
+// rather than one that highlights the table too.
+func proseSpan(ch ChunkInput, found []tables.Table) (*int, *int) {
+    if ch.Start == nil { return nil, nil }
+    runes := []rune(ch.Text)
+    from, to := -1, -1
+    at := 0
+    for i := 0; i <= len(found); i++ {
+        end := len(runes)
+        if i < len(found) { end = found[i].Start }
+        if s, e, ok := trimRunes(runes, at, end); ok {
+            if from >= 0 { return nil, nil }
+            from, to = s, e
+        }
+        if i < len(found) { at = found[i].End }
     }
+    if from < 0 { return nil, nil }
+    start, end := *ch.Start+from, *ch.Start+to
+    return &start, &end
+}
+
+// trimRunes narrows runes[from:to] to its non-space part; ok is false when
+// there is none.
+func trimRunes(runes []rune, from, to int) (int, int, bool) {
+    for from < to && unicode.IsSpace(runes[from]) { from++ }
+    for to > from && unicode.IsSpace(runes[to-1]) { to-- }
+    return from, to, from < to
 }
 
+// chunkItems pairs chunk ids with their vectors; the embedder guarantees one
+// vector per chunk.
+func chunkItems(ids []int64, docID string, vecs [][]float32) []retrieval.Item {
+    items := make([]retrieval.Item, len(ids))
+    for i, id := range ids { items[i] = retrieval.Item{ID: id, DocID: docID, Vector: vecs[i]} }
+    return items
+}
 
+// MakeDeleteDocumentHandler removes a document, its chunks and their vectors.
+// Ids an index failed to drop are harmless: search results are re-read from
+// Postgres and missing rows dropped.
+func MakeDeleteDocumentHandler(deps IngestDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        docID := chi.URLParam(r, "id")
+        tenant := tenantFrom(r.Context())
+        chunkIDs, found, err := deps.Repo.DeleteDocument(r.Context(), tenant, docID)
+        if err != nil { w.WriteHeader(500); return }
+        if !found { w.WriteHeader(http.StatusNotFound); return }
+        for _, col := range deps.Collections.All() {
+            if err := col.Index.Delete(r.Context(), tenant, chunkIDs); err != nil { w.WriteHeader(500); return }
+        }
+        deps.Caches.invalidateDocument(r.Context(), tenant, docID)
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
 
+// MakeListTablesHandler returns the tables found in a document at ingest,
+// with their rows.
+func MakeListTablesHandler(deps IngestDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        docID := chi.URLParam(r, "id")
+        ts, err := deps.Repo.DocumentTables(r.Context(), tenantFrom(r.Context()), docID)
+        if err != nil { w.WriteHeader(500); return }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{"document_id": docID, "tables": ts})
+    }
+}
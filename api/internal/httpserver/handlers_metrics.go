@@ -0,0 +1,204 @@
+package httpserver
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/finance"
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+type ExtractMetricsRequest struct {
+    DocumentID string `json:"document_id"`
+    // Metrics names the metrics to extract (see finance.Metrics); empty
+    // means all. Period optionally restricts extraction to one period.
+    Metrics []string        `json:"metrics,omitempty"`
+    Period  string          `json:"period,omitempty"`
+    TopK    int             `json:"top_k"`
+    Model   string          `json:"model,omitempty"`
+    Options *llm.GenOptions `json:"options,omitempty"`
+}
+
+// statementChunks is how many financial statement tables of the document
+// are added to the retrieved chunks; they hold most of the figures.
+const statementChunks = 4
+
+// MakeExtractMetricsHandler extracts key metrics of a document: chunks are
+// retrieved for the requested metrics, the model lists the figures it finds
+// under a JSON schema, and each figure is parsed from the text as written,
+// checked to cite a supplied chunk that contains it, normalised and stored.
+func MakeExtractMetricsHandler(deps QASumDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req ExtractMetricsRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DocumentID == "" { w.WriteHeader(http.StatusBadRequest); return }
+        if req.TopK <= 0 { req.TopK = 8 }
+        wanted := finance.Metrics
+        if len(req.Metrics) > 0 {
+            wanted = nil
+            for _, name := range req.Metrics {
+                m, ok := finance.LookupMetric(name)
+                if !ok { writeError(w, http.StatusBadRequest, "metric không hỗ trợ: "+name); return }
+                wanted = append(wanted, m)
+            }
+        }
+        model, opts, err := deps.generation("extract", req.Model, req.Options)
+        if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        start := time.Now()
+        ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+        defer cancel()
+        release, ok := deps.Limits.acquireLLM(w, r)
+        if !ok { return }
+        defer release()
+        tenant := tenantFrom(r.Context())
+
+        hits, err := deps.metricHits(ctx, tenant, req.DocumentID, wanted, req.Period, req.TopK)
+        if err != nil { w.WriteHeader(500); return }
+        if len(hits) == 0 { writeError(w, http.StatusBadRequest, "document_id không có dữ liệu; hãy ingest trước"); return }
+        var list strings.Builder
+        names := make([]any, len(wanted))
+        for i, m := range wanted {
+            list.WriteString("- " + m.Name + ": " + m.Label + "\n")
+            names[i] = m.Name
+        }
+        prompt, promptVersion, _, err := deps.Prompts.Render(prompts.ExtractMetrics, "", lang.Default, map[string]any{
+            "Metrics": list.String(), "Period": req.Period, "Context": formatContext(hits),
+        })
+        if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+        var out struct {
+            Metrics []struct {
+                Name     string `json:"name"`
+                Value    string `json:"value"`
+                Unit     string `json:"unit"`
+                Period   string `json:"period"`
+                Currency string `json:"currency"`
+                ChunkID  int64  `json:"chunk_id"`
+            } `json:"metrics"`
+        }
+        repaired, err := deps.LLM.GenerateStructured(ctx, model, prompt, metricsSchema(names), opts, &out)
+        outcome := "ok"
+        var invalid *llm.StructuredError
+        switch {
+        case errors.As(err, &invalid):
+            metrics.StructuredOutputTotal.WithLabelValues("extract_metrics", "fallback").Inc()
+            writeError(w, http.StatusBadGateway, "model không trả về đúng schema: "+invalid.Reason.Error())
+            return
+        case err != nil:
+            w.WriteHeader(500)
+            return
+        case repaired:
+            outcome = "repaired"
+        }
+        metrics.StructuredOutputTotal.WithLabelValues("extract_metrics", outcome).Inc()
+
+        docLang := lang.Default
+        if langs, err := deps.Repo.DocumentLanguages(ctx, tenant, []string{req.DocumentID}); err == nil && langs[req.DocumentID] != "" { docLang = langs[req.DocumentID] }
+        byID := make(map[int64]storage.Hit, len(hits))
+        for _, h := range hits { byID[h.ID] = h }
+        values := []storage.MetricValue{}
+        var rejected []map[string]any
+        for _, m := range out.Metrics {
+            reject := func(reason string) { rejected = append(rejected, map[string]any{"name": m.Name, "value": m.Value, "chunk_id": m.ChunkID, "reason": reason}) }
+            metric, _ := finance.LookupMetric(m.Name)
+            hit, ok := byID[m.ChunkID]
+            if !ok { reject("chunk_id không nằm trong các đoạn đã cung cấp"); continue }
+            raw := strings.TrimSpace(m.Value + " " + m.Unit)
+            amount, err := finance.ParseAmount(raw, docLang)
+            if err != nil { reject(err.Error()); continue }
+            amount = metric.Normalize(amount, m.Currency)
+            // The figure must be written in the cited chunk, as given or
+            // in its normalised form; otherwise the model made it up or
+            // misread the chunk.
+            if written := finance.Numbers(raw, docLang); !finance.Mentions(hit.Content, amount.Value, docLang) && (len(written) == 0 || !finance.Mentions(hit.Content, written[0], docLang)) {
+                reject("giá trị không xuất hiện trong đoạn được trích dẫn")
+                continue
+            }
+            cit, _ := json.Marshal(citations.Single(hit, m.Value))
+            values = append(values, storage.MetricValue{
+                DocumentID: req.DocumentID, Name: m.Name, Period: finance.NormalizePeriod(m.Period),
+                Value: amount.Value, Unit: amount.Unit, Raw: raw, ChunkID: hit.ID, Citation: cit, Model: model,
+            })
+        }
+        if err := deps.Repo.UpsertMetrics(ctx, tenant, values); err != nil { log.Println("store metrics:", err); w.WriteHeader(500); return }
+        latency := time.Since(start).Milliseconds()
+        deps.audit(ctx, storage.Audit{Tenant: tenant, Endpoint: "extract_metrics", LatencyMs: latency, Model: model, PromptName: prompts.ExtractMetrics, PromptVersion: promptVersion})
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{
+            "document_id": req.DocumentID,
+            "metrics":     values,
+            "rejected":    rejected,
+            "meta":        map[string]any{"model": model, "prompt": prompts.ExtractMetrics, "prompt_version": promptVersion, "structured_output": outcome, "chunks": len(hits), "latency_ms": latency},
+        })
+    }
+}
+
+// metricHits retrieves the document's chunks most related to the metrics,
+// plus its financial statement tables.
+func (d QASumDeps) metricHits(ctx context.Context, tenant, docID string, wanted []finance.Metric, period string, topK int) ([]storage.Hit, error) {
+    var q strings.Builder
+    for _, m := range wanted { q.WriteString(m.Label + ", ") }
+    q.WriteString(period)
+    col := d.Collections.Active()
+    embeds, err := d.Caches.embed(ctx, d.LLM, col.Model, []string{q.String()})
+    if err != nil { return nil, err }
+    hits, err := retrieveHits(ctx, d.Repo, col, tenant, docID, embeds[0], topK, retrieval.SearchOptions{})
+    if err != nil { return nil, err }
+    tableIDs, err := d.Repo.StatementTableChunks(ctx, tenant, docID, statementChunks)
+    if err != nil { return nil, err }
+    seen := map[int64]bool{}
+    for _, h := range hits { seen[h.ID] = true }
+    var extra []int64
+    for _, id := range tableIDs {
+        if !seen[id] { extra = append(extra, id) }
+    }
+    tables, err := d.Repo.GetChunksByIDs(ctx, tenant, extra)
+    if err != nil { return nil, err }
+    return append(hits, tables...), nil
+}
+
+// metricsSchema constrains extraction output to the requested metrics.
+func metricsSchema(names []any) *llm.Schema {
+    one, closed := 1, false
+    return &llm.Schema{
+        Type: "object",
+        Properties: map[string]*llm.Schema{
+            "metrics": {Type: "array", Items: &llm.Schema{
+                Type: "object",
+                Properties: map[string]*llm.Schema{
+                    "name":     {Type: "string", Enum: names},
+                    "value":    {Type: "string", MinLength: &one},
+                    "unit":     {Type: "string"},
+                    "period":   {Type: "string"},
+                    "currency": {Type: "string"},
+                    "chunk_id": {Type: "integer"},
+                },
+                Required: []string{"name", "value", "unit", "period", "chunk_id"},
+            }},
+        },
+        Required:             []string{"metrics"},
+        AdditionalProperties: &closed,
+    }
+}
+
+// MakeListMetricsHandler returns the metrics stored for a document.
+func MakeListMetricsHandler(deps QASumDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        docID := chi.URLParam(r, "id")
+        values, err := deps.Repo.DocumentMetrics(r.Context(), tenantFrom(r.Context()), docID)
+        if err != nil { w.WriteHeader(500); return }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{"document_id": docID, "metrics": values})
+    }
+}
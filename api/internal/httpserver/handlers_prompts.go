@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "regexp"
+
+    "github.com/go-chi/chi/v5"
+
+    "github.com/hiepdt/contest/services/api/internal/prompts"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+var promptIDPattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// MakeListPromptsHandler lists every template name with its versions and
+// the default version of each.
+func MakeListPromptsHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        versions := deps.Prompts.Versions()
+        defaults := map[string]string{}
+        for name := range versions {
+            defaults[name] = "v1"
+            if v := deps.Prompts.Defaults[name]; v != "" { defaults[name] = v }
+        }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]any{"versions": versions, "defaults": defaults})
+    }
+}
+
+// MakeSavePromptHandler stores the request body as a template version in
+// Postgres and reloads the registry, so it can be selected right away with
+// prompt_version. The body is checked to parse before it is stored; a
+// version already stored is not replaced (409).
+func MakeSavePromptHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+        if !promptIDPattern.MatchString(name) || !promptIDPattern.MatchString(version) {
+            writeError(w, http.StatusBadRequest, "tên hoặc phiên bản prompt không hợp lệ")
+            return
+        }
+        body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+        if err != nil || len(body) == 0 { w.WriteHeader(http.StatusBadRequest); return }
+        if err := prompts.Parse(name, version, string(body)); err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        if err := deps.Repo.SavePromptTemplate(r.Context(), name, version, string(body)); err != nil {
+            if errors.Is(err, storage.ErrPromptVersionExists) { writeError(w, http.StatusConflict, "phiên bản prompt đã tồn tại; hãy lưu thành phiên bản mới"); return }
+            w.WriteHeader(500)
+            return
+        }
+        if err := deps.Prompts.Reload(r.Context()); err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// MakeReloadPromptsHandler re-reads PROMPT_DIR and Postgres, e.g. after
+// editing template files on a mounted volume.
+func MakeReloadPromptsHandler(deps AdminDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if err := deps.Prompts.Reload(r.Context()); err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
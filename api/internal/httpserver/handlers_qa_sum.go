@@ -2,13 +2,20 @@ package httpserver
 
 import (
     "encoding/json"
+    "errors"
     "net/http"
     "strings"
     "context"
     "time"
     "strconv"
 
+    "github.com/hiepdt/contest/services/api/internal/calc"
+    "github.com/hiepdt/contest/services/api/internal/citations"
+    "github.com/hiepdt/contest/services/api/internal/grounding"
+    "github.com/hiepdt/contest/services/api/internal/lang"
     "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+    "github.com/hiepdt/contest/services/api/internal/prompts"
     "github.com/hiepdt/contest/services/api/internal/retrieval"
     "github.com/hiepdt/contest/services/api/internal/storage"
 )
@@ -16,19 +23,53 @@ import (
 type QASumDeps struct {
     Repo *storage.Repository
     LLM  *llm.OllamaClient
-    EmbedModel string
-    GenModel   string
-    Faiss *retrieval.FaissClient
+    // GenModel is the default generation model; AllowedModels may also be
+    // requested per call. GenDefaults holds per-endpoint sampling options
+    // ("qa", "summarize", "chat", "extract", "expand").
+    GenModel      string
+    AllowedModels map[string]bool
+    GenDefaults   map[string]llm.GenOptions
+    Prompts       *prompts.Registry
+    // Collections.Active() embeds queries and serves retrieval.
+    Collections *retrieval.Registry
+    // Grounding verifies QA and chat answers against their citations; nil
+    // disables it.
+    Grounding *grounding.Verifier
+    // CalculatorRounds is how many rounds of calculator calls a QA answer
+    // may make; 0 disables the calculator.
+    CalculatorRounds int
+    // AgentMaxSteps caps the model turns of an agent mode answer; 0
+    // disables agent mode.
+    AgentMaxSteps int
+    Caches *Caches
+    // Limits caps concurrent generations; handlers take a slot after their
+    // cache lookups miss.
+    Limits *Limits
 }
 
 func MakeSummarizeHandler(deps QASumDeps) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         var req SummarizeRequest
         if err := json.NewDecoder(r.Body).Decode(&req); err != nil { w.WriteHeader(http.StatusBadRequest); return }
+        model, opts, err := deps.generation("summarize", req.Model, req.Options)
+        if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        var ok bool
+        if req.PromptVersion, ok = deps.Prompts.Resolve(prompts.Summarize, req.PromptVersion); !ok { writeError(w, http.StatusBadRequest, "prompt_version không tồn tại"); return }
+        if req.ResponseLanguage != "" && !lang.Valid(req.ResponseLanguage) { writeError(w, http.StatusBadRequest, "response_language phải là vi hoặc en"); return }
+        start := time.Now()
         ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
         defer cancel()
+        tenant := tenantFrom(r.Context())
+        cacheKey, cacheOK := deps.Caches.answerKey(ctx, "summarize", []string{docVersionKey(tenant, req.DocumentID)},
+            tenant, req.DocumentID, model, opts.Key(), req.PromptVersion, req.ResponseLanguage, strconv.Itoa(req.NumBullets), normalizeText(req.Category), normalizeText(req.Instruction))
+        if cacheOK {
+            if b, ok := deps.Caches.getAnswer(ctx, cacheKey); ok { writeRawJSON(w, markCached(b, nil)); return }
+        }
+        release, ok := deps.Limits.acquireLLM(w, r)
+        if !ok { return }
+        defer release()
         // Lấy vài chunk đầu của tài liệu để tóm tắt
-        chunks, _ := deps.Repo.GetChunksByDocument(ctx, req.DocumentID, 8)
+        chunks, _ := deps.Repo.GetChunksByDocument(ctx, tenant, req.DocumentID, 8)
         // Đảo lại theo thời gian (mới nhất trước) -> giữ thứ tự tự nhiên
         for i, j := 0, len(chunks)-1; i < j; i, j = i+1, j-1 { chunks[i], chunks[j] = chunks[j], chunks[i] }
         joined := strings.Join(chunks, "\n\n")
@@ -37,40 +78,84 @@ func MakeSummarizeHandler(deps QASumDeps) http.HandlerFunc {
             _ = json.NewEncoder(w).Encode(map[string]string{"error":"document_id không có dữ liệu; hãy ingest trước"})
             return
         }
+        // Summaries default to the document's own language.
+        docLang := ""
+        if langs, err := deps.Repo.DocumentLanguages(ctx, tenant, []string{req.DocumentID}); err == nil { docLang = langs[req.DocumentID] }
+        if docLang == "" { docLang = lang.Detect(joined) }
+        respLang := lang.Or(req.ResponseLanguage, lang.Or(docLang, lang.Default))
         n := req.NumBullets
         if n <= 0 { n = 5 }
         cat := req.Category
         //if cat == "" { cat = "Kết luận, rủi ro" }
 		userInst := strings.TrimSpace(req.Instruction)
         //if userInst == "" { userInst = "Tóm tắt kết luận và rủi ro chính" }
-        prompt := "Bạn là chuyên gia kinh tế tài chính. Nhiệm vụ: " + userInst + "và tạo đúng " + strconv.Itoa(n) + " gạch đầu dòng ngắn gọn (mỗi gạch 20 đến 25 từ) có ý nghĩa và insight sâu sắc từ việc summarize tài liệu giúp người dùng có thể dễ dàng hiểu được, danh mục cần tập trung là: " + cat + ". Cuối cùng đưa ra kết luận nhé.\n" +
-            "Chỉ dùng thông tin trong văn bản cung cấp.\n" +
-            "Xuất duy nhất JSON theo mẫu: {\"bullets\":[\"...\"]} với đúng " + strconv.Itoa(n) + " phần tử, không thêm tiền tố hay lời dẫn.\n" +
-            "Văn bản:\n" + joined
-        out, err := deps.LLM.Generate(ctx, prompt)
-        if err != nil { w.WriteHeader(500); return }
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusOK)
-        // Thử parse JSON theo schema yêu cầu
+        prompt, promptVersion, promptLang, err := deps.Prompts.Render(prompts.Summarize, req.PromptVersion, respLang, map[string]any{
+            "Instruction": userInst, "NumBullets": n, "Category": cat, "Text": joined, "CrossLingual": docLang != "" && docLang != respLang,
+        })
+        if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+        // Structured output; if the model still breaks the schema after a
+        // repair attempt, salvage the bullets of its last output, reading it
+        // line by line only when it is not JSON. Nothing to salvage (e.g.
+        // JSON of another shape) is a 502 with the validation error.
         var parsed struct{ Bullets []string `json:"bullets"` }
-        err = json.Unmarshal([]byte(out), &parsed)
-        lines := []string{}
-        if err == nil && len(parsed.Bullets) > 0 {
-            lines = parsed.Bullets
-        } else {
+        repaired, err := deps.LLM.GenerateStructured(ctx, model, prompt, bulletsSchema(n), opts, &parsed)
+        var invalid *llm.StructuredError
+        outcome := "ok"
+        lines := parsed.Bullets
+        switch {
+        case errors.As(err, &invalid):
+            outcome = "fallback"
+            lines = []string{}
+            raw := strings.TrimSpace(invalid.Raw)
+            var loose struct{ Bullets []any `json:"bullets"` }
+            if json.Unmarshal([]byte(raw), &loose) == nil {
+                for _, b := range loose.Bullets {
+                    if s, ok := b.(string); ok && strings.TrimSpace(s) != "" { lines = append(lines, strings.TrimSpace(s)) }
+                }
+                break
+            }
+            if json.Valid([]byte(raw)) { break }
             // Fallback: chuẩn hoá theo từng dòng
-            for _, ln := range strings.Split(strings.TrimSpace(out), "\n") {
+            for _, ln := range strings.Split(raw, "\n") {
                 ln = strings.TrimSpace(strings.TrimLeft(ln, "-•* "))
                 if ln != "" && !strings.EqualFold(ln, "gạch đầu dòng:") { lines = append(lines, ln) }
             }
+        case err != nil:
+            w.WriteHeader(500)
+            return
+        case repaired:
+            outcome = "repaired"
+        }
+        metrics.StructuredOutputTotal.WithLabelValues("summarize", outcome).Inc()
+        if invalid != nil && len(lines) == 0 {
+            writeError(w, http.StatusBadGateway, "model không trả về đúng schema: "+invalid.Reason.Error())
+            return
         }
         if len(lines) > n { lines = lines[:n] }
+        latency := time.Since(start).Milliseconds()
+        deps.audit(ctx, storage.Audit{Tenant: tenant, Endpoint: "summarize", LatencyMs: latency, Model: model, PromptName: prompts.Summarize, PromptVersion: promptVersion})
         resp := map[string]any{
             "sections": []map[string]any{{"title": cat, "bullets": lines}},
             "citations": []any{},
-            "meta": map[string]any{"model": deps.GenModel, "prompt_tokens": 0, "completion_tokens": 0, "latency_ms": 0},
+            "cached": false,
+            "meta": map[string]any{"model": model, "options": opts, "structured_output": outcome, "prompt": prompts.Summarize, "prompt_version": promptVersion, "language": respLang, "prompt_language": promptLang, "document_language": docLang, "prompt_tokens": 0, "completion_tokens": 0, "latency_ms": latency},
         }
-        _ = json.NewEncoder(w).Encode(resp)
+        b, _ := json.Marshal(resp)
+        if cacheOK { deps.Caches.setAnswer(ctx, cacheKey, b) }
+        writeRawJSON(w, b)
+    }
+}
+
+// bulletsSchema is the summary format: exactly n non-empty bullets.
+func bulletsSchema(n int) *llm.Schema {
+    one, closed := 1, false
+    return &llm.Schema{
+        Type: "object",
+        Properties: map[string]*llm.Schema{
+            "bullets": {Type: "array", Items: &llm.Schema{Type: "string", MinLength: &one}, MinItems: &n, MaxItems: &n},
+        },
+        Required:             []string{"bullets"},
+        AdditionalProperties: &closed,
     }
 }
 
@@ -79,38 +164,101 @@ func MakeQAHandler(deps QASumDeps) http.HandlerFunc {
         var req QARequest
         if err := json.NewDecoder(r.Body).Decode(&req); err != nil { w.WriteHeader(http.StatusBadRequest); return }
         if req.TopK <= 0 { req.TopK = 5 }
+        if req.NProbe < 0 || req.EfSearch < 0 { writeError(w, http.StatusBadRequest, "nprobe và ef_search không được âm"); return }
+        switch req.Mode {
+        case "":
+        case "compare":
+            deps.compare(w, r, req)
+            return
+        case "agent":
+            deps.agent(w, r, req)
+            return
+        default:
+            writeError(w, http.StatusBadRequest, "mode không hợp lệ")
+            return
+        }
+        model, opts, err := deps.generation("qa", req.Model, req.Options)
+        if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        // Keyed and rendered by the resolved version, so cached answers follow
+        // a change of the default.
+        var ok bool
+        if req.PromptVersion, ok = deps.Prompts.Resolve(prompts.QA, req.PromptVersion); !ok { writeError(w, http.StatusBadRequest, "prompt_version không tồn tại"); return }
+        respLang, err := responseLanguage(req.ResponseLanguage, req.Question)
+        if err != nil { writeError(w, http.StatusBadRequest, err.Error()); return }
+        if !validRetrieval(req.Retrieval) { writeError(w, http.StatusBadRequest, "retrieval phải là multi_query, hyde hoặc bỏ trống"); return }
+        start := time.Now()
         ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
         defer cancel()
-        embeds, err := deps.LLM.Embeddings(ctx, deps.EmbedModel, []string{req.Question})
-        if err != nil || len(embeds) == 0 { w.WriteHeader(500); return }
-        var hits []struct{ID int64; DocID string; Content string; Score float32}
-        if deps.Faiss != nil {
-            _, _, err := deps.Faiss.Search(ctx, embeds[0], req.TopK)
-            if err == nil {
-                // Chưa map id->content nên tạm thời dùng fallback DB ở dưới
+        tenant := tenantFrom(r.Context())
+        // Giới hạn theo document hiện hành nếu client gửi kèm bằng cách đặt câu hỏi dạng: [doc:<id>] ...
+        docScoped := ""
+        if strings.HasPrefix(strings.TrimSpace(req.Question), "[doc:") {
+            if p := strings.Index(req.Question, "]"); p > 5 {
+                docScoped = req.Question[5:p]
             }
         }
-        if len(hits) == 0 {
-            // Giới hạn theo document hiện hành nếu client gửi kèm bằng cách đặt câu hỏi dạng: [doc:<id>] ...
-            docScoped := ""
-            if strings.HasPrefix(strings.TrimSpace(req.Question), "[doc:") {
-                if p := strings.Index(req.Question, "]"); p > 5 {
-                    docScoped = req.Question[5:p]
-                }
-            }
-            if docScoped != "" {
-                hits, err = deps.Repo.SimilarChunksByDoc(ctx, docScoped, embeds[0], req.TopK)
-            } else {
-                hits, err = deps.Repo.SimilarChunks(ctx, embeds[0], req.TopK)
-            }
+        versionKey := tenantVersionKey(tenant)
+        if docScoped != "" { versionKey = docVersionKey(tenant, docScoped) }
+        // The query must be embedded by the model of the index it searches.
+        col := deps.Collections.Active()
+        cacheKey, cacheOK := deps.Caches.answerKey(ctx, "qa", []string{versionKey},
+            tenant, model, opts.Key(), req.PromptVersion, respLang, col.Model, strconv.Itoa(req.TopK), strconv.Itoa(req.NProbe), strconv.Itoa(req.EfSearch), req.Retrieval, strconv.FormatBool(req.Debug), normalizeText(req.Question))
+        if cacheOK {
+            if b, ok := deps.Caches.getAnswer(ctx, cacheKey); ok { writeRawJSON(w, markCached(b, nil)); return }
+        }
+        embeds, err := deps.Caches.embed(ctx, deps.LLM, col.Model, []string{req.Question})
+        if err != nil || len(embeds) == 0 { w.WriteHeader(500); return }
+        // A similar question's answer would carry that question's debug
+        // output, so debug requests skip the semantic cache.
+        scope := semanticScope(tenant, docScoped, col.Model, model, opts.Key(), req.PromptVersion, respLang, req.Retrieval,
+            strconv.Itoa(req.TopK), strconv.Itoa(req.NProbe), strconv.Itoa(req.EfSearch))
+        semVer := int64(-1)
+        if !req.Debug {
+            b, ver, ok := deps.Caches.lookupSemantic(ctx, scope, versionKey, embeds[0])
+            if ok { writeRawJSON(w, b); return }
+            semVer = ver
+        }
+        release, ok := deps.Limits.acquireLLM(w, r)
+        if !ok { return }
+        defer release()
+        search := retrieval.SearchOptions{NProbe: req.NProbe, EfSearch: req.EfSearch}
+        var hits []storage.Hit
+        var expansion *Expansion
+        if req.Retrieval == "" {
+            hits, err = retrieveHits(ctx, deps.Repo, col, tenant, docScoped, embeds[0], req.TopK, search)
+        } else {
+            hits, expansion, err = deps.expandedHits(ctx, col, tenant, docScoped, req.Retrieval, model, respLang, req.Question, embeds[0], req.TopK, search)
         }
         if err != nil { w.WriteHeader(500); return }
-        var contextStr strings.Builder
-        for _, h := range hits { contextStr.WriteString("- "); contextStr.WriteString(h.Content); contextStr.WriteString("\n") }
-        prompt := "Bạn là trợ lý tài chính. Dựa trên ngữ cảnh sau, trả lời ngắn gọn, trích dẫn các đoạn liên quan cuối câu theo dạng [#id].\nNgữ cảnh:\n" + contextStr.String() + "\nCâu hỏi: " + req.Question
-        ans, err := deps.LLM.Generate(ctx, prompt)
-        if err != nil { w.WriteHeader(500); return }
-        _ = json.NewEncoder(w).Encode(map[string]any{"answer": strings.TrimSpace(ans), "citations": hits})
+        meta := map[string]any{"model": model, "prompt": prompts.QA, "language": respLang}
+        if req.Retrieval != "" { meta["retrieval"] = req.Retrieval }
+        var ans string
+        var verdict any
+        var steps []calc.Step
+        if res, abstain := deps.precheck("qa", respLang, hits); abstain {
+            // Nothing retrieved can ground an answer; do not ask the model.
+            ans, hits, verdict = res.Answer, hits[:0], res
+        } else {
+            prompt, promptVersion, promptLang, err := deps.Prompts.Render(prompts.QA, req.PromptVersion, respLang, map[string]any{
+                "Context": formatContext(hits), "Question": req.Question, "CrossLingual": deps.crossLingual(ctx, tenant, respLang, req.Question, hits),
+            })
+            if err != nil { writeError(w, http.StatusInternalServerError, err.Error()); return }
+            ans, steps, err = deps.generateWithCalculator(ctx, model, prompt, opts, respLang, hits)
+            if err != nil { w.WriteHeader(500); return }
+            ans, hits, verdict = deps.ground(ctx, "qa", model, respLang, strings.TrimSpace(ans), hits)
+            meta["prompt_version"], meta["prompt_language"] = promptVersion, promptLang
+            deps.audit(ctx, storage.Audit{Tenant: tenant, Endpoint: "qa", LatencyMs: time.Since(start).Milliseconds(), Model: model, PromptName: prompts.QA, PromptVersion: promptVersion})
+        }
+        meta["latency_ms"] = time.Since(start).Milliseconds()
+        cites, markers := citations.Build(ans, hits)
+        resp := map[string]any{"answer": ans, "citations": cites, "markers": markers, "cached": false, "meta": meta}
+        if verdict != nil { resp["grounding"] = verdict }
+        if len(steps) > 0 { resp["calculations"] = steps }
+        if req.Debug { resp["debug"] = map[string]any{"question": req.Question, "expansion": expansion} }
+        b, _ := json.Marshal(resp)
+        if cacheOK { deps.Caches.setAnswer(ctx, cacheKey, b) }
+        deps.Caches.storeSemantic(ctx, scope, semVer, req.Question, embeds[0], b)
+        writeRawJSON(w, b)
     }
 }
 
@@ -0,0 +1,43 @@
+package httpserver
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/storage"
+    "github.com/hiepdt/contest/services/api/internal/upstream"
+)
+
+type ReadyDeps struct {
+    DB       *storage.Database
+    Breakers []*upstream.Breaker
+}
+
+// MakeReadyHandler reports whether the API can serve traffic: Postgres
+// answers and no upstream circuit is open. /health only says the process is
+// up. An open circuit makes the API not ready until its cooldown ends and a
+// trial call succeeds; half-open counts as ready so that trial can happen.
+func MakeReadyHandler(deps ReadyDeps) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+        defer cancel()
+        ready := true
+        checks := map[string]string{"postgres": "ok"}
+        if err := deps.DB.Pool.Ping(ctx); err != nil {
+            ready = false
+            checks["postgres"] = err.Error()
+        }
+        for _, b := range deps.Breakers {
+            st := b.State()
+            checks[b.Name] = st.String()
+            if st == upstream.Open { ready = false }
+        }
+        status := http.StatusOK
+        if !ready { status = http.StatusServiceUnavailable }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        _ = json.NewEncoder(w).Encode(map[string]any{"ready": ready, "checks": checks})
+    }
+}
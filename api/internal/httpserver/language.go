@@ -0,0 +1,42 @@
+package httpserver
+
+import (
+    "context"
+    "errors"
+    "log"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// responseLanguage is the language an answer is written in: the caller's
+// response_language, else the language detected in text (the question),
+// else the default.
+func responseLanguage(requested, text string) (string, error) {
+    if requested != "" {
+        if !lang.Valid(requested) { return "", errors.New("response_language phải là vi hoặc en") }
+        return requested, nil
+    }
+    return lang.Or(lang.Detect(text), lang.Default), nil
+}
+
+// crossLingual reports whether the question or a retrieved document is in
+// a language other than the answer's, so the prompt asks the model to
+// answer in that language anyway. Documents ingested before languages were
+// recorded are judged from the retrieved text.
+func (d QASumDeps) crossLingual(ctx context.Context, tenant, answer, question string, hits []storage.Hit) bool {
+    if q := lang.Detect(question); q != "" && q != answer { return true }
+    var docIDs []string
+    seen := map[string]bool{}
+    for _, h := range hits {
+        if !seen[h.DocID] { seen[h.DocID] = true; docIDs = append(docIDs, h.DocID) }
+    }
+    langs, err := d.Repo.DocumentLanguages(ctx, tenant, docIDs)
+    if err != nil { log.Println("document languages:", err) }
+    for _, h := range hits {
+        l, ok := langs[h.DocID]
+        if !ok { l = lang.Detect(h.Content) }
+        if l != "" && l != answer { return true }
+    }
+    return false
+}
@@ -0,0 +1,147 @@
+package httpserver
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "log"
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/cache"
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+)
+
+// Limits configures Redis-backed limits shared by all API replicas. A zero
+// RatePerSec or MaxConcurrentLLM disables that limit; a nil Cache disables both.
+// Limits fail open: if Redis is unreachable requests are let through.
+type Limits struct {
+    Cache            *cache.Cache
+    RatePerSec       float64
+    Burst            int
+    MaxConcurrentLLM int
+    // LLMLease bounds how long a crashed request can hold a generation slot.
+    LLMLease      time.Duration
+    LLMRetryAfter time.Duration
+    // TrustedProxies are the peers whose X-Forwarded-For is believed;
+    // anyone else is limited by the address it connects from.
+    TrustedProxies []*net.IPNet
+}
+
+const llmSemaphoreKey = "sem:llm"
+
+// rateLimitMiddleware applies a token bucket per validated API key, or per
+// client IP otherwise. It must run after tenantMiddleware.
+func rateLimitMiddleware(l *Limits) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        if l == nil || l.Cache == nil || l.RatePerSec <= 0 { return next }
+        burst := l.Burst
+        if burst <= 0 { burst = int(math.Ceil(l.RatePerSec)) }
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            ok, wait, err := l.Cache.Allow(r.Context(), "rl:"+l.clientKey(r), l.RatePerSec, burst)
+            if err != nil {
+                log.Println("ratelimit:", err)
+            } else if !ok {
+                metrics.RateLimitedTotal.WithLabelValues("rate").Inc()
+                tooManyRequests(w, wait, "vượt quá giới hạn số request, vui lòng thử lại sau")
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// acquireLLM takes one of the deployment's concurrent LLM generation slots
+// for the request. Handlers call it only once their caches missed, so cached
+// answers are served even when every slot is busy. When no slot is free it
+// writes 429 and returns false; otherwise the caller must call release.
+func (l *Limits) acquireLLM(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+    release = func() {}
+    if l == nil || l.Cache == nil || l.MaxConcurrentLLM <= 0 { return release, true }
+    holder := randomToken()
+    ok, err := l.Cache.Acquire(r.Context(), llmSemaphoreKey, holder, l.MaxConcurrentLLM, l.LLMLease)
+    if err != nil {
+        log.Println("llm semaphore:", err)
+        return release, true
+    }
+    if !ok {
+        metrics.RateLimitedTotal.WithLabelValues("llm_concurrency").Inc()
+        tooManyRequests(w, l.LLMRetryAfter, "hệ thống đang bận xử lý, vui lòng thử lại sau")
+        return release, false
+    }
+    return func() {
+        // Release even if the client went away.
+        ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+        defer cancel()
+        _ = l.Cache.Release(ctx, llmSemaphoreKey, holder)
+    }, true
+}
+
+// clientKey identifies the caller without putting raw API keys into Redis.
+// Only a key tenantMiddleware accepted counts, so made-up keys do not buy a
+// fresh bucket, and the address is the TCP peer unless that is a trusted
+// proxy.
+func (l *Limits) clientKey(r *http.Request) string {
+    if k := validatedKeyFrom(r.Context()); k != "" {
+        sum := sha256.Sum256([]byte(k))
+        return "key:" + hex.EncodeToString(sum[:8])
+    }
+    return "ip:" + l.clientIP(r)
+}
+
+// clientIP is the peer address, or for a trusted proxy the right-most
+// X-Forwarded-For entry not itself a trusted proxy.
+func (l *Limits) clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil { host = r.RemoteAddr }
+    if !l.trusted(host) { return host }
+    hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+    for i := len(hops) - 1; i >= 0; i-- {
+        hop := strings.TrimSpace(hops[i])
+        if net.ParseIP(hop) == nil { break }
+        host = hop
+        if !l.trusted(hop) { break }
+    }
+    return host
+}
+
+func (l *Limits) trusted(addr string) bool {
+    ip := net.ParseIP(addr)
+    if ip == nil { return false }
+    for _, n := range l.TrustedProxies {
+        if n.Contains(ip) { return true }
+    }
+    return false
+}
+
+// ParseCIDRs parses TRUSTED_PROXIES entries; a bare address is one host.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+    var out []*net.IPNet
+    for _, s := range list {
+        if !strings.Contains(s, "/") {
+            if ip := net.ParseIP(s); ip != nil && ip.To4() != nil { s += "/32" } else { s += "/128" }
+        }
+        _, n, err := net.ParseCIDR(s)
+        if err != nil { return nil, err }
+        out = append(out, n)
+    }
+    return out, nil
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+    secs := int(math.Ceil(wait.Seconds()))
+    if secs < 1 { secs = 1 }
+    w.Header().Set("Retry-After", strconv.Itoa(secs))
+    writeError(w, http.StatusTooManyRequests, msg)
+}
+
+func randomToken() string {
+    var b [12]byte
+    _, _ = rand.Read(b[:])
+    return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,46 @@
+package httpserver
+
+import (
+    "context"
+    "strconv"
+    "strings"
+
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// retrieveHits finds the topK chunks of col closest to vec within the tenant,
+// optionally limited to one document, and loads their content. vec must come
+// from col's model. Ids are re-read from Postgres under the tenant, so rows
+// deleted since indexing are dropped.
+func retrieveHits(ctx context.Context, repo *storage.Repository, col *retrieval.Collection, tenant, docScoped string, vec []float32, topK int, opts retrieval.SearchOptions) ([]storage.Hit, error) {
+    if docScoped != "" { opts.DocIDs = []string{docScoped} }
+    found, err := col.Index.Search(ctx, tenant, vec, topK, opts)
+    if err != nil { return nil, err }
+    return loadHits(ctx, repo, tenant, found)
+}
+
+// loadHits loads the content of index results, keeping their order and
+// scores.
+func loadHits(ctx context.Context, repo *storage.Repository, tenant string, found []retrieval.Hit) ([]storage.Hit, error) {
+    ids := make([]int64, len(found))
+    scoreByID := make(map[int64]float32, len(found))
+    for i, h := range found { ids[i] = h.ID; scoreByID[h.ID] = h.Score }
+    hits, err := repo.GetChunksByIDs(ctx, tenant, ids)
+    if err != nil { return nil, err }
+    for i := range hits { hits[i].Score = scoreByID[hits[i].ID] }
+    return hits, nil
+}
+
+// formatContext renders hits for a prompt, one "- [#id] content" line each.
+func formatContext(hits []storage.Hit) string {
+    var b strings.Builder
+    for _, h := range hits {
+        b.WriteString("- [#")
+        b.WriteString(strconv.FormatInt(h.ID, 10))
+        b.WriteString("] ")
+        b.WriteString(h.Content)
+        b.WriteString("\n")
+    }
+    return b.String()
+}
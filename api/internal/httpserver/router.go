@@ -11,6 +11,29 @@ type API struct{
     IngestHandler http.HandlerFunc
     SummarizeHandler http.HandlerFunc
     QAHandler http.HandlerFunc
+    DeleteDocumentHandler http.HandlerFunc
+    ListTablesHandler http.HandlerFunc
+    ListMetricsHandler http.HandlerFunc
+    ExtractMetricsHandler http.HandlerFunc
+    CreateConversationHandler http.HandlerFunc
+    ListMessagesHandler http.HandlerFunc
+    ConversationMessageHandler http.HandlerFunc
+    ReindexHandler http.HandlerFunc
+    IndexStatsHandler http.HandlerFunc
+    TrainIndexHandler http.HandlerFunc
+    RecallBenchmarkHandler http.HandlerFunc
+    StartEmbeddingMigrationHandler http.HandlerFunc
+    ListEmbeddingMigrationsHandler http.HandlerFunc
+    CancelEmbeddingMigrationHandler http.HandlerFunc
+    ListPromptsHandler http.HandlerFunc
+    SavePromptHandler http.HandlerFunc
+    ReloadPromptsHandler http.HandlerFunc
+    ReadyHandler http.HandlerFunc
+    AdminKey string
+    // APIKeys maps API keys to tenants; see tenantMiddleware.
+    APIKeys map[string]string
+    DefaultTenant string
+    Limits *Limits
 }
 
 func NewRouter(a *API) http.Handler {
@@ -20,10 +43,36 @@ func NewRouter(a *API) http.Handler {
         w.WriteHeader(http.StatusOK)
         _, _ = w.Write([]byte(`{"status":"ok"}`))
     })
+    r.Get("/ready", a.ReadyHandler)
 
-    r.Post("/ingest", a.IngestHandler)
-    r.Post("/summarize", a.SummarizeHandler)
-    r.Post("/qa", a.QAHandler)
+    r.Route("/admin", func(r chi.Router) {
+        r.Use(adminMiddleware(a.AdminKey))
+        r.Post("/reindex", a.ReindexHandler)
+        r.Get("/index/stats", a.IndexStatsHandler)
+        r.Post("/index/train", a.TrainIndexHandler)
+        r.Post("/index/benchmark", a.RecallBenchmarkHandler)
+        r.Post("/embeddings/migrations", a.StartEmbeddingMigrationHandler)
+        r.Get("/embeddings/migrations", a.ListEmbeddingMigrationsHandler)
+        r.Post("/embeddings/migrations/{id}/cancel", a.CancelEmbeddingMigrationHandler)
+        r.Get("/prompts", a.ListPromptsHandler)
+        r.Put("/prompts/{name}/{version}", a.SavePromptHandler)
+        r.Post("/prompts/reload", a.ReloadPromptsHandler)
+    })
+
+    r.Group(func(r chi.Router) {
+        r.Use(tenantMiddleware(a.APIKeys, a.DefaultTenant))
+        r.Use(rateLimitMiddleware(a.Limits))
+        r.Post("/ingest", a.IngestHandler)
+        r.Delete("/documents/{id}", a.DeleteDocumentHandler)
+        r.Get("/documents/{id}/tables", a.ListTablesHandler)
+        r.Get("/documents/{id}/metrics", a.ListMetricsHandler)
+        r.Post("/extract/metrics", a.ExtractMetricsHandler)
+        r.Post("/summarize", a.SummarizeHandler)
+        r.Post("/qa", a.QAHandler)
+        r.Post("/conversations", a.CreateConversationHandler)
+        r.Get("/conversations/{id}/messages", a.ListMessagesHandler)
+        r.Post("/conversations/{id}/messages", a.ConversationMessageHandler)
+    })
     return r
 }
 
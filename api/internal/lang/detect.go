@@ -0,0 +1,86 @@
+// Package lang detects whether a text is Vietnamese or English, the two
+// languages of the filings and questions the service handles.
+package lang
+
+import (
+    "strings"
+    "unicode"
+)
+
+// Supported language codes. Vietnamese is the default for text that gives
+// no clear signal, e.g. "EPS 2023".
+const (
+    Vietnamese = "vi"
+    English    = "en"
+    Default    = Vietnamese
+)
+
+// Valid reports whether code is a supported language.
+func Valid(code string) bool { return code == Vietnamese || code == English }
+
+// Name is the language's name as written in prompts.
+func Name(code string) string {
+    if code == English { return "English" }
+    return "tiếng Việt"
+}
+
+// vietnameseLetters are letters that only occur in Vietnamese among the two
+// languages (lower case; tone marks and ă â đ ê ô ơ ư).
+const vietnameseLetters = "àáảãạăằắẳẵặâầấẩẫậđèéẻẽẹêềếểễệìíỉĩịòóỏõọôồốổỗộơờớởỡợùúủũụưừứửữựỳýỷỹỵ"
+
+// Frequent words used when the text carries no diacritics; Vietnamese typed
+// without accents is common in questions.
+var (
+    viWords = set("va la cua cac nhung trong duoc khong bao nhieu nao gi nam quy thu loi nhuan doanh co cho voi tang giam so sanh ty dong")
+    enWords = set("the and of is are was were what how which why in to for with by did does revenue profit year quarter growth compared")
+)
+
+// maxRunes bounds the work spent on long documents; the opening is enough.
+const maxRunes = 4000
+
+// Detect returns Vietnamese, English, or "" when the text gives no signal.
+// Diacritics decide first: a few percent of Vietnamese-only letters (or
+// combining marks, for decomposed input) mark Vietnamese. Otherwise frequent
+// function words are counted.
+func Detect(text string) string {
+    letters, marked, n := 0, 0, 0
+    for _, r := range text {
+        if n++; n > maxRunes { break }
+        if unicode.Is(unicode.Mn, r) { marked++; continue }
+        if !unicode.IsLetter(r) { continue }
+        letters++
+        if strings.ContainsRune(vietnameseLetters, unicode.ToLower(r)) { marked++ }
+    }
+    if letters == 0 { return "" }
+    if marked*100 >= letters*3 { return Vietnamese }
+    vi, en := 0, 0
+    words := strings.FieldsFunc(strings.ToLower(truncate(text)), func(r rune) bool { return !unicode.IsLetter(r) })
+    for _, w := range words {
+        if viWords[w] { vi++ }
+        if enWords[w] { en++ }
+    }
+    switch {
+    case en > vi:
+        return English
+    case vi > en:
+        return Vietnamese
+    }
+    return ""
+}
+
+// Or returns code, or fallback when code is "".
+func Or(code, fallback string) string {
+    if code == "" { return fallback }
+    return code
+}
+
+func truncate(s string) string {
+    if len(s) > maxRunes*4 { return s[:maxRunes*4] }
+    return s
+}
+
+func set(words string) map[string]bool {
+    m := map[string]bool{}
+    for _, w := range strings.Fields(words) { m[w] = true }
+    return m
+}
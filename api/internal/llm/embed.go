@@ -0,0 +1,72 @@
+package llm
+
+import (
+    "context"
+    "fmt"
+    "sync"
+)
+
+type embedRequest struct {
+    Model string   `json:"model"`
+    Input []string `json:"input"`
+}
+
+type embedResponse struct {
+    Embeddings [][]float32 `json:"embeddings"`
+}
+
+// Embeddings returns one vector per input, in order, using Ollama's
+// /api/embed. Inputs are split into batches sent concurrently; the result is
+// checked to hold exactly len(input) vectors of one dimension, so callers can
+// index it by input position.
+func (c *OllamaClient) Embeddings(ctx context.Context, model string, input []string) ([][]float32, error) {
+    if model == "" { model = "nomic-embed-text" }
+    if len(input) == 0 { return nil, nil }
+    out := make([][]float32, len(input))
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    var (
+        wg       sync.WaitGroup
+        once     sync.Once
+        firstErr error
+    )
+    sem := make(chan struct{}, c.embedParallel)
+    for start := 0; start < len(input); start += c.embedBatch {
+        end := min(start+c.embedBatch, len(input))
+        wg.Add(1)
+        go func(start, end int) {
+            defer wg.Done()
+            select {
+            case sem <- struct{}{}:
+                defer func() { <-sem }()
+            case <-ctx.Done():
+                return
+            }
+            vecs, err := c.embedBatchCall(ctx, model, input[start:end])
+            if err != nil {
+                once.Do(func() { firstErr = err; cancel() })
+                return
+            }
+            copy(out[start:end], vecs)
+        }(start, end)
+    }
+    wg.Wait()
+    if firstErr != nil { return nil, firstErr }
+    if err := ctx.Err(); err != nil { return nil, err }
+    dim := len(out[0])
+    for i, v := range out {
+        if len(v) == 0 || len(v) != dim {
+            return nil, fmt.Errorf("ollama embed %s: input %d has %d dimensions, want %d", model, i, len(v), dim)
+        }
+    }
+    return out, nil
+}
+
+func (c *OllamaClient) embedBatchCall(ctx context.Context, model string, input []string) ([][]float32, error) {
+    var out embedResponse
+    if err := c.post(ctx, "embed", "/api/embed", embedRequest{Model: model, Input: input}, &out); err != nil { return nil, err }
+    if len(out.Embeddings) != len(input) {
+        return nil, fmt.Errorf("ollama embed %s: got %d vectors for %d inputs", model, len(out.Embeddings), len(input))
+    }
+    return out.Embeddings, nil
+}
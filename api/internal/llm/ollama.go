@@ -4,15 +4,22 @@ import (
     "bytes"
     "context"
     "encoding/json"
-    "fmt"
+    "io"
     "net/http"
     "time"
+
+    "github.com/hiepdt/contest/services/api/internal/upstream"
 )
 
 type OllamaClient struct {
     host      string
     modelName string
     httpc     *http.Client
+    // embedBatch is the most inputs sent per /api/embed call and
+    // embedParallel how many calls of one Embeddings run at once.
+    embedBatch    int
+    embedParallel int
+    guard         *upstream.Guard
 }
 
 func NewOllama(host, model string) *OllamaClient {
@@ -20,14 +27,64 @@ func NewOllama(host, model string) *OllamaClient {
         host:      host,
         modelName: model,
         httpc: &http.Client{Timeout: 120 * time.Second},
+        embedBatch:    32,
+        embedParallel: 2,
+        guard:         upstream.DefaultGuard("ollama"),
     }
 }
 
+// WithGuard replaces the default retry policy and circuit breaker.
+func (c *OllamaClient) WithGuard(g *upstream.Guard) *OllamaClient {
+    c.guard = g
+    return c
+}
+
+// Breaker exposes the circuit breaker for readiness checks.
+func (c *OllamaClient) Breaker() *upstream.Breaker { return c.guard.Breaker }
+
+// post sends in as JSON to path and decodes the response into out, retrying
+// transient failures through the guard. Non-2xx responses become
+// *upstream.StatusError with Ollama's error message.
+func (c *OllamaClient) post(ctx context.Context, op, path string, in, out any) error {
+    b, err := json.Marshal(in)
+    if err != nil { return err }
+    return c.guard.Call(ctx, func(ctx context.Context) error {
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.host+path, bytes.NewReader(b))
+        if err != nil { return err }
+        req.Header.Set("Content-Type", "application/json")
+        resp, err := c.httpc.Do(req)
+        if err != nil { return err }
+        defer resp.Body.Close()
+        if resp.StatusCode >= 300 { return statusError(op, resp) }
+        return json.NewDecoder(resp.Body).Decode(out)
+    })
+}
+
+// statusError turns a non-2xx response into an error carrying Ollama's
+// message, e.g. `model "x" not found`.
+func statusError(op string, resp *http.Response) error {
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+    var e struct{ Error string `json:"error"` }
+    msg := string(bytes.TrimSpace(body))
+    if json.Unmarshal(body, &e) == nil && e.Error != "" { msg = e.Error }
+    return upstream.NewStatusError("ollama", op, resp, msg)
+}
+
+// WithEmbedBatching sets the batch size and concurrency of Embeddings;
+// values <= 0 keep the defaults.
+func (c *OllamaClient) WithEmbedBatching(batch, parallel int) *OllamaClient {
+    if batch > 0 { c.embedBatch = batch }
+    if parallel > 0 { c.embedParallel = parallel }
+    return c
+}
+
 type generateRequest struct {
     Model  string            `json:"model"`
     Prompt string            `json:"prompt"`
     Stream bool              `json:"stream"`
     Options map[string]any   `json:"options,omitempty"`
+    // Format is "json" or a JSON schema for structured output.
+    Format  json.RawMessage  `json:"format,omitempty"`
 }
 
 type generateResponse struct {
@@ -36,41 +93,87 @@ type generateResponse struct {
 }
 
 func (c *OllamaClient) Generate(ctx context.Context, prompt string) (string, error) {
-    reqBody := generateRequest{Model: c.modelName, Prompt: prompt, Stream: false}
-    b, _ := json.Marshal(reqBody)
-    url := fmt.Sprintf("%s/api/generate", c.host)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
-    req.Header.Set("Content-Type", "application/json")
-    resp, err := c.httpc.Do(req)
-    if err != nil { return "", err }
-    defer resp.Body.Close()
+    return c.GenerateWith(ctx, "", prompt, GenOptions{})
+}
+
+// GenerateWith is Generate with an explicit model ("" for the client's) and
+// sampling options.
+func (c *OllamaClient) GenerateWith(ctx context.Context, model, prompt string, opts GenOptions) (string, error) {
+    return c.generate(ctx, model, prompt, nil, opts)
+}
+
+func (c *OllamaClient) generate(ctx context.Context, model, prompt string, format json.RawMessage, opts GenOptions) (string, error) {
+    if model == "" { model = c.modelName }
+    reqBody := generateRequest{Model: model, Prompt: prompt, Stream: false, Options: opts.toMap(), Format: format}
     var out generateResponse
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return "", err }
+    if err := c.post(ctx, "generate", "/api/generate", reqBody, &out); err != nil { return "", err }
     return out.Response, nil
 }
 
-type embedRequest struct {
-    Model string   `json:"model"`
-    Input []string `json:"input"`
+// ChatMessage is one turn for Ollama's /api/chat; Role is system, user,
+// assistant or tool. ToolCalls are the calls an assistant turn requests;
+// a tool turn answers one of them and names the tool in ToolName.
+type ChatMessage struct {
+    Role      string     `json:"role"`
+    Content   string     `json:"content"`
+    ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+    ToolName  string     `json:"tool_name,omitempty"`
+}
+
+// Tool is a function the model may call; Parameters is the JSON schema of
+// its arguments.
+type Tool struct {
+    Type     string       `json:"type"`
+    Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+    Name        string  `json:"name"`
+    Description string  `json:"description"`
+    Parameters  *Schema `json:"parameters"`
 }
 
-type embedResponse struct {
-    Embeddings [][]float32 `json:"embeddings"`
+// ToolCall is a call requested by the model, with its arguments as JSON.
+type ToolCall struct {
+    Function struct {
+        Name      string          `json:"name"`
+        Arguments json.RawMessage `json:"arguments"`
+    } `json:"function"`
 }
 
-func (c *OllamaClient) Embeddings(ctx context.Context, model string, input []string) ([][]float32, error) {
-    if model == "" { model = "nomic-embed-text" }
-    reqBody := embedRequest{Model: model, Input: input}
-    b, _ := json.Marshal(reqBody)
-    url := fmt.Sprintf("%s/api/embeddings", c.host)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
-    req.Header.Set("Content-Type", "application/json")
-    resp, err := c.httpc.Do(req)
-    if err != nil { return nil, err }
-    defer resp.Body.Close()
-    var out embedResponse
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return nil, err }
-    return out.Embeddings, nil
+type chatRequest struct {
+    Model    string         `json:"model"`
+    Messages []ChatMessage  `json:"messages"`
+    Tools    []Tool         `json:"tools,omitempty"`
+    Stream   bool           `json:"stream"`
+    Options  map[string]any `json:"options,omitempty"`
 }
 
+type chatResponse struct {
+    Message ChatMessage `json:"message"`
+    Done    bool        `json:"done"`
+}
+
+func (c *OllamaClient) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+    return c.ChatWith(ctx, "", messages, GenOptions{})
+}
 
+// ChatWith is Chat with an explicit model ("" for the client's) and sampling
+// options.
+func (c *OllamaClient) ChatWith(ctx context.Context, model string, messages []ChatMessage, opts GenOptions) (string, error) {
+    if model == "" { model = c.modelName }
+    var out chatResponse
+    if err := c.post(ctx, "chat", "/api/chat", chatRequest{Model: model, Messages: messages, Stream: false, Options: opts.toMap()}, &out); err != nil { return "", err }
+    return out.Message.Content, nil
+}
+
+// ChatTools is ChatWith offering tools to the model. The returned message
+// either holds ToolCalls to run, to be answered with "tool" messages in the
+// next call, or the final Content. Models without tool support make Ollama
+// answer 400.
+func (c *OllamaClient) ChatTools(ctx context.Context, model string, messages []ChatMessage, tools []Tool, opts GenOptions) (ChatMessage, error) {
+    if model == "" { model = c.modelName }
+    var out chatResponse
+    if err := c.post(ctx, "chat", "/api/chat", chatRequest{Model: model, Messages: messages, Tools: tools, Stream: false, Options: opts.toMap()}, &out); err != nil { return ChatMessage{}, err }
+    return out.Message, nil
+}
@@ -0,0 +1,59 @@
+package llm
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+// GenOptions are Ollama sampling options. Nil fields are left to the model's
+// defaults (or to a lower-priority GenOptions, see Merge).
+type GenOptions struct {
+    Temperature *float64 `json:"temperature,omitempty"`
+    TopP        *float64 `json:"top_p,omitempty"`
+    NumCtx      *int     `json:"num_ctx,omitempty"`
+    NumPredict  *int     `json:"num_predict,omitempty"`
+    Seed        *int     `json:"seed,omitempty"`
+}
+
+// Merge returns o with every field set in over replacing it.
+func (o GenOptions) Merge(over GenOptions) GenOptions {
+    if over.Temperature != nil { o.Temperature = over.Temperature }
+    if over.TopP != nil { o.TopP = over.TopP }
+    if over.NumCtx != nil { o.NumCtx = over.NumCtx }
+    if over.NumPredict != nil { o.NumPredict = over.NumPredict }
+    if over.Seed != nil { o.Seed = over.Seed }
+    return o
+}
+
+// Validate rejects values Ollama would misbehave on rather than refuse.
+func (o GenOptions) Validate() error {
+    switch {
+    case o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2):
+        return fmt.Errorf("temperature must be in [0, 2]")
+    case o.TopP != nil && (*o.TopP <= 0 || *o.TopP > 1):
+        return fmt.Errorf("top_p must be in (0, 1]")
+    case o.NumCtx != nil && (*o.NumCtx < 256 || *o.NumCtx > 131072):
+        return fmt.Errorf("num_ctx must be in [256, 131072]")
+    case o.NumPredict != nil && (*o.NumPredict < -2 || *o.NumPredict == 0 || *o.NumPredict > 8192):
+        return fmt.Errorf("num_predict must be -1, -2 or in [1, 8192]")
+    }
+    return nil
+}
+
+// Key is a stable encoding of o, e.g. for cache keys.
+func (o GenOptions) Key() string {
+    b, _ := json.Marshal(o)
+    return string(b)
+}
+
+// toMap converts o to Ollama's "options" object; nil when nothing is set.
+func (o GenOptions) toMap() map[string]any {
+    m := map[string]any{}
+    if o.Temperature != nil { m["temperature"] = *o.Temperature }
+    if o.TopP != nil { m["top_p"] = *o.TopP }
+    if o.NumCtx != nil { m["num_ctx"] = *o.NumCtx }
+    if o.NumPredict != nil { m["num_predict"] = *o.NumPredict }
+    if o.Seed != nil { m["seed"] = *o.Seed }
+    if len(m) == 0 { return nil }
+    return m
+}
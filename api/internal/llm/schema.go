@@ -0,0 +1,117 @@
+package llm
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// Schema is the subset of JSON Schema used for structured output and tool
+// parameters. It is sent to Ollama as "format" and enforced again by
+// Validate, since models do not always honour it (e.g. item counts).
+type Schema struct {
+    Type                 string             `json:"type"`
+    Description          string             `json:"description,omitempty"`
+    Properties           map[string]*Schema `json:"properties,omitempty"`
+    Required             []string           `json:"required,omitempty"`
+    AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+    Items                *Schema            `json:"items,omitempty"`
+    MinItems             *int               `json:"minItems,omitempty"`
+    MaxItems             *int               `json:"maxItems,omitempty"`
+    MinLength            *int               `json:"minLength,omitempty"`
+    Enum                 []any              `json:"enum,omitempty"`
+}
+
+// Validate checks a decoded JSON value (as produced by encoding/json into
+// any) against s.
+func (s *Schema) Validate(v any) error { return s.validate("$", v) }
+
+func (s *Schema) validate(path string, v any) error {
+    if len(s.Enum) > 0 {
+        ok := false
+        for _, e := range s.Enum {
+            if fmt.Sprint(e) == fmt.Sprint(v) { ok = true; break }
+        }
+        if !ok { return fmt.Errorf("%s: must be one of %v", path, s.Enum) }
+    }
+    switch s.Type {
+    case "object":
+        obj, ok := v.(map[string]any)
+        if !ok { return fmt.Errorf("%s: expected object", path) }
+        for _, name := range s.Required {
+            if _, ok := obj[name]; !ok { return fmt.Errorf("%s: missing %q", path, name) }
+        }
+        for name, val := range obj {
+            prop, ok := s.Properties[name]
+            if !ok {
+                if s.AdditionalProperties != nil && !*s.AdditionalProperties { return fmt.Errorf("%s: unexpected %q", path, name) }
+                continue
+            }
+            if err := prop.validate(path+"."+name, val); err != nil { return err }
+        }
+    case "array":
+        arr, ok := v.([]any)
+        if !ok { return fmt.Errorf("%s: expected array", path) }
+        if s.MinItems != nil && len(arr) < *s.MinItems { return fmt.Errorf("%s: %d items, want at least %d", path, len(arr), *s.MinItems) }
+        if s.MaxItems != nil && len(arr) > *s.MaxItems { return fmt.Errorf("%s: %d items, want at most %d", path, len(arr), *s.MaxItems) }
+        if s.Items != nil {
+            for i, it := range arr {
+                if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), it); err != nil { return err }
+            }
+        }
+    case "string":
+        str, ok := v.(string)
+        if !ok { return fmt.Errorf("%s: expected string", path) }
+        if s.MinLength != nil && len([]rune(strings.TrimSpace(str))) < *s.MinLength { return fmt.Errorf("%s: shorter than %d", path, *s.MinLength) }
+    case "number":
+        if _, ok := v.(float64); !ok { return fmt.Errorf("%s: expected number", path) }
+    case "integer":
+        f, ok := v.(float64)
+        if !ok || f != float64(int64(f)) { return fmt.Errorf("%s: expected integer", path) }
+    case "boolean":
+        if _, ok := v.(bool); !ok { return fmt.Errorf("%s: expected boolean", path) }
+    }
+    return nil
+}
+
+// ErrInvalidOutput means the model's output still did not match the schema
+// after the repair attempt. StructuredError.Raw holds the last output so
+// callers can fall back to parsing it loosely.
+var ErrInvalidOutput = errors.New("model output does not match schema")
+
+type StructuredError struct {
+    Raw    string
+    Reason error
+}
+
+func (e *StructuredError) Error() string { return ErrInvalidOutput.Error() + ": " + e.Reason.Error() }
+func (e *StructuredError) Unwrap() error { return ErrInvalidOutput }
+
+// GenerateStructured generates with schema as Ollama's "format", validates
+// the result and decodes it into out. On invalid output it retries once with
+// a repair prompt quoting the error. repaired reports whether that retry was
+// needed. Upstream errors are returned as is; invalid output as
+// *StructuredError.
+func (c *OllamaClient) GenerateStructured(ctx context.Context, model, prompt string, schema *Schema, opts GenOptions, out any) (repaired bool, err error) {
+    format, err := json.Marshal(schema)
+    if err != nil { return false, err }
+    raw, err := c.generate(ctx, model, prompt, format, opts)
+    if err != nil { return false, err }
+    reason := decodeValid(raw, schema, out)
+    if reason == nil { return false, nil }
+    repair := prompt + "\n\nKết quả trước của bạn không hợp lệ (" + reason.Error() + "):\n" + raw +
+        "\n\nHãy sửa và chỉ xuất JSON đúng theo JSON Schema sau, không thêm gì khác:\n" + string(format)
+    raw, err = c.generate(ctx, model, repair, format, opts)
+    if err != nil { return true, err }
+    if reason = decodeValid(raw, schema, out); reason != nil { return true, &StructuredError{Raw: raw, Reason: reason} }
+    return true, nil
+}
+
+func decodeValid(raw string, schema *Schema, out any) error {
+    var v any
+    if err := json.Unmarshal([]byte(raw), &v); err != nil { return fmt.Errorf("invalid JSON: %w", err) }
+    if err := schema.Validate(v); err != nil { return err }
+    return json.Unmarshal([]byte(raw), out)
+}
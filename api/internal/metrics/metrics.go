@@ -18,10 +18,45 @@ var (
         Help: "Latency per endpoint in ms",
         Buckets: prom.LinearBuckets(50, 50, 20),
     }, []string{"endpoint"})
+
+    RateLimitedTotal = prom.NewCounterVec(prom.CounterOpts{
+        Name: "api_rate_limited_total",
+        Help: "Requests rejected with 429, by limit",
+    }, []string{"reason"})
+
+    UpstreamCircuitState = prom.NewGaugeVec(prom.GaugeOpts{
+        Name: "api_upstream_circuit_state",
+        Help: "Circuit breaker state per upstream: 0 closed, 1 open, 2 half-open",
+    }, []string{"upstream"})
+
+    UpstreamRetriesTotal = prom.NewCounterVec(prom.CounterOpts{
+        Name: "api_upstream_retries_total",
+        Help: "Retried upstream calls",
+    }, []string{"upstream"})
+
+    UpstreamFailuresTotal = prom.NewCounterVec(prom.CounterOpts{
+        Name: "api_upstream_failures_total",
+        Help: "Transient upstream failures (network errors, 408/429/502/503/504)",
+    }, []string{"upstream"})
+
+    StructuredOutputTotal = prom.NewCounterVec(prom.CounterOpts{
+        Name: "api_structured_output_total",
+        Help: "Schema-constrained generations by task and outcome (ok, repaired, fallback)",
+    }, []string{"task", "outcome"})
+
+    GroundingTotal = prom.NewCounterVec(prom.CounterOpts{
+        Name: "api_grounding_total",
+        Help: "Verified answers by endpoint and outcome (grounded, no_context, low_retrieval_score, unsupported, error)",
+    }, []string{"endpoint", "outcome"})
+
+    CalculatorCallsTotal = prom.NewCounterVec(prom.CounterOpts{
+        Name: "api_calculator_calls_total",
+        Help: "Calculator tool calls by tool and outcome (ok, unsourced, error)",
+    }, []string{"tool", "outcome"})
 )
 
 func init() {
-    prom.MustRegister(RequestsTotal, RequestLatencyMs)
+    prom.MustRegister(RequestsTotal, RequestLatencyMs, RateLimitedTotal, UpstreamCircuitState, UpstreamRetriesTotal, UpstreamFailuresTotal, StructuredOutputTotal, GroundingTotal, CalculatorCallsTotal)
 }
 
 func Handler() http.Handler { return promhttp.Handler() }
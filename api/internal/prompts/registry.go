@@ -0,0 +1,189 @@
+// Package prompts holds the prompt templates sent to the LLM. Templates are
+// Go text/template files identified by name (qa, summarize, chat_system,
+// condense, verify, extract_metrics, compare, compare_decompose,
+// calculator, agent, expand_queries, hyde), version and language, so
+// wording can change, and be A/B compared, without a redeploy.
+//
+// The language is a suffix of the version key: "v1" is the Vietnamese
+// template and "v1.en" its English counterpart, both as file names
+// (qa/v1.en.tmpl) and in Postgres.
+package prompts
+
+import (
+    "context"
+    "embed"
+    "fmt"
+    "io/fs"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "sync"
+    "text/template"
+
+    "github.com/hiepdt/contest/services/api/internal/lang"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// builtin holds the v1 templates; a directory or Postgres can override them
+// or add versions.
+//
+//go:embed builtin
+var builtin embed.FS
+
+// Names of the templates the handlers render.
+const (
+    QA             = "qa"
+    Summarize      = "summarize"
+    ChatSystem     = "chat_system"
+    Condense       = "condense"
+    Verify         = "verify"
+    ExtractMetrics = "extract_metrics"
+    Compare        = "compare"
+    Decompose      = "compare_decompose"
+    Calculator     = "calculator"
+    Agent          = "agent"
+    ExpandQueries  = "expand_queries"
+    HyDE           = "hyde"
+)
+
+// Registry resolves (name, version) to a parsed template. It is safe for
+// concurrent use and can be reloaded while serving.
+type Registry struct {
+    // Dir, when set, holds <name>/<version>.tmpl files.
+    Dir string
+    // Repo, when set, supplies templates stored in Postgres; they win over
+    // files with the same name and version.
+    Repo *storage.Repository
+    // Defaults maps a template name to the version used when a request does
+    // not pick one; unlisted names use "v1".
+    Defaults map[string]string
+
+    mu        sync.RWMutex
+    templates map[string]map[string]map[string]*template.Template // name -> version -> language
+}
+
+// Reload rebuilds the registry from the built-in templates, Dir and Repo.
+// On error the previous templates stay in place.
+func (r *Registry) Reload(ctx context.Context) error {
+    next := map[string]map[string]map[string]*template.Template{}
+    sub, _ := fs.Sub(builtin, "builtin")
+    if err := loadFS(next, sub); err != nil { return err }
+    if r.Dir != "" {
+        if err := loadFS(next, os.DirFS(r.Dir)); err != nil { return fmt.Errorf("prompt dir %s: %w", r.Dir, err) }
+    }
+    if r.Repo != nil {
+        rows, err := r.Repo.PromptTemplates(ctx)
+        if err != nil { return err }
+        for _, t := range rows {
+            if err := add(next, t.Name, t.Version, t.Body); err != nil { return err }
+        }
+    }
+    for name, version := range r.Defaults {
+        if next[name][version][lang.Default] == nil { return fmt.Errorf("default prompt %s version %s does not exist", name, version) }
+    }
+    r.mu.Lock()
+    r.templates = next
+    r.mu.Unlock()
+    return nil
+}
+
+// Parse checks that body is a valid template without registering it.
+func Parse(name, version, body string) error {
+    _, err := parse(name, version, body)
+    return err
+}
+
+// Render executes the template in the given language and returns the text,
+// the version and the language used. A version without a template in that
+// language falls back to its Vietnamese one.
+func (r *Registry) Render(name, version, language string, data any) (string, string, string, error) {
+    if version == "" { version = r.defaultVersion(name) }
+    r.mu.RLock()
+    byLang := r.templates[name][version]
+    r.mu.RUnlock()
+    t := byLang[language]
+    if t == nil { t, language = byLang[lang.Default], lang.Default }
+    if t == nil { return "", version, language, fmt.Errorf("prompt %s không có phiên bản %q", name, version) }
+    var b strings.Builder
+    if err := t.Execute(&b, data); err != nil { return "", version, language, err }
+    return b.String(), version, language, nil
+}
+
+// Has reports whether name has version ("" meaning the default).
+func (r *Registry) Has(name, version string) bool {
+    _, ok := r.Resolve(name, version)
+    return ok
+}
+
+// Resolve returns the version Render would use for version ("" meaning the
+// default), and whether name has it. Cache keys use it, so answers follow a
+// change of PROMPT_VERSIONS.
+func (r *Registry) Resolve(name, version string) (string, bool) {
+    if version == "" { version = r.defaultVersion(name) }
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return version, r.templates[name][version][lang.Default] != nil
+}
+
+// Versions lists every template name with its sorted version keys,
+// including language variants ("v1", "v1.en").
+func (r *Registry) Versions() map[string][]string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    out := map[string][]string{}
+    for name, versions := range r.templates {
+        for v, byLang := range versions {
+            for l := range byLang { out[name] = append(out[name], versionKey(v, l)) }
+        }
+        sort.Strings(out[name])
+    }
+    return out
+}
+
+func (r *Registry) defaultVersion(name string) string {
+    if v := r.Defaults[name]; v != "" { return v }
+    return "v1"
+}
+
+func loadFS(dst map[string]map[string]map[string]*template.Template, fsys fs.FS) error {
+    files, err := fs.Glob(fsys, "*/*.tmpl")
+    if err != nil { return err }
+    for _, f := range files {
+        body, err := fs.ReadFile(fsys, f)
+        if err != nil { return err }
+        name, file := path.Split(f)
+        if err := add(dst, strings.TrimSuffix(name, "/"), strings.TrimSuffix(file, ".tmpl"), string(body)); err != nil { return err }
+    }
+    return nil
+}
+
+func add(dst map[string]map[string]map[string]*template.Template, name, key, body string) error {
+    t, err := parse(name, key, body)
+    if err != nil { return err }
+    version, language := SplitVersion(key)
+    if dst[name] == nil { dst[name] = map[string]map[string]*template.Template{} }
+    if dst[name][version] == nil { dst[name][version] = map[string]*template.Template{} }
+    dst[name][version][language] = t
+    return nil
+}
+
+// SplitVersion splits a version key into version and language: "v2.en" is
+// v2 in English, "v2" and "v2.1" are Vietnamese.
+func SplitVersion(key string) (string, string) {
+    if i := strings.LastIndexByte(key, '.'); i > 0 && lang.Valid(key[i+1:]) { return key[:i], key[i+1:] }
+    return key, lang.Default
+}
+
+func versionKey(version, language string) string {
+    if language == lang.Default { return version }
+    return version + "." + language
+}
+
+// parse drops the file's final newline so templates can end with one
+// without it reaching the prompt.
+func parse(name, version, body string) (*template.Template, error) {
+    t, err := template.New(name + "@" + version).Option("missingkey=error").Parse(strings.TrimSuffix(body, "\n"))
+    if err != nil { return nil, fmt.Errorf("prompt %s@%s: %w", name, version, err) }
+    return t, nil
+}
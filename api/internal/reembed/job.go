@@ -0,0 +1,200 @@
+package reembed
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/llm"
+    "github.com/hiepdt/contest/services/api/internal/retrieval"
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// ErrAlreadyActive is returned when asked to migrate to the active model.
+var ErrAlreadyActive = errors.New("model is already the active embedding model")
+
+// Runner re-embeds every chunk with a new model in the background and flips
+// retrieval to it once every chunk has a vector. The new collection is
+// registered first, so chunks ingested meanwhile are written to both models.
+//
+// Every replica runs a Runner; Postgres is the source of truth. A job is
+// leased with an advisory lock so one replica runs it, and Sync makes every
+// replica register the job's collection and follow the active one.
+type Runner struct {
+    Repo        *storage.Repository
+    LLM         *llm.OllamaClient
+    Collections *retrieval.Registry
+    // Open probes model and builds its collection (indexes included).
+    Open func(ctx context.Context, model string) (*retrieval.Collection, error)
+    // BatchSize is how many chunks are embedded per request; default 64.
+    BatchSize int
+
+    mu     sync.Mutex
+    cancel map[int64]context.CancelFunc
+}
+
+// Start creates a job for model and runs it in the background.
+func (r *Runner) Start(ctx context.Context, model string) (storage.EmbeddingMigration, error) {
+    if active := r.Collections.Active(); active != nil && active.Model == model {
+        return storage.EmbeddingMigration{}, ErrAlreadyActive
+    }
+    col, err := r.collection(ctx, model)
+    if err != nil { return storage.EmbeddingMigration{}, err }
+    total, err := r.Repo.CountChunksMissingEmbedding(ctx, model)
+    if err != nil { return storage.EmbeddingMigration{}, err }
+    m, err := r.Repo.CreateEmbeddingMigration(ctx, model, total)
+    if err != nil { return m, err }
+    r.launch(m, col)
+    return m, nil
+}
+
+// Sync brings this replica in line with Postgres: the running job's
+// collection is registered, so ingests here write its vectors, and the job
+// is run here if no replica holds its lease (e.g. after a restart); then
+// retrieval follows the active collection, flipped by whichever replica
+// finished a job.
+func (r *Runner) Sync(ctx context.Context) error {
+    m, err := r.Repo.RunningEmbeddingMigration(ctx)
+    switch {
+    case err == nil:
+        col, err := r.collection(ctx, m.Model)
+        if err != nil { return err }
+        if !r.running(m.ID) { r.launch(m, col) }
+    case !errors.Is(err, storage.ErrNotFound):
+        return err
+    }
+    active, err := r.Repo.ActiveCollection(ctx)
+    if err != nil || active == "" { return err }
+    if cur := r.Collections.Active(); cur != nil && cur.Model == active { return nil }
+    if _, err := r.collection(ctx, active); err != nil { return err }
+    r.Collections.SetActive(active)
+    log.Printf("embedding collection %s is now active", active)
+    return nil
+}
+
+// Watch calls Sync every interval until ctx ends.
+func (r *Runner) Watch(ctx context.Context, every time.Duration) {
+    if every <= 0 { every = 10 * time.Second }
+    t := time.NewTicker(every)
+    defer t.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-t.C:
+            if err := r.Sync(ctx); err != nil { log.Println("embedding collections sync:", err) }
+        }
+    }
+}
+
+// Cancel stops a running job, wherever it runs; vectors written so far are
+// kept and a later job for the same model continues from them. It reports
+// false when the job is not running.
+func (r *Runner) Cancel(ctx context.Context, id int64) (bool, error) {
+    err := r.Repo.CancelEmbeddingMigration(ctx, id)
+    if errors.Is(err, storage.ErrNotFound) { return false, nil }
+    if err != nil { return false, err }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if cancel, ok := r.cancel[id]; ok { cancel() }
+    return true, nil
+}
+
+func (r *Runner) running(id int64) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    _, ok := r.cancel[id]
+    return ok
+}
+
+func (r *Runner) collection(ctx context.Context, model string) (*retrieval.Collection, error) {
+    if col := r.Collections.Get(model); col != nil { return col, nil }
+    col, err := r.Open(ctx, model)
+    if err != nil { return nil, err }
+    r.Collections.Register(col)
+    return col, nil
+}
+
+func (r *Runner) launch(m storage.EmbeddingMigration, col *retrieval.Collection) {
+    ctx, cancel := context.WithCancel(context.Background())
+    r.mu.Lock()
+    if r.cancel == nil { r.cancel = map[int64]context.CancelFunc{} }
+    r.cancel[m.ID] = cancel
+    r.mu.Unlock()
+    go func() {
+        defer func() {
+            r.mu.Lock()
+            delete(r.cancel, m.ID)
+            r.mu.Unlock()
+            cancel()
+        }()
+        release, ok, err := r.Repo.LeaseEmbeddingMigration(ctx, m.ID)
+        if err != nil { log.Println("embedding migration:", err) }
+        if !ok { return }
+        defer release()
+        // Another replica may have made progress before giving up the lease.
+        if m, err = r.Repo.GetEmbeddingMigration(ctx, m.ID); err != nil || m.Status != "running" { return }
+        log.Printf("embedding migration %d to %s: running after chunk %d", m.ID, m.Model, m.LastChunkID)
+        status, msg := "completed", ""
+        if err := r.run(ctx, m, col); err != nil {
+            status, msg = "failed", err.Error()
+            if errors.Is(err, context.Canceled) || errors.Is(err, storage.ErrNotFound) { status, msg = "cancelled", "" }
+        }
+        // The job context may be cancelled; record the outcome regardless.
+        fctx, fcancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer fcancel()
+        if err := r.Repo.FinishEmbeddingMigration(fctx, m.ID, status, msg); err != nil { log.Println("embedding migration:", err) }
+        log.Printf("embedding migration %d to %s: %s %s", m.ID, m.Model, status, msg)
+    }()
+}
+
+// run embeds pending chunks in id order, then makes a second pass for chunks
+// that were skipped (e.g. a failed side write during ingest) before flipping.
+func (r *Runner) run(ctx context.Context, m storage.EmbeddingMigration, col *retrieval.Collection) error {
+    batch := r.BatchSize
+    if batch <= 0 { batch = 64 }
+    done, after := m.Done, m.LastChunkID
+    for pass := 0; pass < 2; pass++ {
+        for {
+            if err := ctx.Err(); err != nil { return err }
+            chunks, err := r.Repo.ChunksMissingEmbedding(ctx, col.Model, after, batch)
+            if err != nil { return err }
+            if len(chunks) == 0 { break }
+            if err := r.embedBatch(ctx, col, chunks); err != nil { return err }
+            done += int64(len(chunks))
+            after = chunks[len(chunks)-1].ID
+            if err := r.Repo.UpdateEmbeddingMigration(ctx, m.ID, done, after); err != nil { return err }
+        }
+        after = 0
+    }
+    left, err := r.Repo.CountChunksMissingEmbedding(ctx, col.Model)
+    if err != nil { return err }
+    if left > 0 { return fmt.Errorf("%d chunks still lack a %s vector", left, col.Model) }
+    // Other replicas switch on their next Sync.
+    if err := r.Repo.ActivateCollection(ctx, col.Model); err != nil { return err }
+    r.Collections.SetActive(col.Model)
+    return nil
+}
+
+// embedBatch embeds chunks and writes them to col, grouped by tenant since
+// every index call is tenant scoped.
+func (r *Runner) embedBatch(ctx context.Context, col *retrieval.Collection, chunks []storage.PendingChunk) error {
+    ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
+    defer cancel()
+    texts := make([]string, len(chunks))
+    for i, c := range chunks { texts[i] = c.Content }
+    vecs, err := r.LLM.Embeddings(ctx, col.Model, texts)
+    if err != nil { return err }
+    byTenant := map[string][]retrieval.Item{}
+    for i, c := range chunks {
+        if len(vecs[i]) != col.Dim { return fmt.Errorf("chunk %d: got %d dimensions, want %d", c.ID, len(vecs[i]), col.Dim) }
+        byTenant[c.TenantID] = append(byTenant[c.TenantID], retrieval.Item{ID: c.ID, DocID: c.DocID, Vector: vecs[i]})
+    }
+    for tenant, items := range byTenant {
+        if err := col.Index.Add(ctx, tenant, items); err != nil { return err }
+    }
+    return nil
+}
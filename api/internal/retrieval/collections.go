@@ -0,0 +1,102 @@
+package retrieval
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// Collection is the set of vectors produced by one embedding model. Vectors of
+// different models live in separate indexes and are never compared.
+type Collection struct {
+    Model string
+    Dim   int
+    // Name identifies the collection to FAISS and on disk.
+    Name  string
+    Index *Composite
+    // Faiss is nil unless the FAISS service is enabled.
+    Faiss *FaissClient
+}
+
+// CollectionName turns a model name such as "nomic-embed-text:latest" into a
+// name safe for file paths and the FAISS service ([A-Za-z0-9_-], at most 64
+// characters). Every byte other than letters, digits and "-" is escaped as
+// "_" and two hex digits ("nomic-embed-text_3alatest"), so distinct models
+// never share a collection; names that would be too long keep a prefix and a
+// hash of the model.
+func CollectionName(model string) string {
+    var b strings.Builder
+    for i := 0; i < len(model); i++ {
+        c := model[i]
+        if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+            b.WriteByte(c)
+        } else {
+            fmt.Fprintf(&b, "_%02x", c)
+        }
+    }
+    name := b.String()
+    if len(name) > 64 {
+        sum := sha256.Sum256([]byte(model))
+        name = name[:47] + "-" + hex.EncodeToString(sum[:8])
+    }
+    if name == "" { name = "_" }
+    return name
+}
+
+// Registry holds every configured collection. The active one serves queries;
+// the others are only written to, so a new model can be filled side by side.
+type Registry struct {
+    mu     sync.RWMutex
+    active string
+    byModel map[string]*Collection
+    order  []string
+}
+
+func NewRegistry(active string) *Registry {
+    return &Registry{active: active, byModel: map[string]*Collection{}}
+}
+
+func (r *Registry) Register(c *Collection) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if _, ok := r.byModel[c.Model]; !ok { r.order = append(r.order, c.Model) }
+    r.byModel[c.Model] = c
+}
+
+// Active returns the collection used for retrieval.
+func (r *Registry) Active() *Collection {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return r.byModel[r.active]
+}
+
+// SetActive switches retrieval to model's collection; it must be registered.
+// Callers holding the previous Active() finish with it, so a query never
+// mixes a vector of one model with the index of another.
+func (r *Registry) SetActive(model string) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.byModel[model] == nil { return false }
+    r.active = model
+    return true
+}
+
+// Get returns the collection of model, or nil.
+func (r *Registry) Get(model string) *Collection {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return r.byModel[model]
+}
+
+// All returns every collection, the active one first.
+func (r *Registry) All() []*Collection {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    out := []*Collection{r.byModel[r.active]}
+    for _, m := range r.order {
+        if m != r.active { out = append(out, r.byModel[m]) }
+    }
+    return out
+}
@@ -5,54 +5,171 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "io"
     "net/http"
+    "net/url"
+    "strings"
     "time"
+
+    "github.com/hiepdt/contest/services/api/internal/upstream"
 )
 
+// FaissClient talks to the Python FAISS service and implements Index for one
+// collection; the service keeps a separate index per collection and tenant.
 type FaissClient struct {
     host string
+    collection string
     httpc *http.Client
+    guard *upstream.Guard
 }
 
 func NewFaiss(host string) *FaissClient {
-    return &FaissClient{host: host, httpc: &http.Client{Timeout: 30 * time.Second}}
+    return &FaissClient{host: host, collection: "default", httpc: &http.Client{Timeout: 30 * time.Second}, guard: upstream.DefaultGuard("faiss")}
+}
+
+// For returns a client bound to collection that shares c's connection pool
+// and circuit breaker.
+func (c *FaissClient) For(collection string) *FaissClient {
+    return &FaissClient{host: c.host, collection: collection, httpc: c.httpc, guard: c.guard}
 }
 
-type addItem struct { ID int64 `json:"id"`; Vector []float32 `json:"vector"` }
-type addReq struct { Items []addItem `json:"items"` }
+// WithGuard replaces the default retry policy and circuit breaker.
+func (c *FaissClient) WithGuard(g *upstream.Guard) *FaissClient {
+    c.guard = g
+    return c
+}
+
+// Breaker exposes the circuit breaker for readiness checks.
+func (c *FaissClient) Breaker() *upstream.Breaker { return c.guard.Breaker }
+
+type addItem struct { ID int64 `json:"id"`; DocID string `json:"doc_id"`; Vector []float32 `json:"vector"` }
+type addReq struct { Tenant string `json:"tenant"`; Collection string `json:"collection"`; Items []addItem `json:"items"` }
 type addRes struct { Added int `json:"added"` }
 
-func (c *FaissClient) Add(ctx context.Context, items map[int64][]float32) error {
+// Add inserts vectors into the tenant's own index; tenants never share an
+// index. Re-adding an id replaces its vector: HNSW indexes, which cannot
+// drop one, store it under a new internal label and never return the old
+// one, which takes memory until the index is trained again.
+func (c *FaissClient) Add(ctx context.Context, tenant string, items []Item) error {
     arr := make([]addItem, 0, len(items))
-    for id, v := range items { arr = append(arr, addItem{ID: id, Vector: v}) }
-    b, _ := json.Marshal(addReq{Items: arr})
-    url := fmt.Sprintf("%s/add", c.host)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
-    req.Header.Set("Content-Type", "application/json")
-    resp, err := c.httpc.Do(req)
-    if err != nil { return err }
-    defer resp.Body.Close()
-    if resp.StatusCode >= 300 { return fmt.Errorf("faiss add status %d", resp.StatusCode) }
-    return nil
-}
-
-type searchReq struct { Vector []float32 `json:"vector"`; TopK int `json:"top_k"` }
-type searchRes struct { Results []struct{ ID int64 `json:"id"`; Score float32 `json:"score"` } `json:"results"` }
-
-func (c *FaissClient) Search(ctx context.Context, vector []float32, topK int) ([]int64, []float32, error) {
-    b, _ := json.Marshal(searchReq{Vector: vector, TopK: topK})
-    url := fmt.Sprintf("%s/search", c.host)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
-    req.Header.Set("Content-Type", "application/json")
-    resp, err := c.httpc.Do(req)
-    if err != nil { return nil, nil, err }
-    defer resp.Body.Close()
+    for _, it := range items { arr = append(arr, addItem{ID: it.ID, DocID: it.DocID, Vector: it.Vector}) }
+    var out addRes
+    return c.do(ctx, http.MethodPost, "/add", addReq{Tenant: tenant, Collection: c.collection, Items: arr}, &out)
+}
+
+type removeReq struct { Tenant string `json:"tenant"`; Collection string `json:"collection"`; IDs []int64 `json:"ids"` }
+type removeRes struct { Removed int `json:"removed"` }
+
+func (c *FaissClient) Delete(ctx context.Context, tenant string, ids []int64) error {
+    if len(ids) == 0 { return nil }
+    var out removeRes
+    return c.do(ctx, http.MethodPost, "/remove", removeReq{Tenant: tenant, Collection: c.collection, IDs: ids}, &out)
+}
+
+type searchReq struct {
+    Tenant string    `json:"tenant"`
+    Collection string `json:"collection"`
+    Vector []float32 `json:"vector"`
+    TopK   int       `json:"top_k"`
+    DocIDs []string  `json:"doc_ids,omitempty"`
+    NProbe   int     `json:"nprobe,omitempty"`
+    EfSearch int     `json:"ef_search,omitempty"`
+}
+type searchRes struct { Results []struct{ ID int64 `json:"id"`; DocID string `json:"doc_id"`; Score float32 `json:"score"` } `json:"results"` }
+
+// Search only looks at the tenant's index; a document filter is applied
+// inside FAISS with an id selector. NProbe/EfSearch override the index
+// defaults for this query.
+func (c *FaissClient) Search(ctx context.Context, tenant string, vector []float32, topK int, opts SearchOptions) ([]Hit, error) {
     var out searchRes
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return nil, nil, err }
-    ids := make([]int64, 0, len(out.Results))
-    scores := make([]float32, 0, len(out.Results))
-    for _, r := range out.Results { ids = append(ids, r.ID); scores = append(scores, r.Score) }
-    return ids, scores, nil
+    if err := c.do(ctx, http.MethodPost, "/search", searchReq{Tenant: tenant, Collection: c.collection, Vector: vector, TopK: topK, DocIDs: opts.DocIDs, NProbe: opts.NProbe, EfSearch: opts.EfSearch}, &out); err != nil { return nil, err }
+    hits := make([]Hit, 0, len(out.Results))
+    for _, r := range out.Results { hits = append(hits, Hit{ID: r.ID, DocID: r.DocID, Score: r.Score}) }
+    return hits, nil
 }
 
+// FaissIndexSpec selects the FAISS index type for a tenant. Type is "flat"
+// (exact, default), "ivfpq" or "hnsw"; zero fields keep the service defaults.
+// Dim is filled in by the service.
+type FaissIndexSpec struct {
+    Type           string `json:"type"`
+    Dim            int    `json:"dim,omitempty"`
+    NList          int    `json:"nlist,omitempty"`
+    M              int    `json:"m,omitempty"`
+    NBits          int    `json:"nbits,omitempty"`
+    NProbe         int    `json:"nprobe,omitempty"`
+    HNSWM          int    `json:"hnsw_m,omitempty"`
+    EfConstruction int    `json:"ef_construction,omitempty"`
+    EfSearch       int    `json:"ef_search,omitempty"`
+}
 
+type trainReq struct {
+    Tenant  string         `json:"tenant"`
+    Collection string      `json:"collection"`
+    Spec    FaissIndexSpec `json:"spec"`
+    Vectors [][]float32    `json:"vectors"`
+}
+
+// Train replaces the tenant's index with an empty one of spec's type, trained
+// on sample when the type needs it (IVF-PQ). Vectors must be re-added after.
+func (c *FaissClient) Train(ctx context.Context, tenant string, spec FaissIndexSpec, sample [][]float32) error {
+    return c.do(ctx, http.MethodPost, "/train", trainReq{Tenant: tenant, Collection: c.collection, Spec: spec, Vectors: sample}, nil)
+}
+
+// Reset empties the tenant's index, e.g. before a rebuild from Postgres.
+func (c *FaissClient) Reset(ctx context.Context, tenant string) error {
+    return c.do(ctx, http.MethodPost, "/reset", map[string]string{"tenant": tenant, "collection": c.collection}, nil)
+}
+
+// Save asks the service to persist changed indexes now instead of waiting
+// for its periodic save.
+func (c *FaissClient) Save(ctx context.Context) error {
+    return c.do(ctx, http.MethodPost, "/save", struct{}{}, nil)
+}
+
+type FaissStats struct {
+    Tenant string          `json:"tenant"`
+    Collection string      `json:"collection"`
+    Count  int             `json:"count"`
+    Dim    int             `json:"dim"`
+    Spec   *FaissIndexSpec `json:"spec,omitempty"`
+}
+
+// Stats reports how many vectors the tenant's index holds.
+func (c *FaissClient) Stats(ctx context.Context, tenant string) (FaissStats, error) {
+    var out FaissStats
+    err := c.do(ctx, http.MethodGet, "/stats?tenant="+url.QueryEscape(tenant)+"&collection="+url.QueryEscape(c.collection), nil, &out)
+    return out, err
+}
+
+// do sends in as JSON (when non-nil) and decodes a 2xx response into out.
+// Every endpoint is idempotent (add replaces by id), so transient failures
+// are retried through the guard.
+func (c *FaissClient) do(ctx context.Context, method, path string, in, out any) error {
+    var b []byte
+    if in != nil {
+        var err error
+        if b, err = json.Marshal(in); err != nil { return err }
+    }
+    return c.guard.Call(ctx, func(ctx context.Context) error {
+        var body io.Reader
+        if in != nil { body = bytes.NewReader(b) }
+        req, err := http.NewRequestWithContext(ctx, method, c.host+path, body)
+        if err != nil { return err }
+        if in != nil { req.Header.Set("Content-Type", "application/json") }
+        resp, err := c.httpc.Do(req)
+        if err != nil { return err }
+        defer resp.Body.Close()
+        if resp.StatusCode >= 300 {
+            // FastAPI errors look like {"detail": "..."}.
+            msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+            var e struct{ Detail any `json:"detail"` }
+            text := string(bytes.TrimSpace(msg))
+            if json.Unmarshal(msg, &e) == nil && e.Detail != nil { text = fmt.Sprint(e.Detail) }
+            op, _, _ := strings.Cut(path, "?")
+            return upstream.NewStatusError("faiss", op, resp, text)
+        }
+        if out == nil { return nil }
+        return json.NewDecoder(resp.Body).Decode(out)
+    })
+}
@@ -0,0 +1,38 @@
+package retrieval
+
+import "sort"
+
+// rrfK dampens the weight of top ranks in reciprocal rank fusion; 60 is
+// the usual value.
+const rrfK = 60
+
+// Fuse merges result lists of the same search run with different queries
+// by reciprocal rank fusion: a hit's Fused score is the sum of
+// 1/(rrfK+rank) over the lists it appears in. The topK best are returned in
+// fused order. The first list must be the original question's: Score stays
+// the similarity to it (0 for hits only the other queries found), so score
+// thresholds such as the grounding precheck keep judging the question.
+func Fuse(lists [][]Hit, topK int) []Hit {
+    byID := map[int64]*Hit{}
+    var out []*Hit
+    for i, list := range lists {
+        for rank, h := range list {
+            f, ok := byID[h.ID]
+            if !ok {
+                f = &Hit{ID: h.ID, DocID: h.DocID}
+                byID[h.ID] = f
+                out = append(out, f)
+            }
+            if i == 0 { f.Score = h.Score }
+            f.Fused += 1 / float64(rrfK+rank+1)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Fused != out[j].Fused { return out[i].Fused > out[j].Fused }
+        return out[i].ID < out[j].ID
+    })
+    if len(out) > topK { out = out[:topK] }
+    hits := make([]Hit, len(out))
+    for i, h := range out { hits[i] = *h }
+    return hits
+}
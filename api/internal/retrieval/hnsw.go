@@ -0,0 +1,462 @@
+package retrieval
+
+import (
+    "container/heap"
+    "context"
+    "encoding/gob"
+    "errors"
+    "fmt"
+    "math"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+)
+
+// HNSW is an in-process Hierarchical Navigable Small World graph (Malkov &
+// Yashunin) over cosine distance. Vectors are normalized on insert so the
+// distance is 1 - dot product. It is safe for concurrent use: searches share a
+// read lock, Add/Delete take the write lock.
+//
+// Deletes are tombstones: the node stays in the graph for navigation but is
+// never returned. When tombstones outnumber live nodes the graph is rebuilt.
+type HNSW struct {
+    mu             sync.RWMutex
+    saveMu         sync.Mutex // serialises Save, which writes outside mu
+    m              int
+    efConstruction int
+    efSearch       int
+    levelMult      float64
+    rng            *rand.Rand
+
+    dim      int
+    nodes    []*hnswNode
+    byID     map[int64]int32
+    entry    int32
+    maxLevel int
+    deleted  int
+    dirty    bool
+}
+
+type hnswNode struct {
+    ID      int64
+    DocID   string
+    Vec     []float32
+    Level   int
+    Friends [][]int32 // per layer, internal node indexes
+    Deleted bool
+}
+
+type HNSWConfig struct {
+    M              int // neighbours per node per layer (2*M on layer 0)
+    EfConstruction int
+    EfSearch       int
+}
+
+func (c HNSWConfig) withDefaults() HNSWConfig {
+    if c.M <= 0 { c.M = 16 }
+    if c.EfConstruction <= 0 { c.EfConstruction = 200 }
+    if c.EfSearch <= 0 { c.EfSearch = 64 }
+    return c
+}
+
+// Validate rejects settings a graph cannot be built with; zero values take
+// the defaults. M must be at least 2, since levels are drawn with 1/ln(M),
+// and EfConstruction at least M, or inserts could not find M neighbours.
+func (c HNSWConfig) Validate() error {
+    if c.M < 0 || c.EfConstruction < 0 || c.EfSearch < 0 { return errors.New("hnsw: M, ef_construction and ef_search must not be negative") }
+    c = c.withDefaults()
+    if c.M < 2 { return fmt.Errorf("hnsw: M must be at least 2, got %d", c.M) }
+    if c.EfConstruction < c.M { return fmt.Errorf("hnsw: ef_construction (%d) must be at least M (%d)", c.EfConstruction, c.M) }
+    return nil
+}
+
+// NewHNSW returns an empty graph; cfg should have passed Validate.
+func NewHNSW(cfg HNSWConfig) *HNSW {
+    cfg = cfg.withDefaults()
+    return &HNSW{
+        m: cfg.M, efConstruction: cfg.EfConstruction, efSearch: cfg.EfSearch,
+        levelMult: 1 / math.Log(float64(max(cfg.M, 2))),
+        rng:       rand.New(rand.NewSource(rand.Int63())),
+        byID:      map[int64]int32{},
+        entry:     -1,
+    }
+}
+
+var ErrDimMismatch = errors.New("vector dimension mismatch")
+
+// Add inserts items; an existing id is replaced.
+func (h *HNSW) Add(items []Item) error {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    for _, it := range items {
+        if len(it.Vector) == 0 { continue }
+        if h.dim == 0 { h.dim = len(it.Vector) }
+        if len(it.Vector) != h.dim { return ErrDimMismatch }
+        h.deleteLocked(it.ID)
+        h.insertLocked(it.ID, it.DocID, normalize(it.Vector))
+    }
+    h.maybeRebuildLocked()
+    return nil
+}
+
+// Delete removes ids; unknown ids are ignored.
+func (h *HNSW) Delete(ids []int64) error {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    for _, id := range ids { h.deleteLocked(id) }
+    h.maybeRebuildLocked()
+    return nil
+}
+
+// Len returns the number of live vectors.
+func (h *HNSW) Len() int {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return len(h.byID)
+}
+
+// Search returns up to topK hits passing opts, by descending cosine
+// similarity. With a filter the beam is widened until enough matches are
+// found or the whole graph has been considered.
+func (h *HNSW) Search(query []float32, topK int, opts SearchOptions) ([]Hit, error) {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    if h.entry < 0 || topK <= 0 { return nil, nil }
+    if len(query) != h.dim { return nil, ErrDimMismatch }
+    q := normalize(query)
+    ep := h.entry
+    for lc := h.maxLevel; lc > 0; lc-- { ep = h.greedyLocked(q, ep, lc) }
+    ef := h.efSearch
+    if opts.EfSearch > 0 { ef = opts.EfSearch }
+    if ef < topK { ef = topK }
+    // Tombstones occupy result slots; widen the beam to compensate.
+    if h.deleted > 0 { ef += ef * h.deleted / (len(h.byID) + 1) }
+    for {
+        found := h.searchLayerLocked(q, []int32{ep}, ef, 0)
+        hits := make([]Hit, 0, topK)
+        for _, c := range found {
+            n := h.nodes[c.idx]
+            if n.Deleted || !opts.allows(n.DocID) { continue }
+            hits = append(hits, Hit{ID: n.ID, DocID: n.DocID, Score: 1 - c.dist})
+            if len(hits) == topK { break }
+        }
+        if len(hits) == topK || len(found) < ef || ef >= len(h.nodes) { return hits, nil }
+        ef *= 4
+    }
+}
+
+func (h *HNSW) deleteLocked(id int64) {
+    idx, ok := h.byID[id]
+    if !ok { return }
+    h.nodes[idx].Deleted = true
+    delete(h.byID, id)
+    h.deleted++
+    h.dirty = true
+}
+
+func (h *HNSW) insertLocked(id int64, docID string, v []float32) {
+    level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+    idx := int32(len(h.nodes))
+    n := &hnswNode{ID: id, DocID: docID, Vec: v, Level: level, Friends: make([][]int32, level+1)}
+    h.nodes = append(h.nodes, n)
+    h.byID[id] = idx
+    h.dirty = true
+    if h.entry < 0 {
+        h.entry, h.maxLevel = idx, level
+        return
+    }
+    ep := h.entry
+    for lc := h.maxLevel; lc > level; lc-- { ep = h.greedyLocked(v, ep, lc) }
+    eps := []int32{ep}
+    for lc := min(level, h.maxLevel); lc >= 0; lc-- {
+        cands := h.searchLayerLocked(v, eps, h.efConstruction, lc)
+        neigh := h.selectNeighbors(cands, h.maxFriends(lc))
+        n.Friends[lc] = neigh
+        for _, nb := range neigh {
+            f := append(h.nodes[nb].Friends[lc], idx)
+            if len(f) > h.maxFriends(lc) { f = h.shrinkLocked(nb, f, lc) }
+            h.nodes[nb].Friends[lc] = f
+        }
+        eps = eps[:0]
+        for _, c := range cands { eps = append(eps, c.idx) }
+    }
+    if level > h.maxLevel { h.entry, h.maxLevel = idx, level }
+}
+
+func (h *HNSW) maxFriends(layer int) int {
+    if layer == 0 { return 2 * h.m }
+    return h.m
+}
+
+// selectNeighbors applies the HNSW heuristic: keep a candidate only if it is
+// closer to the new node than to any neighbour already kept, which preserves
+// links across clusters. cands must be sorted by distance.
+func (h *HNSW) selectNeighbors(cands []candidate, m int) []int32 {
+    out := make([]int32, 0, m)
+    for _, c := range cands {
+        if len(out) >= m { break }
+        good := true
+        for _, o := range out {
+            if distance(h.nodes[c.idx].Vec, h.nodes[o].Vec) < c.dist { good = false; break }
+        }
+        if good { out = append(out, c.idx) }
+    }
+    // Top up with the closest leftovers so sparse regions stay connected.
+    for _, c := range cands {
+        if len(out) >= m { break }
+        if !containsIdx(out, c.idx) { out = append(out, c.idx) }
+    }
+    return out
+}
+
+func (h *HNSW) shrinkLocked(node int32, friends []int32, layer int) []int32 {
+    v := h.nodes[node].Vec
+    cands := make([]candidate, len(friends))
+    for i, f := range friends { cands[i] = candidate{idx: f, dist: distance(v, h.nodes[f].Vec)} }
+    sort.Slice(cands, func(i, j int) bool { return cands[i].dist < cands[j].dist })
+    return h.selectNeighbors(cands, h.maxFriends(layer))
+}
+
+func (h *HNSW) greedyLocked(q []float32, ep int32, layer int) int32 {
+    cur, curDist := ep, distance(q, h.nodes[ep].Vec)
+    for changed := true; changed; {
+        changed = false
+        for _, f := range h.nodes[cur].Friends[layer] {
+            if d := distance(q, h.nodes[f].Vec); d < curDist { cur, curDist, changed = f, d, true }
+        }
+    }
+    return cur
+}
+
+// searchLayerLocked is a beam search of width ef; results are sorted by distance.
+func (h *HNSW) searchLayerLocked(q []float32, eps []int32, ef int, layer int) []candidate {
+    visited := make(map[int32]struct{}, ef*4)
+    cands := &minHeap{}
+    res := &maxHeap{}
+    for _, ep := range eps {
+        if _, ok := visited[ep]; ok { continue }
+        visited[ep] = struct{}{}
+        c := candidate{idx: ep, dist: distance(q, h.nodes[ep].Vec)}
+        heap.Push(cands, c)
+        heap.Push(res, c)
+    }
+    for res.Len() > ef { heap.Pop(res) }
+    for cands.Len() > 0 {
+        c := heap.Pop(cands).(candidate)
+        if res.Len() >= ef && c.dist > (*res)[0].dist { break }
+        n := h.nodes[c.idx]
+        if layer >= len(n.Friends) { continue }
+        for _, f := range n.Friends[layer] {
+            if _, ok := visited[f]; ok { continue }
+            visited[f] = struct{}{}
+            d := distance(q, h.nodes[f].Vec)
+            if res.Len() < ef || d < (*res)[0].dist {
+                heap.Push(cands, candidate{idx: f, dist: d})
+                heap.Push(res, candidate{idx: f, dist: d})
+                if res.Len() > ef { heap.Pop(res) }
+            }
+        }
+    }
+    out := make([]candidate, res.Len())
+    for i := len(out) - 1; i >= 0; i-- { out[i] = heap.Pop(res).(candidate) }
+    return out
+}
+
+// maybeRebuildLocked rebuilds the graph from live nodes once tombstones
+// dominate, reclaiming memory and search quality.
+func (h *HNSW) maybeRebuildLocked() {
+    if h.deleted < 1024 || h.deleted < len(h.byID) { return }
+    old := h.nodes
+    h.nodes, h.byID, h.entry, h.maxLevel, h.deleted = nil, map[int64]int32{}, -1, 0, 0
+    for _, n := range old {
+        if !n.Deleted { h.insertLocked(n.ID, n.DocID, n.Vec) }
+    }
+}
+
+type hnswSnapshot struct {
+    M, EfConstruction, EfSearch int
+    Dim                         int
+    Nodes                       []*hnswNode
+    Entry                       int32
+    MaxLevel                    int
+}
+
+// Save writes a snapshot atomically (temp file + rename). The graph is
+// copied under the lock and encoded outside it, so inserts and searches are
+// not held up by the encode and fsync.
+func (h *HNSW) Save(path string) error {
+    h.saveMu.Lock()
+    defer h.saveMu.Unlock()
+    h.mu.Lock()
+    snap := hnswSnapshot{M: h.m, EfConstruction: h.efConstruction, EfSearch: h.efSearch, Dim: h.dim, Nodes: make([]*hnswNode, len(h.nodes)), Entry: h.entry, MaxLevel: h.maxLevel}
+    for i, n := range h.nodes {
+        c := *n // vectors are never modified in place; friend lists are
+        c.Friends = make([][]int32, len(n.Friends))
+        for l, f := range n.Friends { c.Friends[l] = append([]int32(nil), f...) }
+        snap.Nodes[i] = &c
+    }
+    h.dirty = false
+    h.mu.Unlock()
+    if err := writeSnapshot(path, &snap); err != nil {
+        h.mu.Lock()
+        h.dirty = true
+        h.mu.Unlock()
+        return err
+    }
+    return nil
+}
+
+func writeSnapshot(path string, snap *hnswSnapshot) error {
+    tmp := path + ".tmp"
+    f, err := os.Create(tmp)
+    if err != nil { return err }
+    if err := gob.NewEncoder(f).Encode(snap); err != nil { f.Close(); os.Remove(tmp); return err }
+    if err := f.Sync(); err != nil { f.Close(); os.Remove(tmp); return err }
+    if err := f.Close(); err != nil { os.Remove(tmp); return err }
+    return os.Rename(tmp, path)
+}
+
+// LoadHNSW reads a snapshot written by Save. M and EfConstruction are the
+// ones the graph was built with; only EfSearch comes from cfg when set, so
+// it can be tuned without rebuilding.
+func LoadHNSW(path string, cfg HNSWConfig) (*HNSW, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    var snap hnswSnapshot
+    if err := gob.NewDecoder(f).Decode(&snap); err != nil { return nil, err }
+    cfg.M, cfg.EfConstruction = snap.M, snap.EfConstruction
+    if cfg.EfSearch <= 0 { cfg.EfSearch = snap.EfSearch }
+    if err := cfg.Validate(); err != nil { return nil, fmt.Errorf("%s: %w", path, err) }
+    h := NewHNSW(cfg)
+    h.dim, h.nodes, h.entry, h.maxLevel = snap.Dim, snap.Nodes, snap.Entry, snap.MaxLevel
+    for i, n := range h.nodes {
+        if n.Deleted { h.deleted++; continue }
+        h.byID[n.ID] = int32(i)
+    }
+    return h, nil
+}
+
+// HNSWStore keeps one HNSW graph per tenant, persisted as <dir>/<tenant>.hnsw.
+// It implements Index.
+type HNSWStore struct {
+    mu      sync.Mutex
+    dir     string
+    cfg     HNSWConfig
+    indexes map[string]*HNSW
+}
+
+// OpenHNSWStore loads every snapshot found in dir.
+func OpenHNSWStore(dir string, cfg HNSWConfig) (*HNSWStore, error) {
+    s := &HNSWStore{dir: dir, cfg: cfg, indexes: map[string]*HNSW{}}
+    if err := os.MkdirAll(dir, 0o755); err != nil { return nil, err }
+    files, err := filepath.Glob(filepath.Join(dir, "*.hnsw"))
+    if err != nil { return nil, err }
+    for _, f := range files {
+        h, err := LoadHNSW(f, cfg)
+        if err != nil { return nil, err }
+        s.indexes[filepath.Base(f[:len(f)-len(".hnsw")])] = h
+    }
+    return s, nil
+}
+
+func (s *HNSWStore) Add(ctx context.Context, tenant string, items []Item) error {
+    return s.Tenant(tenant, true).Add(items)
+}
+
+func (s *HNSWStore) Delete(ctx context.Context, tenant string, ids []int64) error {
+    if h := s.Tenant(tenant, false); h != nil { return h.Delete(ids) }
+    return nil
+}
+
+func (s *HNSWStore) Search(ctx context.Context, tenant string, query []float32, topK int, opts SearchOptions) ([]Hit, error) {
+    h := s.Tenant(tenant, false)
+    if h == nil { return nil, nil }
+    return h.Search(query, topK, opts)
+}
+
+// Reset replaces the tenant's graph with an empty one.
+func (s *HNSWStore) Reset(ctx context.Context, tenant string) error {
+    h := NewHNSW(s.cfg)
+    h.dirty = true
+    s.mu.Lock()
+    s.indexes[tenant] = h
+    s.mu.Unlock()
+    return nil
+}
+
+// Tenant returns the tenant's index, creating it when create is set.
+func (s *HNSWStore) Tenant(tenant string, create bool) *HNSW {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    h := s.indexes[tenant]
+    if h == nil && create {
+        h = NewHNSW(s.cfg)
+        s.indexes[tenant] = h
+    }
+    return h
+}
+
+// Save snapshots every index changed since its last save.
+func (s *HNSWStore) Save() error {
+    s.mu.Lock()
+    snapshot := make(map[string]*HNSW, len(s.indexes))
+    for t, h := range s.indexes { snapshot[t] = h }
+    s.mu.Unlock()
+    var firstErr error
+    for t, h := range snapshot {
+        h.mu.RLock()
+        dirty := h.dirty
+        h.mu.RUnlock()
+        if !dirty { continue }
+        if err := h.Save(filepath.Join(s.dir, t+".hnsw")); err != nil && firstErr == nil { firstErr = err }
+    }
+    return firstErr
+}
+
+type candidate struct {
+    idx  int32
+    dist float32
+}
+
+type minHeap []candidate
+
+func (h minHeap) Len() int            { return len(h) }
+func (h minHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x any)         { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() any           { old := *h; x := old[len(old)-1]; *h = old[:len(old)-1]; return x }
+
+type maxHeap []candidate
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x any)         { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() any           { old := *h; x := old[len(old)-1]; *h = old[:len(old)-1]; return x }
+
+func normalize(v []float32) []float32 {
+    var n float64
+    for _, x := range v { n += float64(x) * float64(x) }
+    out := make([]float32, len(v))
+    if n == 0 { copy(out, v); return out }
+    inv := float32(1 / math.Sqrt(n))
+    for i, x := range v { out[i] = x * inv }
+    return out
+}
+
+// distance is cosine distance between normalized vectors.
+func distance(a, b []float32) float32 {
+    var dot float32
+    for i := range a { dot += a[i] * b[i] }
+    return 1 - dot
+}
+
+func containsIdx(s []int32, x int32) bool {
+    for _, v := range s {
+        if v == x { return true }
+    }
+    return false
+}
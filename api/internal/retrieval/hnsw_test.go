@@ -0,0 +1,130 @@
+package retrieval
+
+import (
+    "math/rand"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func randomItems(rng *rand.Rand, n, dim int) []Item {
+    items := make([]Item, n)
+    for i := range items {
+        v := make([]float32, dim)
+        for j := range v { v[j] = float32(rng.NormFloat64()) }
+        items[i] = Item{ID: int64(i + 1), DocID: "d", Vector: v}
+    }
+    return items
+}
+
+// bruteForce returns the ids of the topK items most cosine-similar to q.
+func bruteForce(items []Item, q []float32, topK int) []int64 {
+    nq := normalize(q)
+    type scored struct {
+        id    int64
+        score float32
+    }
+    all := make([]scored, len(items))
+    for i, it := range items {
+        v := normalize(it.Vector)
+        var dot float32
+        for j := range v { dot += v[j] * nq[j] }
+        all[i] = scored{it.ID, dot}
+    }
+    sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+    ids := make([]int64, topK)
+    for i := range ids { ids[i] = all[i].id }
+    return ids
+}
+
+func TestHNSWRecall(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    items := randomItems(rng, 2000, 32)
+    h := NewHNSW(HNSWConfig{M: 12, EfConstruction: 100, EfSearch: 64})
+    if err := h.Add(items); err != nil { t.Fatal(err) }
+    const queries, topK = 50, 10
+    found := 0
+    for i := 0; i < queries; i++ {
+        q := randomItems(rng, 1, 32)[0].Vector
+        hits, err := h.Search(q, topK, SearchOptions{})
+        if err != nil { t.Fatal(err) }
+        want := map[int64]bool{}
+        for _, id := range bruteForce(items, q, topK) { want[id] = true }
+        for _, hit := range hits {
+            if want[hit.ID] { found++ }
+        }
+    }
+    if recall := float64(found) / (queries * topK); recall < 0.9 { t.Errorf("recall@%d = %.3f, want >= 0.9", topK, recall) }
+}
+
+func TestHNSWDelete(t *testing.T) {
+    rng := rand.New(rand.NewSource(2))
+    items := randomItems(rng, 300, 16)
+    h := NewHNSW(HNSWConfig{M: 8, EfConstruction: 64})
+    if err := h.Add(items); err != nil { t.Fatal(err) }
+    deleted := map[int64]bool{}
+    var ids []int64
+    for i := 0; i < len(items); i += 3 { ids = append(ids, items[i].ID); deleted[items[i].ID] = true }
+    if err := h.Delete(append(ids, 9999)); err != nil { t.Fatal(err) }
+    if got, want := h.Len(), len(items)-len(ids); got != want { t.Errorf("Len = %d, want %d", got, want) }
+    for _, it := range items[:30] {
+        hits, err := h.Search(it.Vector, 20, SearchOptions{})
+        if err != nil { t.Fatal(err) }
+        if len(hits) != 20 { t.Errorf("got %d hits, want 20 despite tombstones", len(hits)) }
+        for _, hit := range hits {
+            if deleted[hit.ID] { t.Fatalf("deleted id %d returned", hit.ID) }
+        }
+    }
+
+    // Re-adding an id replaces its vector instead of keeping the stale one.
+    moved := Item{ID: items[1].ID, DocID: "d", Vector: items[2].Vector}
+    if err := h.Add([]Item{moved}); err != nil { t.Fatal(err) }
+    hits, err := h.Search(items[1].Vector, 1, SearchOptions{})
+    if err != nil { t.Fatal(err) }
+    if len(hits) == 1 && hits[0].ID == moved.ID && hits[0].Score > 0.999 { t.Errorf("id %d still found by its old vector", moved.ID) }
+    if got, want := h.Len(), len(items)-len(ids); got != want { t.Errorf("Len after re-add = %d, want %d", got, want) }
+}
+
+func TestHNSWSnapshotRoundTrip(t *testing.T) {
+    rng := rand.New(rand.NewSource(3))
+    items := randomItems(rng, 200, 16)
+    h := NewHNSW(HNSWConfig{M: 6, EfConstruction: 40, EfSearch: 20})
+    if err := h.Add(items); err != nil { t.Fatal(err) }
+    if err := h.Delete([]int64{items[0].ID}); err != nil { t.Fatal(err) }
+    path := filepath.Join(t.TempDir(), "t.hnsw")
+    if err := h.Save(path); err != nil { t.Fatal(err) }
+
+    // The graph's M and ef_construction come from the snapshot; only
+    // ef_search can be overridden.
+    loaded, err := LoadHNSW(path, HNSWConfig{M: 32, EfConstruction: 400, EfSearch: 50})
+    if err != nil { t.Fatal(err) }
+    if loaded.m != 6 || loaded.efConstruction != 40 || loaded.efSearch != 50 {
+        t.Errorf("loaded M=%d ef_construction=%d ef_search=%d, want 6, 40, 50", loaded.m, loaded.efConstruction, loaded.efSearch)
+    }
+    if kept, err := LoadHNSW(path, HNSWConfig{}); err != nil || kept.efSearch != 20 { t.Errorf("ef_search without override = %v (%v), want 20", kept, err) }
+    if loaded.Len() != h.Len() || loaded.deleted != 1 { t.Errorf("loaded Len=%d deleted=%d, want %d and 1", loaded.Len(), loaded.deleted, h.Len()) }
+
+    q := items[5].Vector
+    want, _ := h.Search(q, 10, SearchOptions{EfSearch: 50})
+    got, err := loaded.Search(q, 10, SearchOptions{})
+    if err != nil { t.Fatal(err) }
+    if !reflect.DeepEqual(got, want) { t.Errorf("search after load = %v, want %v", got, want) }
+}
+
+func TestHNSWConfigValidate(t *testing.T) {
+    tests := []struct {
+        cfg HNSWConfig
+        ok  bool
+    }{
+        {HNSWConfig{}, true},
+        {HNSWConfig{M: 2, EfConstruction: 2}, true},
+        {HNSWConfig{M: 1, EfConstruction: 200}, false},
+        {HNSWConfig{M: -4}, false},
+        {HNSWConfig{M: 32, EfConstruction: 16}, false},
+        {HNSWConfig{M: 16, EfSearch: -1}, false},
+    }
+    for _, tt := range tests {
+        if err := tt.cfg.Validate(); (err == nil) != tt.ok { t.Errorf("Validate(%+v) = %v, want ok=%v", tt.cfg, err, tt.ok) }
+    }
+}
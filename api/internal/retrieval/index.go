@@ -0,0 +1,103 @@
+package retrieval
+
+import (
+    "context"
+    "errors"
+    "log"
+)
+
+// Item is a vector to index. DocID travels with the vector so searches can be
+// filtered by document inside the index.
+type Item struct {
+    ID     int64
+    DocID  string
+    Vector []float32
+}
+
+// SearchOptions narrows a search and tunes approximate indexes. Zero values
+// mean no filter and the index's default accuracy.
+type SearchOptions struct {
+    DocIDs []string
+    // NProbe is the number of IVF lists visited (FAISS IVF-PQ).
+    NProbe int
+    // EfSearch is the HNSW beam width (FAISS HNSW and the in-process index).
+    EfSearch int
+}
+
+func (o SearchOptions) allows(docID string) bool {
+    if len(o.DocIDs) == 0 { return true }
+    for _, d := range o.DocIDs {
+        if d == docID { return true }
+    }
+    return false
+}
+
+// Hit is a search result; chunk content is loaded from Postgres by the caller.
+type Hit struct {
+    ID    int64
+    DocID string
+    Score float32
+    // Fused is the reciprocal rank fusion score set by Fuse.
+    Fused float64
+}
+
+// Index is the single retrieval abstraction: FAISS, pgvector and the
+// in-process HNSW index all implement it. Every call is scoped to a tenant and
+// implementations must never return another tenant's vectors.
+type Index interface {
+    Add(ctx context.Context, tenant string, items []Item) error
+    Delete(ctx context.Context, tenant string, ids []int64) error
+    Search(ctx context.Context, tenant string, query []float32, topK int, opts SearchOptions) ([]Hit, error)
+}
+
+// Rebuildable is a derived index that can be emptied and refilled from the
+// durable one, e.g. after a restart lost it or the embedding model changed.
+type Rebuildable interface {
+    Index
+    Reset(ctx context.Context, tenant string) error
+}
+
+// Composite writes to a durable index plus any number of fast ones and
+// searches the fast ones first, falling back in order on error or empty
+// result. Fast indexes can be rebuilt from the durable one, so their write
+// failures are logged rather than returned.
+type Composite struct {
+    Durable Index
+    Fast    []Index
+}
+
+func (c *Composite) Add(ctx context.Context, tenant string, items []Item) error {
+    if err := c.Durable.Add(ctx, tenant, items); err != nil { return err }
+    for _, f := range c.Fast {
+        if err := f.Add(ctx, tenant, items); err != nil { log.Printf("index add (%T): %v", f, err) }
+    }
+    return nil
+}
+
+func (c *Composite) Delete(ctx context.Context, tenant string, ids []int64) error {
+    if err := c.Durable.Delete(ctx, tenant, ids); err != nil { return err }
+    for _, f := range c.Fast {
+        if err := f.Delete(ctx, tenant, ids); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+            log.Printf("index delete (%T): %v", f, err)
+        }
+    }
+    return nil
+}
+
+func (c *Composite) Search(ctx context.Context, tenant string, query []float32, topK int, opts SearchOptions) ([]Hit, error) {
+    for _, fast := range c.Fast {
+        hits, err := fast.Search(ctx, tenant, query, topK, opts)
+        if err == nil && len(hits) > 0 { return hits, nil }
+        if err != nil { log.Printf("index search (%T), falling back: %v", fast, err) }
+    }
+    return c.Durable.Search(ctx, tenant, query, topK, opts)
+}
+
+// Rebuildable returns the fast indexes that support rebuilding.
+func (c *Composite) Rebuildable() []Rebuildable {
+    var out []Rebuildable
+    for _, f := range c.Fast {
+        if r, ok := f.(Rebuildable); ok { out = append(out, r) }
+    }
+    return out
+}
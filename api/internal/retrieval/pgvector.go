@@ -0,0 +1,37 @@
+package retrieval
+
+import (
+    "context"
+
+    "github.com/hiepdt/contest/services/api/internal/storage"
+)
+
+// Pgvector is the durable Index of one collection: embeddings live in
+// chunk_embeddings under the collection's model and are searched with
+// pgvector. Chunk rows themselves are owned by the repository, so Delete is a
+// no-op (deleting the rows deletes the vectors).
+type Pgvector struct {
+    Repo       *storage.Repository
+    Collection storage.Collection
+}
+
+func NewPgvector(repo *storage.Repository, col storage.Collection) *Pgvector {
+    return &Pgvector{Repo: repo, Collection: col}
+}
+
+func (p *Pgvector) Add(ctx context.Context, tenant string, items []Item) error {
+    ids := make([]int64, len(items))
+    vecs := make([][]float32, len(items))
+    for i, it := range items { ids[i], vecs[i] = it.ID, it.Vector }
+    return p.Repo.SetEmbeddings(ctx, tenant, p.Collection.Model, ids, vecs)
+}
+
+func (p *Pgvector) Delete(ctx context.Context, tenant string, ids []int64) error { return nil }
+
+func (p *Pgvector) Search(ctx context.Context, tenant string, query []float32, topK int, opts SearchOptions) ([]Hit, error) {
+    rows, err := p.Repo.SimilarChunks(ctx, tenant, p.Collection, query, topK, opts.DocIDs)
+    if err != nil { return nil, err }
+    hits := make([]Hit, len(rows))
+    for i, r := range rows { hits[i] = Hit{ID: r.ID, DocID: r.DocID, Score: r.Score} }
+    return hits, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Conversation struct {
+    ID         int64     `json:"id"`
+    DocumentID string    `json:"document_id,omitempty"`
+    Title      string    `json:"title,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+type Message struct {
+    ID        int64           `json:"id"`
+    Role      string          `json:"role"`
+    Content   string          `json:"content"`
+    Citations json.RawMessage `json:"citations,omitempty"`
+    CreatedAt time.Time       `json:"created_at"`
+}
+
+func (r *Repository) CreateConversation(ctx context.Context, tenant, docID, title string) (Conversation, error) {
+    c := Conversation{DocumentID: docID, Title: title}
+    err := r.DB.Pool.QueryRow(ctx, `INSERT INTO conversations(tenant_id, document_id, title)
+        VALUES($1, NULLIF($2,''), $3) RETURNING id, created_at`, tenant, docID, title).Scan(&c.ID, &c.CreatedAt)
+    return c, err
+}
+
+// GetConversation returns ErrNotFound for ids of other tenants.
+func (r *Repository) GetConversation(ctx context.Context, tenant string, id int64) (Conversation, error) {
+    var c Conversation
+    var docID, title *string
+    err := r.DB.Pool.QueryRow(ctx, `SELECT id, document_id, title, created_at FROM conversations
+        WHERE tenant_id=$1 AND id=$2`, tenant, id).Scan(&c.ID, &docID, &title, &c.CreatedAt)
+    if errors.Is(err, pgx.ErrNoRows) { return c, ErrNotFound }
+    if docID != nil { c.DocumentID = *docID }
+    if title != nil { c.Title = *title }
+    return c, err
+}
+
+// RecentMessages returns up to limit latest messages in chronological order.
+// The conversation must already have been checked to belong to the caller.
+func (r *Repository) RecentMessages(ctx context.Context, convID int64, limit int) ([]Message, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT id, role, content, citations, created_at FROM
+        (SELECT * FROM messages WHERE conversation_id=$1 ORDER BY id DESC LIMIT $2) m ORDER BY id`, convID, limit)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Message
+    for rows.Next() {
+        var m Message
+        var cit []byte
+        if err := rows.Scan(&m.ID, &m.Role, &m.Content, &cit, &m.CreatedAt); err != nil { return nil, err }
+        m.Citations = cit
+        out = append(out, m)
+    }
+    return out, rows.Err()
+}
+
+func (r *Repository) AppendMessage(ctx context.Context, convID int64, role, content string, citations any) (int64, error) {
+    var cit []byte
+    if citations != nil {
+        b, err := json.Marshal(citations)
+        if err != nil { return 0, err }
+        cit = b
+    }
+    var id int64
+    err := r.DB.Pool.QueryRow(ctx, `INSERT INTO messages(conversation_id, role, content, citations)
+        VALUES($1,$2,$3,$4) RETURNING id`, convID, role, content, cit).Scan(&id)
+    return id, err
+}
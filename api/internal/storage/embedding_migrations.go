@@ -0,0 +1,175 @@
+package storage
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5"
+)
+
+// ErrMigrationRunning is returned when a re-embedding job is already running.
+var ErrMigrationRunning = errors.New("an embedding migration is already running")
+
+// EmbeddingMigration is a background job re-embedding every chunk with Model.
+// Status is running, completed, failed or cancelled.
+type EmbeddingMigration struct {
+    ID          int64      `json:"id"`
+    Model       string     `json:"model"`
+    Status      string     `json:"status"`
+    Total       int64      `json:"total"`
+    Done        int64      `json:"done"`
+    LastChunkID int64      `json:"last_chunk_id"`
+    Error       string     `json:"error,omitempty"`
+    StartedAt   time.Time  `json:"started_at"`
+    UpdatedAt   time.Time  `json:"updated_at"`
+    FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+const migrationColumns = `id, model, status, total, done, last_chunk_id, COALESCE(error,''), started_at, updated_at, finished_at`
+
+func scanMigration(row pgx.Row) (EmbeddingMigration, error) {
+    var m EmbeddingMigration
+    err := row.Scan(&m.ID, &m.Model, &m.Status, &m.Total, &m.Done, &m.LastChunkID, &m.Error, &m.StartedAt, &m.UpdatedAt, &m.FinishedAt)
+    if errors.Is(err, pgx.ErrNoRows) { return m, ErrNotFound }
+    return m, err
+}
+
+// CreateEmbeddingMigration records a new running job for model with the
+// number of chunks it has to embed.
+func (r *Repository) CreateEmbeddingMigration(ctx context.Context, model string, total int64) (EmbeddingMigration, error) {
+    m, err := scanMigration(r.DB.Pool.QueryRow(ctx, `INSERT INTO embedding_migrations(model, total) VALUES($1,$2)
+        RETURNING `+migrationColumns, model, total))
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) && pgErr.Code == "23505" { return m, ErrMigrationRunning }
+    return m, err
+}
+
+// UpdateEmbeddingMigration stores progress so a restarted job resumes after
+// lastChunkID. It returns ErrNotFound once the job is no longer running,
+// e.g. cancelled from another replica.
+func (r *Repository) UpdateEmbeddingMigration(ctx context.Context, id, done, lastChunkID int64) error {
+    tag, err := r.DB.Pool.Exec(ctx, `UPDATE embedding_migrations SET done=$2, last_chunk_id=$3, updated_at=NOW()
+        WHERE id=$1 AND status='running'`, id, done, lastChunkID)
+    if err != nil { return err }
+    if tag.RowsAffected() == 0 { return ErrNotFound }
+    return nil
+}
+
+// CancelEmbeddingMigration marks a running job cancelled; the replica
+// running it stops at its next batch. ErrNotFound means it was not running.
+func (r *Repository) CancelEmbeddingMigration(ctx context.Context, id int64) error {
+    tag, err := r.DB.Pool.Exec(ctx, `UPDATE embedding_migrations SET status='cancelled', updated_at=NOW(), finished_at=NOW()
+        WHERE id=$1 AND status='running'`, id)
+    if err != nil { return err }
+    if tag.RowsAffected() == 0 { return ErrNotFound }
+    return nil
+}
+
+// migrationLockClass namespaces the advisory locks of embedding jobs.
+const migrationLockClass = 0x656d62 // "emb"
+
+// LeaseEmbeddingMigration takes a session advisory lock on job id, so only
+// one replica runs it. The lock lives on a connection held until release is
+// called, and Postgres drops it if the process dies. ok is false when
+// another replica holds it.
+func (r *Repository) LeaseEmbeddingMigration(ctx context.Context, id int64) (release func(), ok bool, err error) {
+    conn, err := r.DB.Pool.Acquire(ctx)
+    if err != nil { return nil, false, err }
+    if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1::int, $2::int)`, migrationLockClass, int32(id)).Scan(&ok); err != nil || !ok {
+        conn.Release()
+        return nil, false, err
+    }
+    return func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        // A session that could not unlock must not go back to the pool
+        // still holding the lock.
+        if _, err := conn.Exec(ctx, `SELECT pg_advisory_unlock($1::int, $2::int)`, migrationLockClass, int32(id)); err != nil { _ = conn.Conn().Close(ctx) }
+        conn.Release()
+    }, true, nil
+}
+
+// FinishEmbeddingMigration moves a running job to its final status.
+func (r *Repository) FinishEmbeddingMigration(ctx context.Context, id int64, status, msg string) error {
+    _, err := r.DB.Pool.Exec(ctx, `UPDATE embedding_migrations SET status=$2, error=NULLIF($3,''),
+        updated_at=NOW(), finished_at=NOW() WHERE id=$1 AND status='running'`, id, status, msg)
+    return err
+}
+
+func (r *Repository) GetEmbeddingMigration(ctx context.Context, id int64) (EmbeddingMigration, error) {
+    return scanMigration(r.DB.Pool.QueryRow(ctx, `SELECT `+migrationColumns+` FROM embedding_migrations WHERE id=$1`, id))
+}
+
+// EmbeddingMigrations lists the latest jobs, newest first.
+func (r *Repository) EmbeddingMigrations(ctx context.Context, limit int) ([]EmbeddingMigration, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT `+migrationColumns+` FROM embedding_migrations ORDER BY id DESC LIMIT $1`, limit)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []EmbeddingMigration
+    for rows.Next() {
+        m, err := scanMigration(rows)
+        if err != nil { return nil, err }
+        out = append(out, m)
+    }
+    return out, rows.Err()
+}
+
+// RunningEmbeddingMigration returns ErrNotFound when no job is running.
+func (r *Repository) RunningEmbeddingMigration(ctx context.Context) (EmbeddingMigration, error) {
+    return scanMigration(r.DB.Pool.QueryRow(ctx, `SELECT `+migrationColumns+` FROM embedding_migrations WHERE status='running'`))
+}
+
+// PendingChunk is a chunk still lacking a vector of some model.
+type PendingChunk struct {
+    ID       int64
+    TenantID string
+    DocID    string
+    Content  string
+}
+
+// ChunksMissingEmbedding pages, across all tenants, through chunks that have
+// no vector of model yet, by id after afterID.
+func (r *Repository) ChunksMissingEmbedding(ctx context.Context, model string, afterID int64, limit int) ([]PendingChunk, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT c.id, c.tenant_id, c.document_id, c.content FROM chunks c
+        WHERE c.id > $2 AND NOT EXISTS (SELECT 1 FROM chunk_embeddings e WHERE e.chunk_id=c.id AND e.model=$1)
+        ORDER BY c.id LIMIT $3`, model, afterID, limit)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []PendingChunk
+    for rows.Next() {
+        var c PendingChunk
+        if err := rows.Scan(&c.ID, &c.TenantID, &c.DocID, &c.Content); err != nil { return nil, err }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+func (r *Repository) CountChunksMissingEmbedding(ctx context.Context, model string) (int64, error) {
+    var n int64
+    err := r.DB.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM chunks c
+        WHERE NOT EXISTS (SELECT 1 FROM chunk_embeddings e WHERE e.chunk_id=c.id AND e.model=$1)`, model).Scan(&n)
+    return n, err
+}
+
+// ActiveCollection returns the model queries use, or "" if none was set.
+func (r *Repository) ActiveCollection(ctx context.Context) (string, error) {
+    var model string
+    err := r.DB.Pool.QueryRow(ctx, `SELECT model FROM collections WHERE active`).Scan(&model)
+    if errors.Is(err, pgx.ErrNoRows) { return "", nil }
+    return model, err
+}
+
+// ActivateCollection makes model the active collection in one transaction,
+// so there is never zero or two active collections.
+func (r *Repository) ActivateCollection(ctx context.Context, model string) error {
+    tx, err := r.DB.Pool.Begin(ctx)
+    if err != nil { return err }
+    defer tx.Rollback(ctx)
+    if _, err := tx.Exec(ctx, `UPDATE collections SET active=false WHERE active AND model<>$1`, model); err != nil { return err }
+    tag, err := tx.Exec(ctx, `UPDATE collections SET active=true WHERE model=$1`, model)
+    if err != nil { return err }
+    if tag.RowsAffected() == 0 { return ErrNotFound }
+    return tx.Commit(ctx)
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// Collection records which embedding model produced a set of vectors and
+// their dimension. Vectors of different collections are never compared.
+type Collection struct {
+    Model     string    `json:"model"`
+    Dim       int       `json:"dim"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+var nonIdent = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// vectorIndexName names model's pgvector index after a hash of the model,
+// so distinct models never share an index and the name stays well under
+// Postgres' 63-byte identifier limit, which would silently truncate it.
+func vectorIndexName(model string) string {
+    sum := sha256.Sum256([]byte(model))
+    return "chunk_embeddings_hnsw_" + hex.EncodeToString(sum[:8])
+}
+
+// EnsureCollection registers model with its probed dimension and creates its
+// pgvector HNSW index. It fails if the model was recorded earlier with a
+// different dimension, which would make stored vectors unusable.
+func (r *Repository) EnsureCollection(ctx context.Context, model string, dim int) (Collection, error) {
+    var c Collection
+    var created bool
+    // xmax = 0 only for a freshly inserted row.
+    err := r.DB.Pool.QueryRow(ctx, `INSERT INTO collections(model, dim) VALUES($1,$2)
+        ON CONFLICT (model) DO UPDATE SET model=EXCLUDED.model
+        RETURNING model, dim, created_at, xmax = 0`, model, dim).Scan(&c.Model, &c.Dim, &c.CreatedAt, &created)
+    if err != nil { return c, err }
+    if c.Dim != dim {
+        return c, fmt.Errorf("embedding model %q now returns %d dimensions but %d are stored; migrate to a new model instead", model, dim, c.Dim)
+    }
+    if created {
+        // Vectors written before collections existed sit in chunks.embedding;
+        // the first collection of the same size adopts them.
+        if _, err := r.DB.Pool.Exec(ctx, `INSERT INTO chunk_embeddings(chunk_id, model, tenant_id, document_id, embedding)
+            SELECT id, $1, tenant_id, document_id, embedding FROM chunks
+            WHERE embedding IS NOT NULL AND vector_dims(embedding) = $2
+            ON CONFLICT DO NOTHING`, model, dim); err != nil { return c, err }
+    }
+    // pgvector indexes need a fixed dimension, so each model gets a partial
+    // index over its own rows cast to vector(dim); searches use the same cast.
+    if dim <= 2000 {
+        if _, err := r.DB.Pool.Exec(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON chunk_embeddings
+            USING hnsw ((embedding::vector(%d)) vector_cosine_ops) WHERE model = %s`,
+            pgx.Identifier{vectorIndexName(model)}.Sanitize(), dim, quoteLiteral(model))); err != nil { return c, err }
+        // Earlier releases named the index after the sanitized model name,
+        // which distinct models could share; drop it once the new one exists.
+        legacy := "chunk_embeddings_hnsw_" + strings.Trim(nonIdent.ReplaceAllString(strings.ToLower(model), "_"), "_")
+        if len(legacy) > 63 { legacy = legacy[:63] }
+        _, err = r.DB.Pool.Exec(ctx, `DROP INDEX IF EXISTS `+pgx.Identifier{legacy}.Sanitize())
+    }
+    return c, err
+}
+
+func (r *Repository) Collections(ctx context.Context) ([]Collection, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT model, dim, created_at FROM collections ORDER BY created_at`)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Collection
+    for rows.Next() {
+        var c Collection
+        if err := rows.Scan(&c.Model, &c.Dim, &c.CreatedAt); err != nil { return nil, err }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+// SimilarChunks is a cosine search with pgvector over model's vectors,
+// optionally limited to docIDs. Score is cosine similarity. The dimension
+// cast matches the collection's partial index so the planner can use it.
+func (r *Repository) SimilarChunks(ctx context.Context, tenant string, col Collection, query []float32, topK int, docIDs []string) ([]struct{ID int64; DocID string; Score float32}, error) {
+    if len(docIDs) == 0 { docIDs = nil }
+    dist := fmt.Sprintf("embedding::vector(%d) <=> $1::real[]::vector(%d)", col.Dim, col.Dim)
+    rows, err := r.DB.Pool.Query(ctx, `
+        SELECT chunk_id, document_id, 1 - (`+dist+`) AS score
+        FROM chunk_embeddings WHERE model=$2 AND tenant_id=$3
+            AND ($5::text[] IS NULL OR document_id = ANY($5))
+        ORDER BY `+dist+`
+        LIMIT $4`, query, col.Model, tenant, topK, docIDs)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var res []struct{ID int64; DocID string; Score float32}
+    for rows.Next() {
+        var it struct{ID int64; DocID string; Score float32}
+        if err := rows.Scan(&it.ID, &it.DocID, &it.Score); err != nil { return nil, err }
+        res = append(res, it)
+    }
+    return res, rows.Err()
+}
+
+// SetEmbeddings stores model's vectors for chunks of the tenant; ids of other
+// tenants are skipped.
+func (r *Repository) SetEmbeddings(ctx context.Context, tenant, model string, ids []int64, vecs [][]float32) error {
+    batch := &pgx.Batch{}
+    for i, id := range ids {
+        batch.Queue(`INSERT INTO chunk_embeddings(chunk_id, model, tenant_id, document_id, embedding)
+            SELECT id, $2, tenant_id, document_id, $1::real[]::vector FROM chunks WHERE tenant_id=$3 AND id=$4
+            ON CONFLICT (chunk_id, model) DO UPDATE SET embedding=EXCLUDED.embedding`, vecs[i], model, tenant, id)
+    }
+    return r.DB.Pool.SendBatch(ctx, batch).Close()
+}
+
+type ChunkEmbedding struct {
+    ID     int64
+    DocID  string
+    Vector []float32
+}
+
+// EmbeddingsAfter pages through model's stored embeddings by chunk id (keyset
+// pagination), so a whole collection can be streamed without holding a
+// cursor open.
+func (r *Repository) EmbeddingsAfter(ctx context.Context, tenant, model string, afterID int64, limit int) ([]ChunkEmbedding, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT chunk_id, document_id, embedding::real[] FROM chunk_embeddings
+        WHERE model=$1 AND tenant_id=$2 AND chunk_id > $3 ORDER BY chunk_id LIMIT $4`, model, tenant, afterID, limit)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    return scanEmbeddings(rows)
+}
+
+// SampleEmbeddings returns up to n random embeddings of the tenant in model,
+// e.g. to train an IVF index or draw benchmark queries.
+func (r *Repository) SampleEmbeddings(ctx context.Context, tenant, model string, n int) ([]ChunkEmbedding, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT chunk_id, document_id, embedding::real[] FROM chunk_embeddings
+        WHERE model=$1 AND tenant_id=$2 ORDER BY random() LIMIT $3`, model, tenant, n)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    return scanEmbeddings(rows)
+}
+
+func scanEmbeddings(rows pgx.Rows) ([]ChunkEmbedding, error) {
+    var out []ChunkEmbedding
+    for rows.Next() {
+        var e ChunkEmbedding
+        if err := rows.Scan(&e.ID, &e.DocID, &e.Vector); err != nil { return nil, err }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+func quoteLiteral(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
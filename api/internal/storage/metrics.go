@@ -0,0 +1,56 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+)
+
+// MetricValue is a key metric of a document for one period, normalised to
+// base units (see finance.Amount); Raw is the figure as written.
+type MetricValue struct {
+    DocumentID string          `json:"document_id"`
+    Name       string          `json:"name"`
+    Period     string          `json:"period"`
+    Value      float64         `json:"value"`
+    Unit       string          `json:"unit"`
+    Raw        string          `json:"raw"`
+    ChunkID    int64           `json:"chunk_id"`
+    Citation   json.RawMessage `json:"citation,omitempty"`
+    Model      string          `json:"model,omitempty"`
+    CreatedAt  time.Time       `json:"created_at"`
+}
+
+// UpsertMetrics stores values, replacing earlier extractions of the same
+// metric and period.
+func (r *Repository) UpsertMetrics(ctx context.Context, tenant string, values []MetricValue) error {
+    tx, err := r.DB.Pool.Begin(ctx)
+    if err != nil { return err }
+    defer tx.Rollback(ctx)
+    for _, v := range values {
+        _, err := tx.Exec(ctx, `INSERT INTO metrics(tenant_id, document_id, name, period, value, unit, raw, chunk_id, citation, model)
+            VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+            ON CONFLICT (tenant_id, document_id, name, period) DO UPDATE SET value=EXCLUDED.value, unit=EXCLUDED.unit,
+            raw=EXCLUDED.raw, chunk_id=EXCLUDED.chunk_id, citation=EXCLUDED.citation, model=EXCLUDED.model, created_at=NOW()`,
+            tenant, v.DocumentID, v.Name, v.Period, v.Value, v.Unit, v.Raw, v.ChunkID, []byte(v.Citation), v.Model)
+        if err != nil { return err }
+    }
+    return tx.Commit(ctx)
+}
+
+// DocumentMetrics lists the stored metrics of a document.
+func (r *Repository) DocumentMetrics(ctx context.Context, tenant, docID string) ([]MetricValue, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT document_id, name, period, value, unit, raw, COALESCE(chunk_id, 0), citation, COALESCE(model, ''), created_at
+        FROM metrics WHERE tenant_id=$1 AND document_id=$2 ORDER BY name, period`, tenant, docID)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    out := []MetricValue{}
+    for rows.Next() {
+        var v MetricValue
+        var cit []byte
+        if err := rows.Scan(&v.DocumentID, &v.Name, &v.Period, &v.Value, &v.Unit, &v.Raw, &v.ChunkID, &cit, &v.Model, &v.CreatedAt); err != nil { return nil, err }
+        v.Citation = cit
+        out = append(out, v)
+    }
+    return out, rows.Err()
+}
@@ -27,15 +27,179 @@ CREATE TABLE IF NOT EXISTS audits (
     prompt_tokens INTEGER,
     created_at TIMESTAMP DEFAULT NOW()
 );
+
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP DEFAULT NOW()
+);
 `
 
+// migrations evolve the base schema. Each entry runs once, in order, and is
+// recorded in schema_migrations by its 1-based position; only append.
+var migrations = []string{
+    // 1: tenant/workspace isolation. Document ids are unique per tenant.
+    `
+    ALTER TABLE documents ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+    ALTER TABLE chunks ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+    ALTER TABLE audits ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+    ALTER TABLE chunks DROP CONSTRAINT IF EXISTS chunks_document_id_fkey;
+    ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_pkey;
+    ALTER TABLE documents ADD PRIMARY KEY (tenant_id, id);
+    ALTER TABLE chunks ADD CONSTRAINT chunks_document_fkey FOREIGN KEY (tenant_id, document_id)
+        REFERENCES documents(tenant_id, id) ON DELETE CASCADE;
+    CREATE INDEX IF NOT EXISTS chunks_tenant_doc_idx ON chunks(tenant_id, document_id);
+    CREATE INDEX IF NOT EXISTS audits_tenant_idx ON audits(tenant_id, created_at);
+    `,
+    // 2: conversational QA sessions.
+    `
+    CREATE TABLE IF NOT EXISTS conversations (
+        id BIGSERIAL PRIMARY KEY,
+        tenant_id TEXT NOT NULL,
+        document_id TEXT,
+        title TEXT,
+        created_at TIMESTAMP DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS conversations_tenant_idx ON conversations(tenant_id, created_at);
+    CREATE TABLE IF NOT EXISTS messages (
+        id BIGSERIAL PRIMARY KEY,
+        conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+        role TEXT NOT NULL,
+        content TEXT NOT NULL,
+        citations JSONB,
+        created_at TIMESTAMP DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages(conversation_id, id);
+    `,
+    // 3: embeddings are now stored; the dimension follows the embedding model.
+    `
+    ALTER TABLE chunks ALTER COLUMN embedding TYPE vector;
+    `,
+    // 4: embeddings per model ("collection"), so two models can coexist. The
+    // per-model ANN index is created by EnsureCollection once the dimension is
+    // known; chunks.embedding is legacy and copied over on first use.
+    `
+    CREATE TABLE IF NOT EXISTS collections (
+        model TEXT PRIMARY KEY,
+        dim INTEGER NOT NULL,
+        created_at TIMESTAMP DEFAULT NOW()
+    );
+    CREATE TABLE IF NOT EXISTS chunk_embeddings (
+        chunk_id BIGINT NOT NULL REFERENCES chunks(id) ON DELETE CASCADE,
+        model TEXT NOT NULL REFERENCES collections(model),
+        tenant_id TEXT NOT NULL,
+        document_id TEXT NOT NULL,
+        embedding vector NOT NULL,
+        PRIMARY KEY (chunk_id, model)
+    );
+    CREATE INDEX IF NOT EXISTS chunk_embeddings_scope_idx ON chunk_embeddings(model, tenant_id, chunk_id);
+    `,
+    // 5: re-embedding jobs and the persisted query model. At most one
+    // collection is active and at most one job runs at a time.
+    `
+    ALTER TABLE collections ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT false;
+    CREATE UNIQUE INDEX IF NOT EXISTS collections_active_idx ON collections(active) WHERE active;
+    CREATE TABLE IF NOT EXISTS embedding_migrations (
+        id BIGSERIAL PRIMARY KEY,
+        model TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'running',
+        total BIGINT NOT NULL DEFAULT 0,
+        done BIGINT NOT NULL DEFAULT 0,
+        last_chunk_id BIGINT NOT NULL DEFAULT 0,
+        error TEXT,
+        started_at TIMESTAMP DEFAULT NOW(),
+        updated_at TIMESTAMP DEFAULT NOW(),
+        finished_at TIMESTAMP
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS embedding_migrations_running_idx ON embedding_migrations((true)) WHERE status = 'running';
+    `,
+    // 6: prompt templates editable at runtime, and which model and prompt
+    // version produced each audited answer.
+    `
+    CREATE TABLE IF NOT EXISTS prompt_templates (
+        name TEXT NOT NULL,
+        version TEXT NOT NULL,
+        body TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT NOW(),
+        PRIMARY KEY (name, version)
+    );
+    ALTER TABLE audits ADD COLUMN IF NOT EXISTS model TEXT;
+    ALTER TABLE audits ADD COLUMN IF NOT EXISTS prompt_name TEXT;
+    ALTER TABLE audits ADD COLUMN IF NOT EXISTS prompt_version TEXT;
+    CREATE INDEX IF NOT EXISTS audits_prompt_idx ON audits(prompt_name, prompt_version, created_at);
+    `,
+    // 7: detected language of each document ("vi", "en"); NULL until the
+    // document is ingested again.
+    `
+    ALTER TABLE documents ADD COLUMN IF NOT EXISTS language TEXT;
+    `,
+    // 8: tables found in ingested text, as rows of cells. chunk_id is the
+    // chunk holding the table's text rendering, which is what gets embedded.
+    `
+    CREATE TABLE IF NOT EXISTS document_tables (
+        id BIGSERIAL PRIMARY KEY,
+        tenant_id TEXT NOT NULL,
+        document_id TEXT NOT NULL,
+        chunk_id BIGINT REFERENCES chunks(id) ON DELETE CASCADE,
+        page INTEGER,
+        title TEXT,
+        kind TEXT NOT NULL,
+        header TEXT[],
+        created_at TIMESTAMP DEFAULT NOW(),
+        FOREIGN KEY (tenant_id, document_id) REFERENCES documents(tenant_id, id) ON DELETE CASCADE
+    );
+    CREATE INDEX IF NOT EXISTS document_tables_doc_idx ON document_tables(tenant_id, document_id, id);
+    CREATE TABLE IF NOT EXISTS document_table_rows (
+        table_id BIGINT NOT NULL REFERENCES document_tables(id) ON DELETE CASCADE,
+        row_index INTEGER NOT NULL,
+        cells TEXT[] NOT NULL,
+        PRIMARY KEY (table_id, row_index)
+    );
+    `,
+    // 9: key metrics extracted from documents, one row per metric and period,
+    // with the chunk (and highlighted sentence) they were read from.
+    `
+    CREATE TABLE IF NOT EXISTS metrics (
+        id BIGSERIAL PRIMARY KEY,
+        tenant_id TEXT NOT NULL,
+        document_id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        period TEXT NOT NULL DEFAULT '',
+        value DOUBLE PRECISION NOT NULL,
+        unit TEXT NOT NULL DEFAULT '',
+        raw TEXT NOT NULL,
+        chunk_id BIGINT REFERENCES chunks(id) ON DELETE SET NULL,
+        citation JSONB,
+        model TEXT,
+        created_at TIMESTAMP DEFAULT NOW(),
+        FOREIGN KEY (tenant_id, document_id) REFERENCES documents(tenant_id, id) ON DELETE CASCADE,
+        UNIQUE (tenant_id, document_id, name, period)
+    );
+    `,
+}
+
 // RunMigrations creates tables; VECTOR type requires pgvector extension.
 // We enable it if available; on vanilla Postgres it's optional (embedding can be NULL).
 func (d *Database) RunMigrations(ctx context.Context) error {
     _, err := d.Pool.Exec(ctx, `CREATE EXTENSION IF NOT EXISTS vector;`)
     if err != nil { /* ignore on systems without superuser */ }
     _, err = d.Pool.Exec(ctx, schema)
-    return err
+    if err != nil { return err }
+    for i, m := range migrations {
+        if err := d.applyMigration(ctx, i+1, m); err != nil { return err }
+    }
+    return nil
 }
 
-
+func (d *Database) applyMigration(ctx context.Context, version int, sql string) error {
+    tx, err := d.Pool.Begin(ctx)
+    if err != nil { return err }
+    defer tx.Rollback(ctx)
+    // Serialise concurrent API replicas starting at the same time.
+    if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(7261)`); err != nil { return err }
+    var applied bool
+    if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version=$1)`, version).Scan(&applied); err != nil { return err }
+    if applied { return nil }
+    if _, err := tx.Exec(ctx, sql); err != nil { return err }
+    if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations(version) VALUES($1)`, version); err != nil { return err }
+    return tx.Commit(ctx)
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+    "context"
+    "errors"
+    "time"
+)
+
+// ErrPromptVersionExists means a stored prompt version was saved again;
+// versions are immutable so audits and cached answers keep their meaning.
+var ErrPromptVersionExists = errors.New("prompt version already exists")
+
+// PromptTemplate is a prompt version stored in Postgres; see package prompts.
+type PromptTemplate struct {
+    Name      string    `json:"name"`
+    Version   string    `json:"version"`
+    Body      string    `json:"body"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *Repository) PromptTemplates(ctx context.Context) ([]PromptTemplate, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT name, version, body, created_at FROM prompt_templates ORDER BY name, version`)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []PromptTemplate
+    for rows.Next() {
+        var t PromptTemplate
+        if err := rows.Scan(&t.Name, &t.Version, &t.Body, &t.CreatedAt); err != nil { return nil, err }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
+
+// SavePromptTemplate creates a version; it returns ErrPromptVersionExists
+// if the version is already stored.
+func (r *Repository) SavePromptTemplate(ctx context.Context, name, version, body string) error {
+    tag, err := r.DB.Pool.Exec(ctx, `INSERT INTO prompt_templates(name, version, body) VALUES($1,$2,$3)
+        ON CONFLICT (name, version) DO NOTHING`, name, version, body)
+    if err != nil { return err }
+    if tag.RowsAffected() == 0 { return ErrPromptVersionExists }
+    return nil
+}
+
+// Audit is one generation, recorded for cost tracking and for comparing
+// prompt versions and models.
+type Audit struct {
+    Tenant        string
+    Endpoint      string
+    LatencyMs     int64
+    PromptTokens  int
+    Model         string
+    PromptName    string
+    PromptVersion string
+}
+
+func (r *Repository) InsertAudit(ctx context.Context, a Audit) error {
+    _, err := r.DB.Pool.Exec(ctx, `INSERT INTO audits(tenant_id, endpoint, latency_ms, prompt_tokens, model, prompt_name, prompt_version)
+        VALUES($1,$2,$3,$4,$5,$6,$7)`, a.Tenant, a.Endpoint, a.LatencyMs, a.PromptTokens, a.Model, a.PromptName, a.PromptVersion)
+    return err
+}
@@ -2,48 +2,91 @@ package storage
 
 import (
     "context"
+    "strings"
 )
 
+// Repository methods are all scoped by tenant; callers must pass the tenant
+// resolved from the authenticated request, never one taken from the body.
 type Repository struct { DB *Database }
 
 func NewRepository(db *Database) *Repository { return &Repository{DB: db} }
 
-func (r *Repository) UpsertDocument(ctx context.Context, id, title string) error {
-    _, err := r.DB.Pool.Exec(ctx, `INSERT INTO documents(id, title) VALUES($1,$2)
-        ON CONFLICT (id) DO UPDATE SET title=EXCLUDED.title`, id, title)
+// UpsertDocument creates or updates a document. An empty title or
+// language keeps the one already stored.
+func (r *Repository) UpsertDocument(ctx context.Context, tenant, id, title, language string) error {
+    _, err := r.DB.Pool.Exec(ctx, `INSERT INTO documents(tenant_id, id, title, language) VALUES($1,$2,NULLIF($3,''),NULLIF($4,''))
+        ON CONFLICT (tenant_id, id) DO UPDATE SET title=COALESCE(EXCLUDED.title, documents.title),
+        language=COALESCE(EXCLUDED.language, documents.language)`, tenant, id, title, language)
     return err
 }
 
-func (r *Repository) InsertChunk(ctx context.Context, docID string, page int, span, content string, embedding []float32) error {
-    // Để tránh lỗi kiểu với pgvector khi client chưa đăng ký type, tạm set embedding = NULL.
-    _, err := r.DB.Pool.Exec(ctx, `INSERT INTO chunks(document_id,page,span,content,embedding)
-        VALUES($1,$2,$3,$4,NULL)`, docID, page, span, content)
-    return err
+// DocumentLanguages maps each of the tenant's documents among ids to its
+// language; documents without one are left out.
+func (r *Repository) DocumentLanguages(ctx context.Context, tenant string, ids []string) (map[string]string, error) {
+    out := map[string]string{}
+    if len(ids) == 0 { return out, nil }
+    rows, err := r.DB.Pool.Query(ctx, `SELECT id, language FROM documents WHERE tenant_id=$1 AND id = ANY($2) AND language IS NOT NULL`, tenant, ids)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    for rows.Next() {
+        var id, l string
+        if err := rows.Scan(&id, &l); err != nil { return nil, err }
+        out[id] = l
+    }
+    return out, rows.Err()
 }
 
-// TODO: Add method to return inserted id; simplified for now.
-
-func (r *Repository) SimilarChunks(ctx context.Context, query []float32, topK int) ([]struct{ID int64; DocID string; Content string; Score float32}, error) {
-    rows, err := r.DB.Pool.Query(ctx, `
-        SELECT id, document_id, content, 1 - (embedding <#> $1) AS score
-        FROM chunks WHERE embedding IS NOT NULL
-        ORDER BY embedding <-> $1
-        LIMIT $2`, query, topK)
+// MatchDocuments returns ids of the tenant's documents whose id or title
+// contains term (case-insensitive), e.g. the documents of one company.
+func (r *Repository) MatchDocuments(ctx context.Context, tenant, term string, limit int) ([]string, error) {
+    pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%"
+    rows, err := r.DB.Pool.Query(ctx, `SELECT id FROM documents WHERE tenant_id=$1 AND (id ILIKE $2 OR title ILIKE $2) ORDER BY id LIMIT $3`, tenant, pattern, limit)
     if err != nil { return nil, err }
     defer rows.Close()
-    var res []struct{ID int64; DocID string; Content string; Score float32}
+    var out []string
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil { return nil, err }
+        out = append(out, id)
+    }
+    return out, rows.Err()
+}
+
+// DeleteDocument removes a document and its chunks, returning the chunk ids
+// so callers can drop them from vector indexes.
+func (r *Repository) DeleteDocument(ctx context.Context, tenant, id string) ([]int64, bool, error) {
+    tx, err := r.DB.Pool.Begin(ctx)
+    if err != nil { return nil, false, err }
+    defer tx.Rollback(ctx)
+    rows, err := tx.Query(ctx, `DELETE FROM chunks WHERE tenant_id=$1 AND document_id=$2 RETURNING id`, tenant, id)
+    if err != nil { return nil, false, err }
+    var ids []int64
     for rows.Next() {
-        var it struct{ID int64; DocID string; Content string; Score float32}
-        if err := rows.Scan(&it.ID, &it.DocID, &it.Content, &it.Score); err != nil { return nil, err }
-        res = append(res, it)
+        var cid int64
+        if err := rows.Scan(&cid); err != nil { rows.Close(); return nil, false, err }
+        ids = append(ids, cid)
     }
-    return res, rows.Err()
+    rows.Close()
+    if err := rows.Err(); err != nil { return nil, false, err }
+    tag, err := tx.Exec(ctx, `DELETE FROM documents WHERE tenant_id=$1 AND id=$2`, tenant, id)
+    if err != nil { return nil, false, err }
+    return ids, tag.RowsAffected() > 0, tx.Commit(ctx)
 }
 
-func (r *Repository) GetChunksByDocument(ctx context.Context, docID string, limit int) ([]string, error) {
+// InsertChunk stores a chunk and returns its id, which is also its vector id.
+// span is "start-end", the chunk's character offsets in the document, or "".
+func (r *Repository) InsertChunk(ctx context.Context, tenant, docID string, page int, span, content string) (int64, error) {
+    // Embedding được ghi riêng theo từng model (SetEmbeddings).
+    var id int64
+    err := r.DB.Pool.QueryRow(ctx, `INSERT INTO chunks(tenant_id,document_id,page,span,content,embedding)
+        VALUES($1,$2,$3,$4,$5,NULL) RETURNING id`, tenant, docID, page, span, content).Scan(&id)
+    return id, err
+}
+
+func (r *Repository) GetChunksByDocument(ctx context.Context, tenant, docID string, limit int) ([]string, error) {
     if limit <= 0 { limit = 10 }
     // Lấy các chunk MỚI NHẤT để phản ánh ngữ cảnh vừa ingest
-    rows, err := r.DB.Pool.Query(ctx, `SELECT content FROM chunks WHERE document_id=$1 ORDER BY id DESC LIMIT $2`, docID, limit)
+    rows, err := r.DB.Pool.Query(ctx, `SELECT content FROM chunks WHERE tenant_id=$1 AND document_id=$2 ORDER BY id DESC LIMIT $3`, tenant, docID, limit)
     if err != nil { return nil, err }
     defer rows.Close()
     var out []string
@@ -55,21 +98,52 @@ func (r *Repository) GetChunksByDocument(ctx context.Context, docID string, limi
     return out, rows.Err()
 }
 
-func (r *Repository) SimilarChunksByDoc(ctx context.Context, docID string, query []float32, topK int) ([]struct{ID int64; DocID string; Content string; Score float32}, error) {
-    rows, err := r.DB.Pool.Query(ctx, `
-        SELECT id, document_id, content, 1 - (embedding <#> $1) AS score
-        FROM chunks WHERE document_id=$2 AND embedding IS NOT NULL
-        ORDER BY embedding <-> $1
-        LIMIT $3`, query, docID, topK)
+// Hit is a retrieved chunk with where it comes from; Score is its
+// similarity to the query, set by the caller.
+type Hit struct {
+    ID      int64
+    DocID   string
+    Title   string
+    Page    int
+    Span    string
+    Content string
+    Score   float32
+}
+
+// GetChunksByIDs loads chunks returned by FAISS. Ids belonging to another
+// tenant are silently dropped, so a stale or foreign id can never leak content.
+// Results keep the order of ids.
+func (r *Repository) GetChunksByIDs(ctx context.Context, tenant string, ids []int64) ([]Hit, error) {
+    if len(ids) == 0 { return nil, nil }
+    rows, err := r.DB.Pool.Query(ctx, `SELECT c.id, c.document_id, COALESCE(d.title, ''), COALESCE(c.page, 0), COALESCE(c.span, ''), c.content
+        FROM chunks c JOIN documents d ON d.tenant_id = c.tenant_id AND d.id = c.document_id
+        WHERE c.tenant_id=$1 AND c.id = ANY($2)`, tenant, ids)
     if err != nil { return nil, err }
     defer rows.Close()
-    var res []struct{ID int64; DocID string; Content string; Score float32}
+    byID := make(map[int64]Hit, len(ids))
     for rows.Next() {
-        var it struct{ID int64; DocID string; Content string; Score float32}
-        if err := rows.Scan(&it.ID, &it.DocID, &it.Content, &it.Score); err != nil { return nil, err }
-        res = append(res, it)
+        var it Hit
+        if err := rows.Scan(&it.ID, &it.DocID, &it.Title, &it.Page, &it.Span, &it.Content); err != nil { return nil, err }
+        byID[it.ID] = it
     }
-    return res, rows.Err()
+    if err := rows.Err(); err != nil { return nil, err }
+    res := make([]Hit, 0, len(byID))
+    for _, id := range ids {
+        if it, ok := byID[id]; ok { res = append(res, it) }
+    }
+    return res, nil
 }
 
-
+// Tenants lists tenants that own at least one document.
+func (r *Repository) Tenants(ctx context.Context) ([]string, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT DISTINCT tenant_id FROM documents ORDER BY tenant_id`)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []string
+    for rows.Next() {
+        var t string
+        if err := rows.Scan(&t); err != nil { return nil, err }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
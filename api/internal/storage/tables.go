@@ -0,0 +1,77 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// Table is a table found in a document at ingest, stored as rows of cells.
+type Table struct {
+    ID         int64      `json:"id"`
+    DocumentID string     `json:"document_id"`
+    ChunkID    int64      `json:"chunk_id"`
+    Page       int        `json:"page,omitempty"`
+    Title      string     `json:"title,omitempty"`
+    Kind       string     `json:"kind"`
+    Header     []string   `json:"header,omitempty"`
+    Rows       [][]string `json:"rows"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+// InsertTable stores t with its rows and returns its id.
+func (r *Repository) InsertTable(ctx context.Context, tenant string, t Table) (int64, error) {
+    tx, err := r.DB.Pool.Begin(ctx)
+    if err != nil { return 0, err }
+    defer tx.Rollback(ctx)
+    var id int64
+    err = tx.QueryRow(ctx, `INSERT INTO document_tables(tenant_id, document_id, chunk_id, page, title, kind, header)
+        VALUES($1,$2,$3,$4,NULLIF($5,''),$6,$7) RETURNING id`, tenant, t.DocumentID, t.ChunkID, t.Page, t.Title, t.Kind, t.Header).Scan(&id)
+    if err != nil { return 0, err }
+    batch := &pgx.Batch{}
+    for i, row := range t.Rows {
+        batch.Queue(`INSERT INTO document_table_rows(table_id, row_index, cells) VALUES($1,$2,$3)`, id, i, row)
+    }
+    if err := tx.SendBatch(ctx, batch).Close(); err != nil { return 0, err }
+    return id, tx.Commit(ctx)
+}
+
+// DocumentTables lists the tables of a document in ingest order.
+func (r *Repository) DocumentTables(ctx context.Context, tenant, docID string) ([]Table, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT t.id, t.document_id, t.chunk_id, COALESCE(t.page, 0), COALESCE(t.title, ''), t.kind, t.header, t.created_at,
+        COALESCE(json_agg(r.cells ORDER BY r.row_index) FILTER (WHERE r.table_id IS NOT NULL), '[]')::text
+        FROM document_tables t LEFT JOIN document_table_rows r ON r.table_id = t.id
+        WHERE t.tenant_id=$1 AND t.document_id=$2
+        GROUP BY t.id ORDER BY t.id`, tenant, docID)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    out := []Table{}
+    for rows.Next() {
+        var t Table
+        var chunkID *int64
+        var cells string
+        if err := rows.Scan(&t.ID, &t.DocumentID, &chunkID, &t.Page, &t.Title, &t.Kind, &t.Header, &t.CreatedAt, &cells); err != nil { return nil, err }
+        if chunkID != nil { t.ChunkID = *chunkID }
+        if err := json.Unmarshal([]byte(cells), &t.Rows); err != nil { return nil, err }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
+
+// StatementTableChunks returns the chunks rendering a document's financial
+// statement tables (any kind but "other"), first ingested first.
+func (r *Repository) StatementTableChunks(ctx context.Context, tenant, docID string, limit int) ([]int64, error) {
+    rows, err := r.DB.Pool.Query(ctx, `SELECT chunk_id FROM document_tables
+        WHERE tenant_id=$1 AND document_id=$2 AND kind <> 'other' AND chunk_id IS NOT NULL ORDER BY id LIMIT $3`, tenant, docID, limit)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil { return nil, err }
+        out = append(out, id)
+    }
+    return out, rows.Err()
+}
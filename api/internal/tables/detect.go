@@ -0,0 +1,204 @@
+// Package tables finds tables in extracted document text (financial
+// statements are mostly tables) so they can be stored as rows and columns
+// and embedded as readable text instead of a flattened jumble of numbers.
+package tables
+
+import (
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// Table is a detected table. Start and End are the character (code point)
+// offsets of its lines in the text it was found in.
+type Table struct {
+    Title  string
+    Kind   string
+    Header []string
+    Rows   [][]string
+    Start  int
+    End    int
+}
+
+// Kinds of financial statements recognised by Classify.
+const (
+    BalanceSheet    = "balance_sheet"
+    IncomeStatement = "income_statement"
+    CashFlow        = "cash_flow"
+    Other           = "other"
+)
+
+var (
+    wideGap   = regexp.MustCompile(`\s{2,}|\t`)
+    separator = regexp.MustCompile(`^:?-{2,}:?$`)
+    numeric   = regexp.MustCompile(`^[(\-−+]?\d[\d.,\s]*%?\)?$|^[-–—]$`)
+    year      = regexp.MustCompile(`^(19|20)\d\d$`)
+)
+
+// line is one line of text split into cells; pipe tells a "| a | b |" row
+// from one aligned with tabs or runs of spaces.
+type line struct {
+    text      string
+    cells     []string
+    pipe      bool
+    separator bool
+    start     int
+    end       int
+}
+
+// Detect returns the tables in text, in order. A table is at least two
+// consecutive rows of two or more cells: pipe-delimited rows, or rows whose
+// columns are separated by tabs or several spaces and hold numbers.
+func Detect(text string) []Table {
+    var out []Table
+    lines := split(text)
+    for i := 0; i < len(lines); {
+        if lines[i].cells == nil { i++; continue }
+        j := i + 1
+        for j < len(lines) && lines[j].cells != nil && lines[j].pipe == lines[i].pipe { j++ }
+        if t, ok := build(lines[i:j]); ok {
+            t.Title = title(lines, i)
+            t.Kind = Classify(t.Title, t.Rows)
+            out = append(out, t)
+        }
+        i = j
+    }
+    return out
+}
+
+// Strip returns text without the lines of the given tables (from Detect on
+// the same text), keeping their titles with the surrounding prose.
+func Strip(text string, found []Table) string {
+    runes := []rune(text)
+    var b strings.Builder
+    at := 0
+    for _, t := range found {
+        b.WriteString(string(runes[at:t.Start]))
+        at = t.End
+    }
+    b.WriteString(string(runes[at:]))
+    return strings.TrimSpace(b.String())
+}
+
+func split(text string) []line {
+    var out []line
+    pos := 0
+    for _, raw := range strings.SplitAfter(text, "\n") {
+        n := len([]rune(raw))
+        l := parse(strings.TrimRight(raw, "\r\n"))
+        l.text, l.start, l.end = raw, pos, pos+n
+        out = append(out, l)
+        pos += n
+    }
+    return out
+}
+
+func parse(s string) line {
+    s = strings.TrimSpace(s)
+    if strings.Count(s, "|") >= 2 {
+        cells := strings.Split(strings.Trim(s, "|"), "|")
+        sep := true
+        for i := range cells {
+            cells[i] = strings.TrimSpace(cells[i])
+            if !separator.MatchString(cells[i]) { sep = false }
+        }
+        if len(cells) >= 2 { return line{cells: cells, pipe: true, separator: sep} }
+    }
+    cells := wideGap.Split(s, -1)
+    if len(cells) < 2 { return line{} }
+    for _, c := range cells[1:] {
+        if numeric.MatchString(c) { return line{cells: cells} }
+    }
+    return line{}
+}
+
+func build(lines []line) (Table, bool) {
+    t := Table{Start: lines[0].start, End: lines[len(lines)-1].end}
+    width := 0
+    for _, l := range lines {
+        if !l.separator { width = max(width, len(l.cells)) }
+    }
+    for i, l := range lines {
+        if l.separator {
+            // A separator under the first row makes it the header.
+            if i == 1 && len(t.Rows) == 1 { t.Header, t.Rows = t.Rows[0], nil }
+            continue
+        }
+        row := append(l.cells, make([]string, width-len(l.cells))...)
+        t.Rows = append(t.Rows, row)
+    }
+    if t.Header == nil && len(t.Rows) > 0 && isHeader(t.Rows[0]) { t.Header, t.Rows = t.Rows[0], t.Rows[1:] }
+    return t, len(t.Rows) >= 2 || (t.Header != nil && len(t.Rows) >= 1)
+}
+
+// isHeader: a first row without figures other than years ("Chỉ tiêu
+// 2023 2022") labels the columns.
+func isHeader(row []string) bool {
+    labels := 0
+    for _, c := range row[1:] {
+        if c == "" { continue }
+        if numeric.MatchString(c) && !year.MatchString(c) { return false }
+        labels++
+    }
+    return labels > 0
+}
+
+// title is the closest non-empty line above the table, if it is prose.
+func title(lines []line, i int) string {
+    for k := i - 1; k >= 0 && k >= i-2; k-- {
+        if lines[k].cells != nil { return "" }
+        if s := strings.TrimSpace(lines[k].text); s != "" { return s }
+    }
+    return ""
+}
+
+var kindWords = []struct{ kind string; words []string }{
+    {BalanceSheet, []string{"cân đối kế toán", "balance sheet", "financial position", "tổng tài sản", "total assets", "nguồn vốn"}},
+    {IncomeStatement, []string{"kết quả kinh doanh", "kết quả hoạt động kinh doanh", "income statement", "profit or loss", "doanh thu thuần", "net revenue", "lợi nhuận sau thuế"}},
+    {CashFlow, []string{"lưu chuyển tiền", "cash flow"}},
+}
+
+// Classify guesses the statement a table belongs to from its title and row
+// labels.
+func Classify(title string, rows [][]string) string {
+    var b strings.Builder
+    b.WriteString(strings.ToLower(title))
+    for _, r := range rows {
+        if len(r) > 0 { b.WriteString("\n" + strings.ToLower(r[0])) }
+    }
+    text := b.String()
+    for _, k := range kindWords {
+        for _, w := range k.words {
+            if strings.Contains(text, w) { return k.kind }
+        }
+    }
+    return Other
+}
+
+// Render writes a table as text for embedding and prompts: the title, then
+// one line per row pairing each value with its column, e.g.
+// "Doanh thu thuần — 2023: 1.234; 2022: 1.100".
+func Render(t Table) string {
+    var b strings.Builder
+    if t.Title != "" { b.WriteString(t.Title + "\n") }
+    for _, row := range t.Rows {
+        label, values := row[0], row[1:]
+        b.WriteString(label)
+        sep := " — "
+        if label == "" { sep = "" }
+        for i, v := range values {
+            if v == "" { continue }
+            b.WriteString(sep)
+            if h := column(t.Header, i+1); h != "" { b.WriteString(h + ": ") }
+            b.WriteString(v)
+            sep = "; "
+        }
+        b.WriteString("\n")
+    }
+    return strings.TrimRightFunc(b.String(), unicode.IsSpace)
+}
+
+func column(header []string, i int) string {
+    if i < len(header) { return header[i] }
+    return ""
+}
@@ -0,0 +1,219 @@
+// Package upstream wraps calls to the services the API depends on (Ollama,
+// FAISS) with status-aware errors, jittered retries and a circuit breaker.
+package upstream
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/hiepdt/contest/services/api/internal/metrics"
+)
+
+// ErrOpen is returned without calling the upstream while its breaker is open.
+var ErrOpen = errors.New("circuit open")
+
+// StatusError is a non-2xx response. Message is the upstream's error text.
+type StatusError struct {
+    Upstream string
+    Op       string
+    Status   int
+    Message  string
+    // RetryAfter is the server's Retry-After hint, if any.
+    RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+    return fmt.Sprintf("%s %s status %d: %s", e.Upstream, e.Op, e.Status, e.Message)
+}
+
+// Temporary reports statuses worth retrying: overload, a model still loading
+// and gateway errors. Other 4xx/5xx responses would fail the same way again.
+func (e *StatusError) Temporary() bool {
+    switch e.Status {
+    case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+        http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+        return true
+    }
+    return false
+}
+
+// NewStatusError builds a StatusError from resp; message is the already
+// extracted error text of the body.
+func NewStatusError(upstream, op string, resp *http.Response, message string) *StatusError {
+    e := &StatusError{Upstream: upstream, Op: op, Status: resp.StatusCode, Message: message}
+    if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+        e.RetryAfter = time.Duration(s) * time.Second
+    }
+    return e
+}
+
+// retryable reports whether err is a transient upstream failure: a temporary
+// status, a network error or a per-attempt timeout. Call checks the caller's
+// context first, so its cancellation or deadline never gets here.
+func retryable(err error) bool {
+    if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrOpen) { return false }
+    var se *StatusError
+    if errors.As(err, &se) { return se.Temporary() }
+    var ne net.Error
+    return errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Policy is a retry policy with full-jitter exponential backoff.
+type Policy struct {
+    MaxAttempts int
+    BaseDelay   time.Duration
+    MaxDelay    time.Duration
+}
+
+func (p Policy) delay(attempt int, err error) time.Duration {
+    d := p.BaseDelay << attempt
+    if d <= 0 || d > p.MaxDelay { d = p.MaxDelay }
+    d = time.Duration(rand.Int63n(int64(d) + 1))
+    var se *StatusError
+    if errors.As(err, &se) && se.RetryAfter > d { d = min(se.RetryAfter, p.MaxDelay) }
+    return d
+}
+
+// Breaker opens after Failures consecutive upstream failures and rejects
+// calls for Cooldown; then one trial call decides whether it closes again.
+type Breaker struct {
+    Name     string
+    Failures int
+    Cooldown time.Duration
+
+    mu       sync.Mutex
+    state    State
+    failures int
+    openedAt time.Time
+    trial    bool
+}
+
+type State int
+
+const (
+    Closed State = iota
+    Open
+    HalfOpen
+)
+
+func (s State) String() string {
+    switch s {
+    case Open: return "open"
+    case HalfOpen: return "half_open"
+    }
+    return "closed"
+}
+
+func (b *Breaker) allow() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.state == Open && time.Since(b.openedAt) >= b.Cooldown { b.setLocked(HalfOpen) }
+    switch b.state {
+    case Open:
+        return fmt.Errorf("%s: %w", b.Name, ErrOpen)
+    case HalfOpen:
+        if b.trial { return fmt.Errorf("%s: %w", b.Name, ErrOpen) }
+        b.trial = true
+    }
+    return nil
+}
+
+// record counts only upstream failures; client errors and cancellations say
+// nothing about the upstream's health.
+func (b *Breaker) record(err error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.trial = false
+    if !retryable(err) {
+        // Any response, even an error status, shows the upstream is up.
+        var se *StatusError
+        if err == nil || errors.As(err, &se) { b.failures = 0; b.setLocked(Closed) }
+        return
+    }
+    metrics.UpstreamFailuresTotal.WithLabelValues(b.Name).Inc()
+    b.failures++
+    if b.state == HalfOpen || b.failures >= b.Failures {
+        b.openedAt = time.Now()
+        b.setLocked(Open)
+    }
+}
+
+// abandon ends an attempt the caller gave up on without counting it either
+// way, freeing the half-open trial for the next call.
+func (b *Breaker) abandon() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.trial = false
+}
+
+func (b *Breaker) setLocked(s State) {
+    b.state = s
+    metrics.UpstreamCircuitState.WithLabelValues(b.Name).Set(float64(s))
+}
+
+// State returns the current state, moving an expired open breaker to
+// half-open.
+func (b *Breaker) State() State {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.state == Open && time.Since(b.openedAt) >= b.Cooldown { return HalfOpen }
+    return b.state
+}
+
+// Guard applies a retry policy and a breaker to calls to one upstream.
+type Guard struct {
+    Retry   Policy
+    Breaker *Breaker
+}
+
+func NewGuard(name string, retry Policy, failures int, cooldown time.Duration) *Guard {
+    b := &Breaker{Name: name, Failures: failures, Cooldown: cooldown}
+    metrics.UpstreamCircuitState.WithLabelValues(name).Set(float64(Closed))
+    return &Guard{Retry: retry, Breaker: b}
+}
+
+// DefaultGuard is used by clients that were not given one.
+func DefaultGuard(name string) *Guard {
+    return NewGuard(name, Policy{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}, 5, 30*time.Second)
+}
+
+// Call runs fn, retrying transient failures while ctx allows. Every attempt
+// passes the breaker, so an upstream that went down stops being retried.
+// An attempt that fails once ctx is done is returned as is: the caller's
+// cancellation or deadline is neither retried nor held against the upstream.
+func (g *Guard) Call(ctx context.Context, fn func(context.Context) error) error {
+    attempts := max(g.Retry.MaxAttempts, 1)
+    var err error
+    for attempt := 0; attempt < attempts; attempt++ {
+        if attempt > 0 {
+            metrics.UpstreamRetriesTotal.WithLabelValues(g.Breaker.Name).Inc()
+            t := time.NewTimer(g.Retry.delay(attempt-1, err))
+            select {
+            case <-ctx.Done():
+                t.Stop()
+                return err
+            case <-t.C:
+            }
+        }
+        if open := g.Breaker.allow(); open != nil {
+            // Opened by our own failures: report what actually went wrong.
+            if err != nil { return err }
+            return open
+        }
+        err = fn(ctx)
+        if err != nil && ctx.Err() != nil {
+            g.Breaker.abandon()
+            return err
+        }
+        g.Breaker.record(err)
+        if !retryable(err) { return err }
+    }
+    return err
+}
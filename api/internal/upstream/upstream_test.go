@@ -0,0 +1,47 @@
+package upstream
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestCallStopsWhenCallerGivesUp(t *testing.T) {
+    tests := []struct {
+        name string
+        ctx  func() (context.Context, context.CancelFunc)
+    }{
+        {"cancelled", func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }},
+        {"deadline", func() (context.Context, context.CancelFunc) { return context.WithTimeout(context.Background(), time.Millisecond) }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            g := NewGuard("test", Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, 1, time.Minute)
+            ctx, cancel := tt.ctx()
+            defer cancel()
+            calls := 0
+            err := g.Call(ctx, func(ctx context.Context) error {
+                calls++
+                cancel()
+                <-ctx.Done()
+                return ctx.Err()
+            })
+            if err == nil || calls != 1 { t.Fatalf("err = %v, calls = %d, want the caller's error after one call", err, calls) }
+            if s := g.Breaker.State(); s != Closed { t.Errorf("breaker %v after the caller gave up, want closed", s) }
+        })
+    }
+}
+
+func TestCallCountsAttemptTimeouts(t *testing.T) {
+    g := NewGuard("test", Policy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, 2, time.Minute)
+    calls := 0
+    err := g.Call(context.Background(), func(ctx context.Context) error {
+        calls++
+        ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+        defer cancel()
+        <-ctx.Done()
+        return ctx.Err()
+    })
+    if err == nil || calls != 2 { t.Fatalf("err = %v, calls = %d, want a timeout after 2 attempts", err, calls) }
+    if s := g.Breaker.State(); s != Open { t.Errorf("breaker %v after 2 attempt timeouts, want open", s) }
+}
@@ -1,58 +1,405 @@
-from fastapi import FastAPI
+import json
+import os
+import re
+import threading
+import time
+from contextlib import asynccontextmanager
+
+from fastapi import FastAPI, HTTPException
 from pydantic import BaseModel
 import faiss
 import numpy as np
 
-app = FastAPI()
+# Indexes are persisted here (<collection>/<tenant>.faiss, .docs.json and
+# .spec.json each) so they survive container restarts.
+INDEX_DIR = os.environ.get("INDEX_DIR", "/data")
+SAVE_INTERVAL = float(os.environ.get("SAVE_INTERVAL", "30"))
+
+# One index per (collection, tenant): a collection holds the vectors of one
+# embedding model, and a search can never return another tenant's ids.
+Key = tuple[str, str]
+indexes: dict[Key, faiss.Index] = {}
+# key -> vector id -> document id, for filtered search.
+doc_of: dict[Key, dict[int, str]] = {}
+# key -> index spec, see build_index. Keys without one use exact search. The
+# spec also records the vector dimension once it is known.
+specs: dict[Key, dict] = {}
+# HNSW graphs can neither remove nor replace vectors, so theirs are stored
+# under internal labels: label_of maps a vector id to its current label and
+# ext_of back. Re-adding an id gives it a new label; the old label, like
+# those of removed ids, goes to removed and is excluded at search time until
+# the next rebuild. Other index types use the vector ids directly.
+label_of: dict[Key, dict[int, int]] = {}
+ext_of: dict[Key, dict[int, int]] = {}
+next_label: dict[Key, int] = {}
+removed: dict[Key, set[int]] = {}
+dirty: set[Key] = set()
+lock = threading.Lock()
+TENANT_RE = re.compile(r"^[A-Za-z0-9_-]{1,64}$")
+
+
+def index_path(key: Key) -> str:
+    return os.path.join(INDEX_DIR, key[0], key[1] + ".faiss")
+
+
+def docs_path(key: Key) -> str:
+    return os.path.join(INDEX_DIR, key[0], key[1] + ".docs.json")
+
+
+def spec_path(key: Key) -> str:
+    return os.path.join(INDEX_DIR, key[0], key[1] + ".spec.json")
+
+
+def build_index(spec: dict, dim: int) -> faiss.Index:
+    """Creates an empty index. type is flat (exact), ivfpq (needs training)
+    or hnsw; all use inner product on normalized vectors, i.e. cosine."""
+    kind = spec.get("type", "flat")
+    if kind == "flat":
+        base = faiss.IndexFlatIP(dim)
+    elif kind == "ivfpq":
+        quantizer = faiss.IndexFlatIP(dim)
+        base = faiss.IndexIVFPQ(quantizer, dim, spec.get("nlist", 1024), spec.get("m", 64),
+                                spec.get("nbits", 8), faiss.METRIC_INNER_PRODUCT)
+        base.nprobe = spec.get("nprobe", 16)
+    elif kind == "hnsw":
+        base = faiss.IndexHNSWFlat(dim, spec.get("hnsw_m", 32), faiss.METRIC_INNER_PRODUCT)
+        base.hnsw.efConstruction = spec.get("ef_construction", 200)
+        base.hnsw.efSearch = spec.get("ef_search", 64)
+    else:
+        raise HTTPException(status_code=400, detail="unknown index type " + kind)
+    return faiss.IndexIDMap(base)
+
+
+def check_sample(spec: dict, n: int):
+    """Rejects a training sample too small for the spec: IVF k-means needs
+    at least nlist points and each PQ codebook 2^nbits."""
+    nlist, nbits = spec.get("nlist", 1024), spec.get("nbits", 8)
+    if n < nlist:
+        raise HTTPException(status_code=400, detail=f"training sample has {n} vectors, need at least nlist={nlist}")
+    if n < 2 ** nbits:
+        raise HTTPException(status_code=400, detail=f"training sample has {n} vectors, need at least 2^nbits={2 ** nbits}")
+
+
+def normalized(rows: list[list[float]], dim: int = 0) -> np.ndarray:
+    xb = np.array(rows, dtype="float32")
+    if xb.ndim != 2 or xb.shape[1] == 0 or (dim and xb.shape[1] != dim):
+        raise HTTPException(status_code=400, detail="vector dim mismatch")
+    faiss.normalize_L2(xb)
+    return xb
+
+
+def save_key(key: Key):
+    # Caller holds lock. Write to temp files then rename so a crash mid-save
+    # never leaves a truncated index behind.
+    os.makedirs(os.path.join(INDEX_DIR, key[0]), exist_ok=True)
+    faiss.write_index(indexes[key], index_path(key) + ".tmp")
+    with open(docs_path(key) + ".tmp", "w") as f:
+        json.dump({"docs": {str(k): v for k, v in doc_of[key].items()},
+                   "removed": sorted(removed.get(key, ())),
+                   "labels": {str(k): v for k, v in label_of.get(key, {}).items()},
+                   "next_label": next_label.get(key, 0)}, f)
+    with open(spec_path(key) + ".tmp", "w") as f:
+        json.dump(specs.get(key, {"type": "flat"}), f)
+    os.replace(index_path(key) + ".tmp", index_path(key))
+    os.replace(docs_path(key) + ".tmp", docs_path(key))
+    os.replace(spec_path(key) + ".tmp", spec_path(key))
+
+
+def save_dirty():
+    with lock:
+        for key in list(dirty):
+            save_key(key)
+            dirty.discard(key)
+
+
+def load_all():
+    os.makedirs(INDEX_DIR, exist_ok=True)
+    for collection in os.listdir(INDEX_DIR):
+        cdir = os.path.join(INDEX_DIR, collection)
+        if not TENANT_RE.match(collection) or not os.path.isdir(cdir):
+            continue
+        for name in os.listdir(cdir):
+            if not name.endswith(".faiss"):
+                continue
+            key = (collection, name[: -len(".faiss")])
+            if not TENANT_RE.match(key[1]):
+                continue
+            indexes[key] = faiss.read_index(index_path(key))
+            meta = {}
+            if os.path.exists(docs_path(key)):
+                with open(docs_path(key)) as f:
+                    meta = json.load(f)
+            doc_of[key] = {int(k): v for k, v in meta.get("docs", {}).items()}
+            specs[key] = {"type": "flat"}
+            if os.path.exists(spec_path(key)):
+                with open(spec_path(key)) as f:
+                    specs[key] = json.load(f)
+            specs[key]["dim"] = indexes[key].d
+            reset_labels(key)
+            removed[key] = set(meta.get("removed", []))
+            if is_hnsw(key):
+                # Indexes saved before labels existed used the ids as labels.
+                labels = meta.get("labels")
+                label_of[key] = {int(k): v for k, v in labels.items()} if labels is not None else {i: i for i in doc_of[key]}
+                ext_of[key] = {v: k for k, v in label_of[key].items()}
+                used = max([*label_of[key].values(), *removed[key]], default=-1) + 1
+                next_label[key] = max(meta.get("next_label", 0), used)
+
+
+def saver():
+    while True:
+        time.sleep(SAVE_INTERVAL)
+        try:
+            save_dirty()
+        except Exception as e:  # keep the loop alive; next tick retries
+            print("faiss save failed:", e, flush=True)
+
+
+@asynccontextmanager
+async def lifespan(app: FastAPI):
+    load_all()
+    threading.Thread(target=saver, daemon=True).start()
+    yield
+    save_dirty()
 
-# Cosine similarity via inner product on normalized vectors
-dim = 768
-index = faiss.IndexFlatIP(dim)
+
+app = FastAPI(lifespan=lifespan)
+
+
+def index_key(collection: str, tenant: str) -> Key:
+    if not TENANT_RE.match(tenant):
+        raise HTTPException(status_code=400, detail="invalid tenant")
+    if not TENANT_RE.match(collection):
+        raise HTTPException(status_code=400, detail="invalid collection")
+    return (collection, tenant)
+
+
+def get_index(key: Key, dim: int = 0) -> faiss.Index | None:
+    """Returns the index for key, creating an exact one of the given
+    dimension if it does not exist yet and dim is set."""
+    with lock:
+        idx = indexes.get(key)
+        if idx is None and dim:
+            specs[key] = {"type": "flat", "dim": dim}
+            idx = build_index(specs[key], dim)
+            indexes[key] = idx
+            doc_of[key] = {}
+            reset_labels(key)
+        return idx
+
+
+def is_hnsw(key: Key) -> bool:
+    return specs.get(key, {}).get("type") == "hnsw"
+
+
+def reset_labels(key: Key):
+    label_of[key], ext_of[key], next_label[key], removed[key] = {}, {}, 0, set()
+
+
+def retire(key: Key, vid: int) -> bool:
+    """Moves the current label of an HNSW vector id to removed. Caller holds
+    lock."""
+    label = label_of[key].pop(vid, None)
+    if label is None:
+        return False
+    del ext_of[key][label]
+    removed[key].add(label)
+    return True
+
+
+def search_params(key: Key, sel, nprobe: int, ef_search: int):
+    kind = specs.get(key, {}).get("type", "flat")
+    if kind == "ivfpq" and nprobe > 0:
+        return faiss.SearchParametersIVF(sel=sel, nprobe=nprobe)
+    if kind == "hnsw" and ef_search > 0:
+        return faiss.SearchParametersHNSW(sel=sel, efSearch=ef_search)
+    if sel is None:
+        return None
+    return faiss.SearchParameters(sel=sel)
 
 class AddItem(BaseModel):
     id: int
+    doc_id: str = ""
     vector: list[float]
 
 class AddRequest(BaseModel):
+    tenant: str = "default"
+    collection: str = "default"
     items: list[AddItem]
 
 class SearchRequest(BaseModel):
+    tenant: str = "default"
+    collection: str = "default"
     vector: list[float]
     top_k: int = 5
+    doc_ids: list[str] = []
+    # Per-query overrides for approximate indexes; 0 keeps the index default.
+    nprobe: int = 0
+    ef_search: int = 0
+
+class TrainRequest(BaseModel):
+    tenant: str = "default"
+    collection: str = "default"
+    # type: flat | ivfpq | hnsw, plus nlist, m, nbits, nprobe (ivfpq) or
+    # hnsw_m, ef_construction, ef_search (hnsw).
+    spec: dict
+    vectors: list[list[float]] = []
+
+class RemoveRequest(BaseModel):
+    tenant: str = "default"
+    collection: str = "default"
+    ids: list[int]
+
+class ResetRequest(BaseModel):
+    tenant: str = "default"
+    collection: str = "default"
 
 @app.post("/add")
 def add_vectors(req: AddRequest):
     if not req.items:
         return {"added": 0}
-    vecs = []
-    ids = []
-    for it in req.items:
-        v = np.array(it.vector, dtype="float32")
-        if v.shape[0] != dim:
-            raise ValueError("vector dim mismatch")
-        # normalize for cosine
-        n = np.linalg.norm(v)
-        if n > 0:
-            v = v / n
-        vecs.append(v)
-        ids.append(it.id)
-    xb = np.stack(vecs, axis=0)
-    index.add_with_ids(xb, np.array(ids, dtype="int64"))
+    key = index_key(req.collection, req.tenant)
+    # normalize for cosine; the first add fixes the collection's dimension
+    xb = normalized([it.vector for it in req.items])
+    index = get_index(key, dim=xb.shape[1])
+    if index.d != xb.shape[1]:
+        raise HTTPException(status_code=400, detail="vector dim mismatch")
+    ids = [it.id for it in req.items]
+    id_arr = np.array(ids, dtype="int64")
+    with lock:
+        if not index.is_trained:
+            raise HTTPException(status_code=409, detail="index not trained")
+        # Re-adding an id replaces it instead of creating a duplicate.
+        if is_hnsw(key):
+            for vid in ids:
+                retire(key, vid)
+                label_of[key][vid] = next_label[key]
+                ext_of[key][next_label[key]] = vid
+                next_label[key] += 1
+            id_arr = np.array([label_of[key][vid] for vid in ids], dtype="int64")
+        else:
+            index.remove_ids(id_arr)
+        index.add_with_ids(xb, id_arr)
+        docs = doc_of[key]
+        for it in req.items:
+            docs[it.id] = it.doc_id
+        dirty.add(key)
     return {"added": len(ids)}
 
+@app.post("/train")
+def train(req: TrainRequest):
+    """Replaces the index with an empty one of the requested type, trained on
+    the sample if it needs training. Vectors must be re-added. The dimension
+    comes from the sample, spec["dim"] or the current index."""
+    key = index_key(req.collection, req.tenant)
+    sample = normalized(req.vectors) if req.vectors else None
+    current = get_index(key)
+    dim = sample.shape[1] if sample is not None else req.spec.get("dim") or (current.d if current else 0)
+    if not dim:
+        raise HTTPException(status_code=400, detail="vector dim unknown")
+    spec = {**req.spec, "dim": dim}
+    index = build_index(spec, dim)
+    if not index.is_trained:
+        if sample is None:
+            raise HTTPException(status_code=400, detail="training sample required")
+        check_sample(spec, len(sample))
+        index.train(sample)
+    with lock:
+        indexes[key] = index
+        specs[key] = spec
+        doc_of[key] = {}
+        reset_labels(key)
+        dirty.add(key)
+    return {"trained": True, "spec": spec, "sample": len(req.vectors)}
+
+@app.post("/remove")
+def remove_vectors(req: RemoveRequest):
+    key = index_key(req.collection, req.tenant)
+    index = get_index(key)
+    if index is None or not req.ids:
+        return {"removed": 0}
+    with lock:
+        docs = doc_of[key]
+        if is_hnsw(key):
+            count = sum(retire(key, i) for i in set(req.ids))
+        else:
+            count = int(index.remove_ids(np.array(req.ids, dtype="int64")))
+        for i in req.ids:
+            docs.pop(i, None)
+        dirty.add(key)
+    return {"removed": count}
+
+@app.post("/reset")
+def reset(req: ResetRequest):
+    key = index_key(req.collection, req.tenant)
+    index = get_index(key)
+    if index is None:
+        return {"reset": True}
+    with lock:
+        # reset() keeps training (IVF centroids, PQ codebooks), so a trained
+        # index can be refilled directly.
+        index.reset()
+        doc_of[key] = {}
+        reset_labels(key)
+        dirty.add(key)
+    return {"reset": True}
+
+@app.post("/save")
+def save():
+    save_dirty()
+    return {"saved": True}
+
+@app.get("/stats")
+def stats(tenant: str | None = None, collection: str = "default"):
+    if tenant is not None:
+        key = index_key(collection, tenant)
+        index = get_index(key)
+        if index is None:
+            return {"tenant": tenant, "collection": collection, "count": 0, "dim": 0, "spec": {"type": "flat"}}
+        return {"tenant": tenant, "collection": collection, "count": len(doc_of[key]),
+                "dim": index.d, "spec": specs.get(key)}
+    with lock:
+        out: dict[str, dict[str, int]] = {}
+        for (c, t) in indexes:
+            out.setdefault(c, {})[t] = len(doc_of[(c, t)])
+        return {"collections": out}
+
 @app.post("/search")
 def search(req: SearchRequest):
-    v = np.array(req.vector, dtype="float32")
-    n = np.linalg.norm(v)
-    if n>0:
-        v = v / n
-    v = v.reshape(1,-1)
-    scores, ids = index.search(v, req.top_k)
-    res = []
-    for i in range(ids.shape[1]):
-        if ids[0, i] == -1:
-            continue
-        res.append({"id": int(ids[0,i]), "score": float(scores[0,i])})
+    if req.nprobe < 0 or req.ef_search < 0:
+        raise HTTPException(status_code=400, detail="nprobe and ef_search must not be negative")
+    key = index_key(req.collection, req.tenant)
+    index = get_index(key)
+    if index is None or index.ntotal == 0:
+        return {"results": []}
+    v = normalized([req.vector], index.d)
+    with lock:
+        docs = doc_of.get(key, {})
+        hnsw = is_hnsw(key)
+        # HNSW labels map back to vector ids; a label no longer current
+        # (replaced or removed) has no entry and is dropped.
+        ext = ext_of[key] if hnsw else None
+        sel = None
+        if req.doc_ids:
+            wanted = set(req.doc_ids)
+            allowed = [label_of[key][i] if hnsw else i for i, d in docs.items() if d in wanted]
+            if not allowed:
+                return {"results": []}
+            sel = faiss.IDSelectorBatch(np.array(allowed, dtype="int64"))
+        elif removed.get(key):
+            sel = faiss.IDSelectorNot(faiss.IDSelectorBatch(np.array(sorted(removed[key]), dtype="int64")))
+        params = search_params(key, sel, req.nprobe, req.ef_search)
+        scores, ids = index.search(v, req.top_k, params=params)
+        res = []
+        # Indexes saved before labels existed may still hold a replaced
+        # vector under its id; keep the best hit of each.
+        seen = set()
+        for i in range(ids.shape[1]):
+            vid = int(ids[0, i])
+            if hnsw:
+                vid = ext.get(vid, -1)
+            if vid == -1 or vid in seen or vid not in docs:
+                continue
+            seen.add(vid)
+            res.append({"id": vid, "doc_id": docs[vid], "score": float(scores[0, i])})
     return {"results": res}
-
-